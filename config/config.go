@@ -3,15 +3,21 @@ package config
 import (
 	"fmt"
 	"github.com/ilyakaznacheev/cleanenv"
+	"time"
 )
 
 type Config struct {
-	HTTP  HTTP
-	Log   Log
-	PG    PG
-	Redis Redis
-	JWT   JWT
-	Kafka Kafka
+	HTTP        HTTP
+	Log         Log
+	PG          PG
+	Redis       Redis
+	Cache       Cache
+	JWT         JWT
+	Kafka       Kafka
+	Outbox      Outbox
+	Reservation Reservation
+	Snapshot    Snapshot
+	Report      Report
 }
 
 type (
@@ -27,16 +33,38 @@ type (
 		Url         string `env-required:"true" env:"PG_URL"`
 	}
 	Redis struct {
-		Url      string `env-required:"true" env:"REDIS_URL"`
+		Url      string `env:"REDIS_URL"`
 		Password string `env:"REDIS_PASSWORD"`
 	}
+	Cache struct {
+		Backend    string `env:"CACHE_BACKEND" env-default:"redis"` // redis или memory
+		MaxEntries int    `env:"CACHE_MAX_ENTRIES" env-default:"100000"`
+	}
 	JWT struct {
 		PrivateKey string `env-required:"true" env:"JWT_PRIVATE_KEY"`
 		PublicKey  string `env-required:"true" env:"JWT_PUBLIC_KEY"`
+		AdminKey   string `env:"ADMIN_KEY"` // ключ для получения админского токена, пустой - админский токен не выдается
 	}
 	Kafka struct {
 		Url string `env-required:"true" env:"KAFKA_URL"`
 	}
+	Outbox struct {
+		RelayInterval time.Duration `env:"OUTBOX_RELAY_INTERVAL" env-default:"1s"`
+		Retention     time.Duration `env:"OUTBOX_RETENTION" env-default:"72h"`
+	}
+	Reservation struct {
+		TTL            time.Duration `env:"RESERVATION_TTL" env-default:"0"` // 0 - резервирования без срока
+		ExpiryInterval time.Duration `env:"RESERVATION_EXPIRY_INTERVAL" env-default:"1m"`
+	}
+	Snapshot struct {
+		Interval time.Duration `env:"SNAPSHOT_INTERVAL" env-default:"1h"` // как часто проверять, не пора ли снять балансы
+		Delay    time.Duration `env:"SNAPSHOT_DELAY" env-default:"5m"`    // ожидание после конца дня, пока закоммитятся начатые транзакции
+	}
+	Report struct {
+		StorageDir  string        `env:"REPORT_STORAGE_DIR" env-default:"reports"` // каталог для файлов фоновых отчетов
+		JobInterval time.Duration `env:"REPORT_JOB_INTERVAL" env-default:"5s"`     // как часто проверять очередь отчетов
+		JobTimeout  time.Duration `env:"REPORT_JOB_TIMEOUT" env-default:"10m"`     // после этого задача считается брошенной
+	}
 )
 
 func NewConfig() (*Config, error) {
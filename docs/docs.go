@@ -22,7 +22,7 @@ const docTemplate = `{
                         "JWT": []
                     }
                 ],
-                "description": "Get balance for account by id",
+                "description": "Get balance for account by id in one currency (RUB by default): free balance, reserved by active reservations, their total, credit limit and available amount.\nWith as_of returns service.BalanceAsOfOutput - free balance at that moment computed from the ledger. Date without time means end of that day (UTC)",
                 "consumes": [
                     "application/json"
                 ],
@@ -33,6 +33,67 @@ const docTemplate = `{
                     "account"
                 ],
                 "summary": "Get balance",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user id",
+                        "name": "user_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "currency code (ISO 4217)",
+                        "name": "currency",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "moment in the past, RFC 3339 or date (2006-01-02)",
+                        "name": "as_of",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.BalanceOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/accounts/balances": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Get account balances in all currencies",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "account"
+                ],
+                "summary": "Get balances",
                 "parameters": [
                     {
                         "type": "string",
@@ -46,7 +107,10 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/internal_api_v1.balanceResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/avito_intership_internal_service.BalanceOutput"
+                            }
                         }
                     },
                     "400": {
@@ -139,6 +203,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/internal_api_v1.accountDepositInput"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "idempotency key, repeated request with the same key and body returns stored result",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -151,6 +221,76 @@ const docTemplate = `{
                             "$ref": "#/definitions/echo.HTTPError"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/accounts/holds": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Get money held by active reservations of account, grouped by order and currency. Without currency returns holds in all currencies",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "account"
+                ],
+                "summary": "Get holds",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user id",
+                        "name": "user_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "currency code (ISO 4217)",
+                        "name": "currency",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/avito_intership_internal_service.HoldOutput"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -167,7 +307,7 @@ const docTemplate = `{
                         "JWT": []
                     }
                 ],
-                "description": "Transfer from account to account",
+                "description": "Transfer from account to account. If currencies differ, amount is converted by stored exchange rate",
                 "consumes": [
                     "application/json"
                 ],
@@ -187,6 +327,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/internal_api_v1.accountTransferInput"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "idempotency key, repeated request with the same key and body returns stored result",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -199,6 +345,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/echo.HTTPError"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.limitExceededResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -235,6 +393,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/internal_api_v1.accountWithdrawInput"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "idempotency key, repeated request with the same key and body returns stored result",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -247,6 +411,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/echo.HTTPError"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.limitExceededResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -256,14 +432,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/v1/operations/history": {
-            "get": {
+        "/api/v1/admin/accounts/credit-limit": {
+            "post": {
                 "security": [
                     {
                         "JWT": []
                     }
                 ],
-                "description": "Get account transactions history",
+                "description": "Set how far balance in currency (RUB by default) can go below zero. Zero limit forbids negative balance. Limit cannot be less than current debt",
                 "consumes": [
                     "application/json"
                 ],
@@ -271,9 +447,9 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "operation"
+                    "admin"
                 ],
-                "summary": "Get history",
+                "summary": "Set credit limit",
                 "parameters": [
                     {
                         "description": "input",
@@ -281,7 +457,7 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/internal_api_v1.operationHistoryInput"
+                            "$ref": "#/definitions/internal_api_v1.adminSetCreditLimitInput"
                         }
                     }
                 ],
@@ -289,10 +465,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/avito_intership_internal_service.HistoryOutput"
-                            }
+                            "$ref": "#/definitions/avito_intership_internal_service.BalanceOutput"
                         }
                     },
                     "400": {
@@ -301,6 +474,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/echo.HTTPError"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -310,14 +495,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/v1/operations/report": {
+        "/api/v1/admin/accounts/status": {
             "get": {
                 "security": [
                     {
                         "JWT": []
                     }
                 ],
-                "description": "Get monthly report, ordered by products ids",
+                "description": "Get current account status and history of its changes, latest first",
                 "consumes": [
                     "application/json"
                 ],
@@ -325,23 +510,24 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "operation"
+                    "admin"
                 ],
-                "summary": "Get report",
+                "summary": "Get account status",
                 "parameters": [
                     {
-                        "description": "input",
-                        "name": "input",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/internal_api_v1.operationReportInput"
-                        }
+                        "type": "integer",
+                        "description": "user id",
+                        "name": "user_id",
+                        "in": "query",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK"
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.AccountStatusOutput"
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -356,16 +542,14 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/api/v1/reservations/cancel": {
-            "delete": {
+            },
+            "post": {
                 "security": [
                     {
                         "JWT": []
                     }
                 ],
-                "description": "cancel product amount reservation and return money to account",
+                "description": "Freeze, unfreeze or close account. Frozen account can only receive money, closed account takes no part in operations and cannot be reopened. Only empty account without active reservations can be closed",
                 "consumes": [
                     "application/json"
                 ],
@@ -373,9 +557,9 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "reservation"
+                    "admin"
                 ],
-                "summary": "cancel reservation",
+                "summary": "Set account status",
                 "parameters": [
                     {
                         "description": "input",
@@ -383,7 +567,7 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/internal_api_v1.reservationCancelInput"
+                            "$ref": "#/definitions/internal_api_v1.adminSetStatusInput"
                         }
                     }
                 ],
@@ -397,6 +581,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/echo.HTTPError"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -406,14 +596,63 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/v1/reservations/create": {
+        "/api/v1/admin/limits": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Get spending limits applied to account: its own and global ones. Without user_id returns only global limits",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get spending limits",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user id",
+                        "name": "user_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/avito_intership_internal_service.LimitOutput"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            },
             "post": {
                 "security": [
                     {
                         "JWT": []
                     }
                 ],
-                "description": "Create product amount reservation",
+                "description": "Create or replace spending limits in currency (RUB by default) for account or globally (without user_id). Omitted limit means no limit. Withdrawals, outgoing transfers and reservations are checked against both account and global limits, days and months are calendar",
                 "consumes": [
                     "application/json"
                 ],
@@ -421,9 +660,9 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "reservation"
+                    "admin"
                 ],
-                "summary": "create reservation",
+                "summary": "Set spending limits",
                 "parameters": [
                     {
                         "description": "input",
@@ -431,7 +670,7 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/internal_api_v1.reservationCreateInput"
+                            "$ref": "#/definitions/internal_api_v1.adminSetLimitInput"
                         }
                     }
                 ],
@@ -439,7 +678,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/internal_api_v1.reservationResponse"
+                            "$ref": "#/definitions/avito_intership_internal_service.LimitOutput"
                         }
                     },
                     "400": {
@@ -455,16 +694,14 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/api/v1/reservations/revenue": {
-            "post": {
+            },
+            "delete": {
                 "security": [
                     {
                         "JWT": []
                     }
                 ],
-                "description": "confirm reservation",
+                "description": "Remove all spending limits in currency (RUB by default) for account or global ones (without user_id)",
                 "consumes": [
                     "application/json"
                 ],
@@ -472,9 +709,9 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "reservation"
+                    "admin"
                 ],
-                "summary": "revenue reservation",
+                "summary": "Delete spending limits",
                 "parameters": [
                     {
                         "description": "input",
@@ -482,7 +719,7 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/internal_api_v1.reservationRevenueInput"
+                            "$ref": "#/definitions/internal_api_v1.adminDeleteLimitInput"
                         }
                     }
                 ],
@@ -504,32 +741,1794 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "avito_intership_internal_service.HistoryOutput": {
-            "type": "object",
-            "properties": {
-                "amount": {
-                    "type": "number"
-                },
-                "created_at": {
-                    "type": "string"
-                },
-                "operation_id": {
-                    "type": "integer"
-                },
-                "order_id": {
-                    "type": "integer"
+        },
+        "/api/v1/ledger/check": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Check ledger invariants: every journal entry and the whole ledger sum to zero, account balances and active reservations match postings. Admin token only",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ledger"
+                ],
+                "summary": "Check ledger",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.LedgerCheckOutput"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/operations/history": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Get account transactions history page filtered by types, created_at and amount ranges, product and order.\nSort is a comma separated list of created_at, amount, type, id with optional :asc or :desc (desc by default),\nnewest first if empty. Pass next_cursor from the response as cursor to get the next page,\nthe cursor is valid only for the same sort",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "operation"
+                ],
+                "summary": "Get history",
+                "parameters": [
+                    {
+                        "description": "input",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.operationHistoryInput"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.HistoryPageOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/operations/history/export": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Download the whole account transactions history as csv or ndjson (one history operation per line).\nFilters and sort are the same as in history, the file is streamed while operations are read",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "operation"
+                ],
+                "summary": "Export history",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user id",
+                        "name": "user_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "csv",
+                            "ndjson"
+                        ],
+                        "type": "string",
+                        "description": "csv (default) or ndjson",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "operation types",
+                        "name": "types",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 time or date, inclusive",
+                        "name": "created_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 time or date, exclusive",
+                        "name": "created_to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "min amount, inclusive",
+                        "name": "amount_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "max amount, inclusive",
+                        "name": "amount_to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "product id",
+                        "name": "product_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "order id",
+                        "name": "order_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "sort, e.g. created_at:asc",
+                        "name": "sort",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/operations/report": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Get monthly revenue report, ordered by products ids and currencies: revenue, number of revenue operations, refunds and net revenue.\nFormat is taken from the format parameter or the Accept header, csv by default. Admin token only",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "text/csv",
+                    "application/json",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+                ],
+                "tags": [
+                    "operation"
+                ],
+                "summary": "Get report",
+                "parameters": [
+                    {
+                        "description": "input",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.operationReportInput"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.ReportOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/operations/report/revenue": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Get revenue report for period grouped by day, week (from monday), month or quarter and currency, optionally by products and users:\nrevenue, number of revenue operations, refunds and net revenue. Period bounds are RFC 3339 time or date (2006-01-02), date in \"to\" means end of that day.\nFormat is taken from the format parameter or the Accept header, json by default. Admin token only",
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+                ],
+                "tags": [
+                    "operation"
+                ],
+                "summary": "Get revenue report",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "period start",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "period end",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "day",
+                            "week",
+                            "month",
+                            "quarter"
+                        ],
+                        "type": "string",
+                        "description": "grouping, month by default",
+                        "name": "period",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "group by products",
+                        "name": "by_product",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "group by users",
+                        "name": "by_user",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "xlsx"
+                        ],
+                        "type": "string",
+                        "description": "json, csv or xlsx",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.RevenueReportOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/operations/statement": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Get account statement in one currency (RUB by default) for period: opening balance, operations with balance after each and closing balance.\nPeriod bounds are RFC 3339 time or date (2006-01-02), date in \"to\" means end of that day. Balances exclude money held by active reservations",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/csv"
+                ],
+                "tags": [
+                    "operation"
+                ],
+                "summary": "Get statement",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user id",
+                        "name": "user_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "currency code (ISO 4217)",
+                        "name": "currency",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "period start",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "period end",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "json (default) or csv",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.StatementOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/rates/get": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Get stored exchange rate for currency pair",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rate"
+                ],
+                "summary": "Get exchange rate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "base currency",
+                        "name": "base",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "quote currency",
+                        "name": "quote",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.RateOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/rates/set": {
+            "post": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Create or update exchange rate: how many quote currency units are given for one base currency unit. Admin token only",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rate"
+                ],
+                "summary": "Set exchange rate",
+                "parameters": [
+                    {
+                        "description": "input",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.rateSetInput"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reports/jobs": {
+            "post": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Queue report generation in background, admin token only. Monthly report needs year and month, revenue report needs from and to (RFC 3339 time or date, date in \"to\" means end of that day),\nperiod and breakdowns as in the revenue report. If the same report is already queued, or is generated and its period is over, that job is returned with 200 instead of a new one",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "report"
+                ],
+                "summary": "Create report job",
+                "parameters": [
+                    {
+                        "description": "input",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.reportJobCreateInput"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.ReportJobOutput"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.ReportJobOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reports/jobs/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Get report job status: pending, running, done or failed. File of done job is available for download",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "report"
+                ],
+                "summary": "Get report job",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "job id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.ReportJobOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reports/jobs/{id}/file": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Download file of done report job in the format of the job",
+                "produces": [
+                    "text/csv",
+                    "application/json",
+                    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+                ],
+                "tags": [
+                    "report"
+                ],
+                "summary": "Download report",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "job id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reservations": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "List reservations by user, order or product, newest first. At least one of user_id, order_id, product_id is required",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservation"
+                ],
+                "summary": "List reservations",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user id",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "order id",
+                        "name": "order_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "product id",
+                        "name": "product_id",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "active",
+                            "cancelled",
+                            "recognized",
+                            "expired"
+                        ],
+                        "type": "string",
+                        "description": "status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "offset",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "limit, 20 at most",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/avito_intership_internal_service.ReservationOutput"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reservations/cancel": {
+            "delete": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "cancel product amount reservation and return money to account",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservation"
+                ],
+                "summary": "cancel reservation",
+                "parameters": [
+                    {
+                        "description": "input",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.reservationCancelInput"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reservations/create": {
+            "post": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Create product amount reservation. Reservation without expires_at expires after default service TTL (if configured)",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservation"
+                ],
+                "summary": "create reservation",
+                "parameters": [
+                    {
+                        "description": "input",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.reservationCreateInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "idempotency key, repeated request with the same key and body returns stored result",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.reservationResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.limitExceededResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reservations/order/cancel": {
+            "delete": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "cancel all active reservations of the order in one transaction and return money to accounts",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservation"
+                ],
+                "summary": "cancel order",
+                "parameters": [
+                    {
+                        "description": "input",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.reservationOrderInput"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.OrderSettlementOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reservations/order/refund": {
+            "post": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "return all recognized and not yet refunded revenue of the order to accounts in one transaction",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservation"
+                ],
+                "summary": "refund order",
+                "parameters": [
+                    {
+                        "description": "input",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.reservationOrderInput"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.OrderSettlementOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reservations/order/revenue": {
+            "post": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "confirm all active reservations of the order in one transaction",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservation"
+                ],
+                "summary": "revenue order",
+                "parameters": [
+                    {
+                        "description": "input",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.reservationOrderInput"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.OrderSettlementOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reservations/refund": {
+            "post": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "return recognized revenue of the reservation to account, at most recognized amount in total",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservation"
+                ],
+                "summary": "refund reservation",
+                "parameters": [
+                    {
+                        "description": "input",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.reservationRefundInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "idempotency key, repeated request with the same key and body returns stored result",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.ReservationOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reservations/revenue": {
+            "post": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "confirm reservation. If amount is less than reserved, the remainder is returned to the account",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservation"
+                ],
+                "summary": "revenue reservation",
+                "parameters": [
+                    {
+                        "description": "input",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/internal_api_v1.reservationRevenueInput"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reservations/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "JWT": []
+                    }
+                ],
+                "description": "Get reservation with its status and transition timestamps",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reservation"
+                ],
+                "summary": "Get reservation",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "reservation id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito_intership_internal_service.ReservationOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/echo.HTTPError"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "avito_intership_internal_service.AccountStatusChangeOutput": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "avito_intership_internal_service.AccountStatusOutput": {
+            "type": "object",
+            "properties": {
+                "history": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito_intership_internal_service.AccountStatusChangeOutput"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                },
+                "status_changed_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "avito_intership_internal_service.BalanceOutput": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "description": "можно потратить: balance + credit_limit",
+                    "type": "number"
+                },
+                "balance": {
+                    "description": "свободные деньги, резерв уже вычтен",
+                    "type": "number"
+                },
+                "credit_limit": {
+                    "type": "number"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "reserved": {
+                    "description": "удержано активными резервированиями",
+                    "type": "number"
+                },
+                "total": {
+                    "description": "balance + reserved",
+                    "type": "number"
+                }
+            }
+        },
+        "avito_intership_internal_service.HistoryOutput": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "number"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "operation_id": {
+                    "type": "integer"
+                },
+                "order_id": {
+                    "type": "integer"
+                },
+                "overdraft": {
+                    "type": "boolean"
+                },
+                "product_id": {
+                    "type": "integer"
+                },
+                "rate": {
+                    "type": "number"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "avito_intership_internal_service.HistoryPageOutput": {
+            "type": "object",
+            "properties": {
+                "next_cursor": {
+                    "description": "пустой на последней странице",
+                    "type": "string"
+                },
+                "operations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito_intership_internal_service.HistoryOutput"
+                    }
+                }
+            }
+        },
+        "avito_intership_internal_service.HoldOutput": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "number"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "order_id": {
+                    "type": "integer"
+                },
+                "reservations": {
+                    "type": "integer"
+                }
+            }
+        },
+        "avito_intership_internal_service.LedgerCheckOutput": {
+            "type": "object",
+            "properties": {
+                "balanced": {
+                    "type": "boolean"
+                },
+                "imbalances": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito_intership_internal_service.LedgerImbalance"
+                    }
+                }
+            }
+        },
+        "avito_intership_internal_service.LedgerImbalance": {
+            "type": "object",
+            "properties": {
+                "actual": {
+                    "type": "number"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "expected": {
+                    "type": "number"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "subject": {
+                    "type": "string"
+                }
+            }
+        },
+        "avito_intership_internal_service.LimitOutput": {
+            "type": "object",
+            "properties": {
+                "currency": {
+                    "type": "string"
+                },
+                "daily_amount": {
+                    "type": "number"
+                },
+                "daily_count": {
+                    "type": "integer"
+                },
+                "monthly_amount": {
+                    "type": "number"
+                },
+                "monthly_count": {
+                    "type": "integer"
+                },
+                "per_operation": {
+                    "type": "number"
+                },
+                "scope": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "avito_intership_internal_service.OrderReservationResult": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "number"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "reservation_id": {
+                    "type": "integer"
+                },
+                "settled": {
+                    "description": "false - резервирование было закрыто раньше и не изменилось",
+                    "type": "boolean"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "avito_intership_internal_service.OrderSettlementOutput": {
+            "type": "object",
+            "properties": {
+                "order_id": {
+                    "type": "integer"
+                },
+                "reservations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito_intership_internal_service.OrderReservationResult"
+                    }
+                }
+            }
+        },
+        "avito_intership_internal_service.RateOutput": {
+            "type": "object",
+            "properties": {
+                "base": {
+                    "type": "string"
+                },
+                "quote": {
+                    "type": "string"
+                },
+                "rate": {
+                    "type": "number"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "avito_intership_internal_service.ReportJobOutput": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "format": {
+                    "type": "string"
+                },
+                "job_id": {
+                    "type": "integer"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "params": {
+                    "type": "object"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "avito_intership_internal_service.ReportLine": {
+            "type": "object",
+            "properties": {
+                "currency": {
+                    "type": "string"
+                },
+                "net_revenue": {
+                    "type": "number"
+                },
+                "operations": {
+                    "description": "сколько раз признавалась выручка",
+                    "type": "integer"
+                },
+                "product_id": {
+                    "type": "integer"
+                },
+                "refunds": {
+                    "type": "number"
+                },
+                "revenue": {
+                    "type": "number"
+                }
+            }
+        },
+        "avito_intership_internal_service.ReportOutput": {
+            "type": "object",
+            "properties": {
+                "month": {
+                    "type": "integer"
+                },
+                "products": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito_intership_internal_service.ReportLine"
+                    }
+                },
+                "year": {
+                    "type": "integer"
+                }
+            }
+        },
+        "avito_intership_internal_service.ReservationOutput": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "number"
+                },
+                "cancelled_at": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "expired_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "order_id": {
+                    "type": "integer"
+                },
+                "product_id": {
+                    "type": "integer"
+                },
+                "recognized_amount": {
+                    "description": "только для recognized",
+                    "type": "number"
+                },
+                "recognized_at": {
+                    "type": "string"
+                },
+                "refunded_amount": {
+                    "type": "number"
+                },
+                "reservation_id": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "avito_intership_internal_service.RevenueReportLine": {
+            "type": "object",
+            "properties": {
+                "currency": {
+                    "type": "string"
+                },
+                "net_revenue": {
+                    "type": "number"
+                },
+                "operations": {
+                    "type": "integer"
+                },
+                "period_start": {
+                    "description": "дата начала периода, у первого может быть раньше начала отчета",
+                    "type": "string"
+                },
+                "product_id": {
+                    "type": "integer"
+                },
+                "refunds": {
+                    "type": "number"
+                },
+                "revenue": {
+                    "type": "number"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "avito_intership_internal_service.RevenueReportOutput": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "string"
+                },
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito_intership_internal_service.RevenueReportLine"
+                    }
+                },
+                "period": {
+                    "type": "string"
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "avito_intership_internal_service.StatementLine": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "number"
+                },
+                "balance": {
+                    "description": "баланс после операции",
+                    "type": "number"
+                },
+                "change": {
+                    "description": "изменение свободного баланса, со знаком",
+                    "type": "number"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "operation_id": {
+                    "type": "integer"
+                },
+                "order_id": {
+                    "type": "integer"
+                },
+                "overdraft": {
+                    "type": "boolean"
                 },
                 "product_id": {
                     "type": "integer"
                 },
+                "rate": {
+                    "type": "number"
+                },
                 "type": {
                     "type": "string"
                 }
             }
         },
+        "avito_intership_internal_service.StatementOutput": {
+            "type": "object",
+            "properties": {
+                "closing_balance": {
+                    "type": "number"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "from": {
+                    "type": "string"
+                },
+                "opening_balance": {
+                    "type": "number"
+                },
+                "operations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito_intership_internal_service.StatementLine"
+                    }
+                },
+                "to": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
         "echo.HTTPError": {
             "type": "object",
             "properties": {
@@ -557,6 +2556,9 @@ const docTemplate = `{
                 "amount": {
                     "type": "number"
                 },
+                "currency": {
+                    "type": "string"
+                },
                 "user_id": {
                     "type": "integer"
                 }
@@ -573,11 +2575,19 @@ const docTemplate = `{
                 "amount": {
                     "type": "number"
                 },
+                "currency": {
+                    "description": "валюта списания",
+                    "type": "string"
+                },
                 "from": {
                     "type": "integer"
                 },
                 "to": {
                     "type": "integer"
+                },
+                "to_currency": {
+                    "description": "валюта зачисления, если отличается",
+                    "type": "string"
                 }
             }
         },
@@ -591,16 +2601,119 @@ const docTemplate = `{
                 "amount": {
                     "type": "number"
                 },
+                "currency": {
+                    "type": "string"
+                },
                 "user_id": {
                     "type": "integer"
                 }
             }
         },
-        "internal_api_v1.balanceResponse": {
+        "internal_api_v1.adminDeleteLimitInput": {
             "type": "object",
             "properties": {
-                "balance": {
+                "currency": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "internal_api_v1.adminSetCreditLimitInput": {
+            "type": "object",
+            "required": [
+                "user_id"
+            ],
+            "properties": {
+                "credit_limit": {
+                    "type": "number",
+                    "minimum": 0
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "internal_api_v1.adminSetLimitInput": {
+            "type": "object",
+            "properties": {
+                "currency": {
+                    "type": "string"
+                },
+                "daily_amount": {
+                    "type": "number"
+                },
+                "daily_count": {
+                    "type": "integer"
+                },
+                "monthly_amount": {
+                    "type": "number"
+                },
+                "monthly_count": {
+                    "type": "integer"
+                },
+                "per_operation": {
+                    "type": "number"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "internal_api_v1.adminSetStatusInput": {
+            "type": "object",
+            "required": [
+                "reason",
+                "status",
+                "user_id"
+            ],
+            "properties": {
+                "reason": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "active",
+                        "frozen",
+                        "closed"
+                    ]
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "internal_api_v1.limitExceededResponse": {
+            "type": "object",
+            "properties": {
+                "currency": {
+                    "type": "string"
+                },
+                "current_amount": {
+                    "type": "number"
+                },
+                "current_count": {
+                    "type": "integer"
+                },
+                "limit": {
+                    "type": "string"
+                },
+                "max_amount": {
                     "type": "number"
+                },
+                "max_count": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "scope": {
+                    "type": "string"
                 }
             }
         },
@@ -610,14 +2723,50 @@ const docTemplate = `{
                 "user_id"
             ],
             "properties": {
+                "amount_from": {
+                    "type": "number",
+                    "minimum": 0
+                },
+                "amount_to": {
+                    "type": "number",
+                    "minimum": 0
+                },
+                "created_from": {
+                    "description": "включительно",
+                    "type": "string"
+                },
+                "created_to": {
+                    "description": "не включительно",
+                    "type": "string"
+                },
+                "cursor": {
+                    "description": "next_cursor из предыдущего ответа",
+                    "type": "string"
+                },
                 "limit": {
                     "type": "integer"
                 },
                 "offset": {
+                    "description": "устарело: используйте cursor",
                     "type": "integer"
                 },
+                "order_id": {
+                    "type": "integer",
+                    "minimum": 0
+                },
+                "product_id": {
+                    "type": "integer",
+                    "minimum": 0
+                },
                 "sort": {
-                    "type": "string"
+                    "type": "string",
+                    "example": "amount:asc,created_at:desc"
+                },
+                "types": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
                 },
                 "user_id": {
                     "type": "integer"
@@ -631,8 +2780,89 @@ const docTemplate = `{
                 "year"
             ],
             "properties": {
+                "format": {
+                    "type": "string",
+                    "enum": [
+                        "csv",
+                        "json",
+                        "xlsx"
+                    ]
+                },
                 "month": {
+                    "type": "integer",
+                    "maximum": 12,
+                    "minimum": 1
+                },
+                "year": {
                     "type": "integer"
+                }
+            }
+        },
+        "internal_api_v1.rateSetInput": {
+            "type": "object",
+            "required": [
+                "base",
+                "quote",
+                "rate"
+            ],
+            "properties": {
+                "base": {
+                    "type": "string"
+                },
+                "quote": {
+                    "type": "string"
+                },
+                "rate": {
+                    "type": "number"
+                }
+            }
+        },
+        "internal_api_v1.reportJobCreateInput": {
+            "type": "object",
+            "required": [
+                "kind"
+            ],
+            "properties": {
+                "by_product": {
+                    "type": "boolean"
+                },
+                "by_user": {
+                    "type": "boolean"
+                },
+                "format": {
+                    "type": "string",
+                    "enum": [
+                        "csv",
+                        "json",
+                        "xlsx"
+                    ]
+                },
+                "from": {
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string",
+                    "enum": [
+                        "monthly",
+                        "revenue"
+                    ]
+                },
+                "month": {
+                    "type": "integer",
+                    "maximum": 12,
+                    "minimum": 1
+                },
+                "period": {
+                    "type": "string",
+                    "enum": [
+                        "day",
+                        "week",
+                        "month",
+                        "quarter"
+                    ]
+                },
+                "to": {
+                    "type": "string"
                 },
                 "year": {
                     "type": "integer"
@@ -659,6 +2889,13 @@ const docTemplate = `{
                 "amount": {
                     "type": "number"
                 },
+                "currency": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "description": "если не указано, то используется срок из настроек сервиса",
+                    "type": "string"
+                },
                 "order_id": {
                     "type": "integer"
                 },
@@ -670,6 +2907,32 @@ const docTemplate = `{
                 }
             }
         },
+        "internal_api_v1.reservationOrderInput": {
+            "type": "object",
+            "required": [
+                "order_id"
+            ],
+            "properties": {
+                "order_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "internal_api_v1.reservationRefundInput": {
+            "type": "object",
+            "required": [
+                "reservation_id"
+            ],
+            "properties": {
+                "amount": {
+                    "description": "если не указано, то возвращается все, что еще не вернули",
+                    "type": "number"
+                },
+                "reservation_id": {
+                    "type": "integer"
+                }
+            }
+        },
         "internal_api_v1.reservationResponse": {
             "type": "object",
             "properties": {
@@ -681,6 +2944,10 @@ const docTemplate = `{
         "internal_api_v1.reservationRevenueInput": {
             "type": "object",
             "properties": {
+                "amount": {
+                    "description": "если не указано, то признается вся сумма",
+                    "type": "number"
+                },
                 "reservation_id": {
                     "type": "integer"
                 }
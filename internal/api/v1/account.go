@@ -2,10 +2,12 @@ package v1
 
 import (
 	"avito_intership/internal/service"
+	"avito_intership/pkg/money"
 	"errors"
 	"github.com/labstack/echo/v4"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type accountRouter struct {
@@ -17,6 +19,8 @@ func newAccountRouter(g *echo.Group, account service.Account) {
 
 	g.POST("/create", r.create)
 	g.GET("/balance", r.balance)
+	g.GET("/balances", r.balances)
+	g.GET("/holds", r.holds)
 	g.PATCH("/deposit", r.deposit)
 	g.PATCH("/withdraw", r.withdraw)
 	g.POST("/transfer", r.transfer)
@@ -60,19 +64,18 @@ func (r *accountRouter) create(c echo.Context) error {
 	return c.NoContent(http.StatusCreated)
 }
 
-type balanceResponse struct {
-	Balance float64 `json:"balance"`
-}
-
 // @Summary		Get balance
-// @Description	Get balance for account by id
+// @Description	Get balance for account by id in one currency (RUB by default): free balance, reserved by active reservations, their total, credit limit and available amount.
+// @Description	With as_of returns service.BalanceAsOfOutput - free balance at that moment computed from the ledger. Date without time means end of that day (UTC)
 // @Tags			account
 // @Accept			json
 // @Produce		json
-// @Param			user_id	query		string	true	"user id"
-// @Success		200		{object}	balanceResponse
-// @Failure		400		{object}	echo.HTTPError
-// @Failure		500		{object}	echo.HTTPError
+// @Param			user_id		query		string	true	"user id"
+// @Param			currency	query		string	false	"currency code (ISO 4217)"
+// @Param			as_of		query		string	false	"moment in the past, RFC 3339 or date (2006-01-02)"
+// @Success		200			{object}	service.BalanceOutput
+// @Failure		400			{object}	echo.HTTPError
+// @Failure		500			{object}	echo.HTTPError
 // @Security		JWT
 // @Router			/api/v1/accounts/balance [get]
 func (r *accountRouter) balance(c echo.Context) error {
@@ -86,8 +89,33 @@ func (r *accountRouter) balance(c echo.Context) error {
 		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
 		return err
 	}
+	currency := c.QueryParam("currency")
+	if err = c.Validate(&struct {
+		Currency string `validate:"omitempty,iso4217"`
+	}{currency}); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	if q = c.QueryParam("as_of"); len(q) != 0 {
+		asOf, err := parseAsOf(q)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, ErrInvalidAsOf)
+			return err
+		}
+		balance, err := r.account.GetBalanceAsOf(c.Request().Context(), userId, currency, asOf)
+		if err != nil {
+			if errors.Is(err, service.ErrAccountNotFound) || errors.Is(err, service.ErrBalanceAsOfFuture) {
+				errorResponse(c, http.StatusBadRequest, err)
+				return nil
+			}
+			errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+			return err
+		}
+		return c.JSON(http.StatusOK, balance)
+	}
 
-	balance, err := r.account.GetBalance(c.Request().Context(), userId)
+	balance, err := r.account.GetBalance(c.Request().Context(), userId, currency)
 	if err != nil {
 		if errors.Is(err, service.ErrAccountNotFound) {
 			errorResponse(c, http.StatusBadRequest, err)
@@ -96,13 +124,93 @@ func (r *accountRouter) balance(c echo.Context) error {
 		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
 		return err
 	}
+	return c.JSON(http.StatusOK, balance)
+}
 
-	return c.JSON(http.StatusOK, balanceResponse{Balance: balance})
+// Момент времени для исторического баланса. Дата без времени - конец этого дня, то есть начало следующего
+func parseAsOf(value string) (time.Time, error) {
+	if day, err := time.Parse(time.DateOnly, value); err == nil {
+		return day.AddDate(0, 0, 1), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// @Summary		Get balances
+// @Description	Get account balances in all currencies
+// @Tags			account
+// @Accept			json
+// @Produce		json
+// @Param			user_id	query		string	true	"user id"
+// @Success		200		{array}		service.BalanceOutput
+// @Failure		400		{object}	echo.HTTPError
+// @Failure		500		{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/accounts/balances [get]
+func (r *accountRouter) balances(c echo.Context) error {
+	userId, err := strconv.Atoi(c.QueryParam("user_id"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+
+	balances, err := r.account.GetBalances(c.Request().Context(), userId)
+	if err != nil {
+		if errors.Is(err, service.ErrAccountNotFound) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, balances)
+}
+
+type accountHoldsInput struct {
+	UserId   int    `query:"user_id" validate:"required"`
+	Currency string `query:"currency" validate:"omitempty,iso4217"`
+}
+
+// @Summary		Get holds
+// @Description	Get money held by active reservations of account, grouped by order and currency. Without currency returns holds in all currencies
+// @Tags			account
+// @Accept			json
+// @Produce		json
+// @Param			user_id		query		string	true	"user id"
+// @Param			currency	query		string	false	"currency code (ISO 4217)"
+// @Success		200			{array}		service.HoldOutput
+// @Failure		400			{object}	echo.HTTPError
+// @Failure		500			{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/accounts/holds [get]
+func (r *accountRouter) holds(c echo.Context) error {
+	var input accountHoldsInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	holds, err := r.account.GetHolds(c.Request().Context(), input.UserId, input.Currency)
+	if err != nil {
+		if errors.Is(err, service.ErrAccountNotFound) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.JSON(http.StatusOK, holds)
 }
 
 type accountDepositInput struct {
-	UserId int     `json:"user_id" validate:"required"`
-	Amount float64 `json:"amount" validate:"amount,required"`
+	UserId   int          `json:"user_id" validate:"required"`
+	Amount   money.Amount `json:"amount" validate:"amount,required" swaggertype:"number"`
+	Currency string       `json:"currency" validate:"omitempty,iso4217"`
 }
 
 // @Summary		Account deposit
@@ -111,8 +219,11 @@ type accountDepositInput struct {
 // @Accept			json
 // @Produce		json
 // @Param			input	body	accountDepositInput	true	"input"
+// @Param			Idempotency-Key	header	string	false	"idempotency key, repeated request with the same key and body returns stored result"
 // @Success		200
 // @Failure		400	{object}	echo.HTTPError
+// @Failure		403	{object}	echo.HTTPError
+// @Failure		422	{object}	echo.HTTPError
 // @Failure		500	{object}	echo.HTTPError
 // @Security		JWT
 // @Router			/api/v1/accounts/deposit [patch]
@@ -127,12 +238,27 @@ func (r *accountRouter) deposit(c echo.Context) error {
 		errorResponse(c, http.StatusBadRequest, err)
 		return err
 	}
+	key, ok := parseIdempotencyKey(c.Request())
+	if !ok {
+		errorResponse(c, http.StatusBadRequest, ErrInvalidIdempotencyKey)
+		return nil
+	}
 
 	err := r.account.Deposit(c.Request().Context(), service.DepositInput{
-		UserId: input.UserId,
-		Amount: input.Amount,
+		UserId:         input.UserId,
+		Currency:       input.Currency,
+		Amount:         input.Amount,
+		IdempotencyKey: key,
 	})
 	if err != nil {
+		if errors.Is(err, service.ErrIdempotencyKeyReused) {
+			errorResponse(c, http.StatusUnprocessableEntity, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrAccountFrozen) || errors.Is(err, service.ErrAccountClosed) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
 		if errors.Is(err, service.ErrAccountNotFound) {
 			errorResponse(c, http.StatusBadRequest, err)
 			return nil
@@ -144,8 +270,9 @@ func (r *accountRouter) deposit(c echo.Context) error {
 }
 
 type accountWithdrawInput struct {
-	UserId int     `json:"user_id" validate:"required"`
-	Amount float64 `json:"amount" validate:"amount,required"`
+	UserId   int          `json:"user_id" validate:"required"`
+	Amount   money.Amount `json:"amount" validate:"amount,required" swaggertype:"number"`
+	Currency string       `json:"currency" validate:"omitempty,iso4217"`
 }
 
 // @Summary		Account withdraw
@@ -154,8 +281,11 @@ type accountWithdrawInput struct {
 // @Accept			json
 // @Produce		json
 // @Param			input	body	accountWithdrawInput	true	"input"
+// @Param			Idempotency-Key	header	string	false	"idempotency key, repeated request with the same key and body returns stored result"
 // @Success		200
 // @Failure		400	{object}	echo.HTTPError
+// @Failure		403	{object}	limitExceededResponse
+// @Failure		422	{object}	echo.HTTPError
 // @Failure		500	{object}	echo.HTTPError
 // @Security		JWT
 // @Router			/api/v1/accounts/withdraw [patch]
@@ -170,12 +300,30 @@ func (r *accountRouter) withdraw(c echo.Context) error {
 		errorResponse(c, http.StatusBadRequest, err)
 		return err
 	}
+	key, ok := parseIdempotencyKey(c.Request())
+	if !ok {
+		errorResponse(c, http.StatusBadRequest, ErrInvalidIdempotencyKey)
+		return nil
+	}
 
 	err := r.account.Withdraw(c.Request().Context(), service.WithdrawInput{
-		UserId: input.UserId,
-		Amount: input.Amount,
+		UserId:         input.UserId,
+		Currency:       input.Currency,
+		Amount:         input.Amount,
+		IdempotencyKey: key,
 	})
 	if err != nil {
+		if errors.Is(err, service.ErrIdempotencyKeyReused) {
+			errorResponse(c, http.StatusUnprocessableEntity, err)
+			return nil
+		}
+		if limitExceeded(c, err) {
+			return nil
+		}
+		if errors.Is(err, service.ErrAccountFrozen) || errors.Is(err, service.ErrAccountClosed) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
 		if !errors.Is(err, service.ErrCannotUpdateBalance) {
 			errorResponse(c, http.StatusBadRequest, err)
 			return nil
@@ -188,19 +336,24 @@ func (r *accountRouter) withdraw(c echo.Context) error {
 }
 
 type accountTransferInput struct {
-	From   int     `json:"from" validate:"required"`
-	To     int     `json:"to" validate:"required"`
-	Amount float64 `json:"amount" validate:"amount,required"`
+	From       int          `json:"from" validate:"required"`
+	To         int          `json:"to" validate:"required"`
+	Amount     money.Amount `json:"amount" validate:"amount,required" swaggertype:"number"`
+	Currency   string       `json:"currency" validate:"omitempty,iso4217"`    // валюта списания
+	ToCurrency string       `json:"to_currency" validate:"omitempty,iso4217"` // валюта зачисления, если отличается
 }
 
 // @Summary		Account transfer
-// @Description	Transfer from account to account
+// @Description	Transfer from account to account. If currencies differ, amount is converted by stored exchange rate
 // @Tags			account
 // @Accept			json
 // @Produce		json
 // @Param			input	body	accountTransferInput	true	"input"
+// @Param			Idempotency-Key	header	string	false	"idempotency key, repeated request with the same key and body returns stored result"
 // @Success		200
 // @Failure		400	{object}	echo.HTTPError
+// @Failure		403	{object}	limitExceededResponse
+// @Failure		422	{object}	echo.HTTPError
 // @Failure		500	{object}	echo.HTTPError
 // @Security		JWT
 // @Router			/api/v1/accounts/transfer [post]
@@ -215,13 +368,32 @@ func (r *accountRouter) transfer(c echo.Context) error {
 		errorResponse(c, http.StatusBadRequest, err)
 		return err
 	}
+	key, ok := parseIdempotencyKey(c.Request())
+	if !ok {
+		errorResponse(c, http.StatusBadRequest, ErrInvalidIdempotencyKey)
+		return nil
+	}
 
 	err := r.account.Transfer(c.Request().Context(), service.TransferInput{
-		From:   input.From,
-		To:     input.To,
-		Amount: input.Amount,
+		From:           input.From,
+		To:             input.To,
+		Amount:         input.Amount,
+		FromCurrency:   input.Currency,
+		ToCurrency:     input.ToCurrency,
+		IdempotencyKey: key,
 	})
 	if err != nil {
+		if errors.Is(err, service.ErrIdempotencyKeyReused) {
+			errorResponse(c, http.StatusUnprocessableEntity, err)
+			return nil
+		}
+		if limitExceeded(c, err) {
+			return nil
+		}
+		if errors.Is(err, service.ErrAccountFrozen) || errors.Is(err, service.ErrAccountClosed) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
 		if !errors.Is(err, service.ErrCannotUpdateBalance) {
 			errorResponse(c, http.StatusBadRequest, err)
 			return nil
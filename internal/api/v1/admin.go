@@ -0,0 +1,297 @@
+package v1
+
+import (
+	"avito_intership/internal/service"
+	"avito_intership/pkg/money"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+type adminRouter struct {
+	account service.Account
+	limit   service.Limit
+}
+
+// Ручки администратора. Группа закрыта adminHandler: нужен токен из /token/admin, клиентского токена мало
+func newAdminRouter(g *echo.Group, account service.Account, limit service.Limit) {
+	r := &adminRouter{account: account, limit: limit}
+
+	g.POST("/accounts/status", r.setStatus)
+	g.GET("/accounts/status", r.getStatus)
+	g.POST("/accounts/credit-limit", r.setCreditLimit)
+	g.POST("/limits", r.setLimit)
+	g.GET("/limits", r.getLimits)
+	g.DELETE("/limits", r.deleteLimit)
+}
+
+type adminSetStatusInput struct {
+	UserId int    `json:"user_id" validate:"required,gt=0"`
+	Status string `json:"status" validate:"required,oneof=active frozen closed"`
+	Reason string `json:"reason" validate:"required"`
+}
+
+// @Summary		Set account status
+// @Description	Freeze, unfreeze or close account. Frozen account can only receive money, closed account takes no part in operations and cannot be reopened. Only empty account without active reservations can be closed
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			input	body	adminSetStatusInput	true	"input"
+// @Success		200
+// @Failure		400	{object}	echo.HTTPError
+// @Failure		409	{object}	echo.HTTPError
+// @Failure		500	{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/admin/accounts/status [post]
+func (r *adminRouter) setStatus(c echo.Context) error {
+	var input adminSetStatusInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	err := r.account.SetStatus(c.Request().Context(), service.AccountStatusInput{
+		UserId: input.UserId,
+		Status: input.Status,
+		Reason: input.Reason,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrAccountNotFound) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrAccountClosed) || errors.Is(err, service.ErrAccountNotEmpty) {
+			errorResponse(c, http.StatusConflict, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+type adminGetStatusInput struct {
+	UserId int `query:"user_id" validate:"required,gt=0"`
+}
+
+// @Summary		Get account status
+// @Description	Get current account status and history of its changes, latest first
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			user_id	query		int	true	"user id"
+// @Success		200		{object}	service.AccountStatusOutput
+// @Failure		400		{object}	echo.HTTPError
+// @Failure		500		{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/admin/accounts/status [get]
+func (r *adminRouter) getStatus(c echo.Context) error {
+	var input adminGetStatusInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	status, err := r.account.GetStatus(c.Request().Context(), input.UserId)
+	if err != nil {
+		if errors.Is(err, service.ErrAccountNotFound) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+type adminSetCreditLimitInput struct {
+	UserId      int          `json:"user_id" validate:"required,gt=0"`
+	Currency    string       `json:"currency" validate:"omitempty,iso4217"`
+	CreditLimit money.Amount `json:"credit_limit" validate:"gte=0" swaggertype:"number"`
+}
+
+// @Summary		Set credit limit
+// @Description	Set how far balance in currency (RUB by default) can go below zero. Zero limit forbids negative balance. Limit cannot be less than current debt
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			input	body		adminSetCreditLimitInput	true	"input"
+// @Success		200		{object}	service.BalanceOutput
+// @Failure		400		{object}	echo.HTTPError
+// @Failure		403		{object}	echo.HTTPError
+// @Failure		409		{object}	echo.HTTPError
+// @Failure		500		{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/admin/accounts/credit-limit [post]
+func (r *adminRouter) setCreditLimit(c echo.Context) error {
+	var input adminSetCreditLimitInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	balance, err := r.account.SetCreditLimit(c.Request().Context(), service.CreditLimitInput{
+		UserId:      input.UserId,
+		Currency:    input.Currency,
+		CreditLimit: input.CreditLimit,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrAccountNotFound) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrAccountFrozen) || errors.Is(err, service.ErrAccountClosed) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrCreditLimitDebt) {
+			errorResponse(c, http.StatusConflict, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.JSON(http.StatusOK, balance)
+}
+
+type adminSetLimitInput struct {
+	UserId        *int          `json:"user_id" validate:"omitempty,gt=0"`
+	Currency      string        `json:"currency" validate:"omitempty,iso4217"`
+	PerOperation  *money.Amount `json:"per_operation" validate:"omitempty,gt=0" swaggertype:"number"`
+	DailyAmount   *money.Amount `json:"daily_amount" validate:"omitempty,gt=0" swaggertype:"number"`
+	MonthlyAmount *money.Amount `json:"monthly_amount" validate:"omitempty,gt=0" swaggertype:"number"`
+	DailyCount    *int          `json:"daily_count" validate:"omitempty,gt=0"`
+	MonthlyCount  *int          `json:"monthly_count" validate:"omitempty,gt=0"`
+}
+
+// @Summary		Set spending limits
+// @Description	Create or replace spending limits in currency (RUB by default) for account or globally (without user_id). Omitted limit means no limit. Withdrawals, outgoing transfers and reservations are checked against both account and global limits, days and months are calendar
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			input	body		adminSetLimitInput	true	"input"
+// @Success		200		{object}	service.LimitOutput
+// @Failure		400		{object}	echo.HTTPError
+// @Failure		500		{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/admin/limits [post]
+func (r *adminRouter) setLimit(c echo.Context) error {
+	var input adminSetLimitInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	limit, err := r.limit.SetLimit(c.Request().Context(), service.LimitInput{
+		UserId:        input.UserId,
+		Currency:      input.Currency,
+		PerOperation:  input.PerOperation,
+		DailyAmount:   input.DailyAmount,
+		MonthlyAmount: input.MonthlyAmount,
+		DailyCount:    input.DailyCount,
+		MonthlyCount:  input.MonthlyCount,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrAccountNotFound) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.JSON(http.StatusOK, limit)
+}
+
+type adminGetLimitsInput struct {
+	UserId *int `query:"user_id" validate:"omitempty,gt=0"`
+}
+
+// @Summary		Get spending limits
+// @Description	Get spending limits applied to account: its own and global ones. Without user_id returns only global limits
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			user_id	query		int	false	"user id"
+// @Success		200		{array}		service.LimitOutput
+// @Failure		400		{object}	echo.HTTPError
+// @Failure		500		{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/admin/limits [get]
+func (r *adminRouter) getLimits(c echo.Context) error {
+	var input adminGetLimitsInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	limits, err := r.limit.GetLimits(c.Request().Context(), input.UserId)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.JSON(http.StatusOK, limits)
+}
+
+type adminDeleteLimitInput struct {
+	UserId   *int   `json:"user_id" validate:"omitempty,gt=0"`
+	Currency string `json:"currency" validate:"omitempty,iso4217"`
+}
+
+// @Summary		Delete spending limits
+// @Description	Remove all spending limits in currency (RUB by default) for account or global ones (without user_id)
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			input	body	adminDeleteLimitInput	true	"input"
+// @Success		200
+// @Failure		400	{object}	echo.HTTPError
+// @Failure		500	{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/admin/limits [delete]
+func (r *adminRouter) deleteLimit(c echo.Context) error {
+	var input adminDeleteLimitInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	if err := r.limit.DeleteLimit(c.Request().Context(), input.UserId, input.Currency); err != nil {
+		if errors.Is(err, service.ErrLimitNotFound) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
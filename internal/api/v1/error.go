@@ -1,13 +1,26 @@
 package v1
 
 import (
+	"avito_intership/internal/service"
 	"errors"
 	"github.com/labstack/echo/v4"
+	"net/http"
 )
 
 var (
 	ErrInvalidAuthHeader = errors.New("invalid authorization header")
 	ErrInvalidAuthToken  = errors.New("invalid authorization token")
+	ErrAdminRequired     = errors.New("admin token is required")
+
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key header")
+	ErrInvalidAsOf           = errors.New("invalid as_of, expected RFC 3339 time or date")
+	ErrInvalidPeriod         = errors.New("invalid period bound, expected RFC 3339 time or date")
+	ErrInvalidRange          = errors.New("range start must be before its end")
+	ErrInvalidAmount         = errors.New("invalid amount, expected non-negative decimal number")
+	ErrInvalidSort           = errors.New("invalid sort, expected comma separated created_at, amount, type, id with optional :asc or :desc")
+
+	ErrReservationFilterRequired = errors.New("one of user_id, order_id, product_id is required")
+	ErrReportParamsRequired      = errors.New("year and month are required for monthly report, from and to for revenue report")
 )
 
 func errorResponse(c echo.Context, status int, err error) {
@@ -17,3 +30,19 @@ func errorResponse(c echo.Context, status int, err error) {
 	}
 	_ = c.JSON(status, err)
 }
+
+// limitExceededResponse тело ответа при превышении лимита расходов: кроме сообщения говорит, какой лимит сработал
+type limitExceededResponse struct {
+	Message string `json:"message"`
+	*service.LimitExceededError
+}
+
+// Ответ на превышение лимита расходов. Возвращает false, если ошибка не про лимит
+func limitExceeded(c echo.Context, err error) bool {
+	var limitErr *service.LimitExceededError
+	if !errors.As(err, &limitErr) {
+		return false
+	}
+	_ = c.JSON(http.StatusForbidden, limitExceededResponse{Message: limitErr.Error(), LimitExceededError: limitErr})
+	return true
+}
@@ -0,0 +1,37 @@
+package v1
+
+import (
+	"avito_intership/internal/service"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+type ledgerRouter struct {
+	ledger service.Ledger
+}
+
+// Сверка идет по всей системе и отдает суммы и расхождения по чужим счетам, поэтому группа только для администратора
+func newLedgerRouter(g *echo.Group, ledger service.Ledger) {
+	r := &ledgerRouter{ledger: ledger}
+
+	g.GET("/check", r.check)
+}
+
+// @Summary		Check ledger
+// @Description	Check ledger invariants: every journal entry and the whole ledger sum to zero, account balances and active reservations match postings. Admin token only
+// @Tags			ledger
+// @Accept			json
+// @Produce		json
+// @Success		200	{object}	service.LedgerCheckOutput
+// @Failure		403	{object}	echo.HTTPError
+// @Failure		500	{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/ledger/check [get]
+func (r *ledgerRouter) check(c echo.Context) error {
+	result, err := r.ledger.Check(c.Request().Context())
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
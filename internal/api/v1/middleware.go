@@ -10,7 +10,17 @@ import (
 	"strings"
 )
 
-const bearerPrefix = "Bearer "
+const (
+	bearerPrefix = "Bearer "
+
+	idempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+
+	adminKeyHeader = "X-Admin-Key"
+
+	// ключ контекста запроса, под которым лежит роль из токена
+	roleContextKey = "role"
+)
 
 type authMiddleware struct {
 	auth service.Auth
@@ -23,10 +33,23 @@ func (h *authMiddleware) authHandler(next echo.HandlerFunc) echo.HandlerFunc {
 			errorResponse(c, http.StatusUnauthorized, ErrInvalidAuthHeader)
 			return nil
 		}
-		if !h.auth.ValidateToken(token) {
+		role, valid := h.auth.ValidateToken(token)
+		if !valid {
 			errorResponse(c, http.StatusForbidden, ErrInvalidAuthToken)
 			return nil
 		}
+		c.Set(roleContextKey, role)
+		return next(c)
+	}
+}
+
+// adminHandler пропускает только запросы с админским токеном. Ставится после authHandler
+func (h *authMiddleware) adminHandler(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if role, _ := c.Get(roleContextKey).(string); role != service.RoleAdmin {
+			errorResponse(c, http.StatusForbidden, ErrAdminRequired)
+			return nil
+		}
 		return next(c)
 	}
 }
@@ -43,6 +66,15 @@ func parseToken(r *http.Request) (string, bool) {
 	return token[1], true
 }
 
+// Ключ идемпотентности необязательный. Без него запрос выполняется как обычно
+func parseIdempotencyKey(r *http.Request) (string, bool) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		return "", false
+	}
+	return key, true
+}
+
 func LoggingMiddleware(h *echo.Echo, output string) {
 	cfg := middleware.LoggerConfig{
 		Format: `{"time":"${time_rfc3339}", "method":"${method}","uri":"${uri}", "status":${status}, "error":"${error}"}` + "\n",
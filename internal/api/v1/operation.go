@@ -2,36 +2,57 @@ package v1
 
 import (
 	"avito_intership/internal/service"
+	"avito_intership/pkg/money"
+	"avito_intership/pkg/xlsx"
 	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type operationRouter struct {
 	operation service.Operation
 }
 
-func newOperationRouter(g *echo.Group, operation service.Operation) {
+func newOperationRouter(g *echo.Group, operation service.Operation, admin echo.MiddlewareFunc) {
 	r := &operationRouter{operation: operation}
 
 	g.GET("/history", r.history)
-	g.GET("/report", r.report)
+	g.GET("/history/export", r.exportHistory)
+	// отчеты о выручке считаются по всем пользователям (revenue еще и с разбивкой by_user), поэтому только для администратора
+	g.GET("/report", r.report, admin)
+	g.GET("/report/revenue", r.revenueReport, admin)
+	g.GET("/statement", r.statement)
 }
 
 type operationHistoryInput struct {
-	UserId int    `json:"user_id" validate:"required"`
-	Sort   string `json:"sort"`
-	Offset int    `json:"offset"`
-	Limit  int    `json:"limit"`
+	UserId      int          `json:"user_id" validate:"required"`
+	Types       []string     `json:"types" validate:"omitempty,dive,oneof=deposit withdraw outgoing-transfer incoming-transfer reservation de-reservation revenue refund"`
+	CreatedFrom time.Time    `json:"created_from"` // включительно
+	CreatedTo   time.Time    `json:"created_to"`   // не включительно
+	AmountFrom  money.Amount `json:"amount_from" validate:"min=0" swaggertype:"number"`
+	AmountTo    money.Amount `json:"amount_to" validate:"min=0" swaggertype:"number"`
+	ProductId   int          `json:"product_id" validate:"min=0"`
+	OrderId     int          `json:"order_id" validate:"min=0"`
+	Sort        string       `json:"sort" example:"amount:asc,created_at:desc"`
+	Cursor      string       `json:"cursor"` // next_cursor из предыдущего ответа
+	Offset      int          `json:"offset"` // устарело: используйте cursor
+	Limit       int          `json:"limit"`
 }
 
 //	@Summary		Get history
-//	@Description	Get account transactions history
+//	@Description	Get account transactions history page filtered by types, created_at and amount ranges, product and order.
+//	@Description	Sort is a comma separated list of created_at, amount, type, id with optional :asc or :desc (desc by default),
+//	@Description	newest first if empty. Pass next_cursor from the response as cursor to get the next page,
+//	@Description	the cursor is valid only for the same sort
 //	@Tags			operation
 //	@Accept			json
 //	@Produce		json
 //	@Param			input	body		operationHistoryInput	true	"input"
-//	@Success		200		{array}		service.HistoryOutput
+//	@Success		200		{object}	service.HistoryPageOutput
 //	@Failure		400		{object}	echo.HTTPError
 //	@Failure		500		{object}	echo.HTTPError
 //	@Security		JWT
@@ -47,15 +68,32 @@ func (r *operationRouter) history(c echo.Context) error {
 		errorResponse(c, http.StatusBadRequest, err)
 		return err
 	}
+	if !historyRangesValid(input.CreatedFrom, input.CreatedTo, input.AmountFrom, input.AmountTo) {
+		errorResponse(c, http.StatusBadRequest, ErrInvalidRange)
+		return nil
+	}
+	sort, err := parseHistorySort(input.Sort)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
 
 	history, err := r.operation.GetHistory(c.Request().Context(), service.HistoryInput{
-		UserId: input.UserId,
-		Sort:   input.Sort,
-		Offset: input.Offset,
-		Limit:  input.Limit,
+		UserId:      input.UserId,
+		Types:       input.Types,
+		CreatedFrom: input.CreatedFrom,
+		CreatedTo:   input.CreatedTo,
+		AmountFrom:  input.AmountFrom,
+		AmountTo:    input.AmountTo,
+		ProductId:   input.ProductId,
+		OrderId:     input.OrderId,
+		Sort:        sort,
+		Cursor:      input.Cursor,
+		Offset:      input.Offset,
+		Limit:       input.Limit,
 	})
 	if err != nil {
-		if errors.Is(err, service.ErrAccountNotFound) {
+		if errors.Is(err, service.ErrAccountNotFound) || errors.Is(err, service.ErrInvalidCursor) {
 			errorResponse(c, http.StatusBadRequest, err)
 			return nil
 		}
@@ -66,20 +104,190 @@ func (r *operationRouter) history(c echo.Context) error {
 	return c.JSON(http.StatusOK, history)
 }
 
+// выгрузка продлевает дедлайн записи на это время перед каждой записью в соединение
+const exportWriteTimeout = 30 * time.Second
+
+type operationExportInput struct {
+	UserId      int      `query:"user_id" validate:"required"`
+	Format      string   `query:"format" validate:"omitempty,oneof=csv ndjson"`
+	Types       []string `query:"types" validate:"omitempty,dive,oneof=deposit withdraw outgoing-transfer incoming-transfer reservation de-reservation revenue refund"`
+	CreatedFrom string   `query:"created_from"`
+	CreatedTo   string   `query:"created_to"`
+	AmountFrom  string   `query:"amount_from"`
+	AmountTo    string   `query:"amount_to"`
+	ProductId   int      `query:"product_id" validate:"min=0"`
+	OrderId     int      `query:"order_id" validate:"min=0"`
+	Sort        string   `query:"sort"`
+}
+
+//	@Summary		Export history
+//	@Description	Download the whole account transactions history as csv or ndjson (one history operation per line).
+//	@Description	Filters and sort are the same as in history, the file is streamed while operations are read
+//	@Tags			operation
+//	@Produce		text/csv
+//	@Produce		application/x-ndjson
+//	@Param			user_id			query		int			true	"user id"
+//	@Param			format			query		string		false	"csv (default) or ndjson"	Enums(csv, ndjson)
+//	@Param			types			query		[]string	false	"operation types"	collectionFormat(multi)
+//	@Param			created_from	query		string		false	"RFC 3339 time or date, inclusive"
+//	@Param			created_to		query		string		false	"RFC 3339 time or date, exclusive"
+//	@Param			amount_from		query		number		false	"min amount, inclusive"
+//	@Param			amount_to		query		number		false	"max amount, inclusive"
+//	@Param			product_id		query		int			false	"product id"
+//	@Param			order_id		query		int			false	"order id"
+//	@Param			sort			query		string		false	"sort, e.g. created_at:asc"
+//	@Success		200				{file}		file
+//	@Failure		400				{object}	echo.HTTPError
+//	@Failure		500				{object}	echo.HTTPError
+//	@Security		JWT
+//	@Router			/api/v1/operations/history/export [get]
+func (r *operationRouter) exportHistory(c echo.Context) error {
+	var input operationExportInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+	historyInput := service.HistoryInput{
+		UserId:    input.UserId,
+		Types:     input.Types,
+		ProductId: input.ProductId,
+		OrderId:   input.OrderId,
+	}
+	var err error
+	if input.CreatedFrom != "" {
+		if historyInput.CreatedFrom, err = parsePeriodStart(input.CreatedFrom); err != nil {
+			errorResponse(c, http.StatusBadRequest, ErrInvalidPeriod)
+			return nil
+		}
+	}
+	if input.CreatedTo != "" {
+		if historyInput.CreatedTo, err = parsePeriodStart(input.CreatedTo); err != nil {
+			errorResponse(c, http.StatusBadRequest, ErrInvalidPeriod)
+			return nil
+		}
+	}
+	if input.AmountFrom != "" {
+		if historyInput.AmountFrom, err = money.Parse(input.AmountFrom); err != nil || historyInput.AmountFrom < 0 {
+			errorResponse(c, http.StatusBadRequest, ErrInvalidAmount)
+			return nil
+		}
+	}
+	if input.AmountTo != "" {
+		if historyInput.AmountTo, err = money.Parse(input.AmountTo); err != nil || historyInput.AmountTo < 0 {
+			errorResponse(c, http.StatusBadRequest, ErrInvalidAmount)
+			return nil
+		}
+	}
+	if !historyRangesValid(historyInput.CreatedFrom, historyInput.CreatedTo, historyInput.AmountFrom, historyInput.AmountTo) {
+		errorResponse(c, http.StatusBadRequest, ErrInvalidRange)
+		return nil
+	}
+	if historyInput.Sort, err = parseHistorySort(input.Sort); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+
+	format, contentType := service.ExportFormatCSV, "text/csv"
+	if input.Format == service.ExportFormatNDJSON {
+		format, contentType = service.ExportFormatNDJSON, "application/x-ndjson"
+	}
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"history_%d.%s\"", input.UserId, format))
+
+	w := &deadlineWriter{
+		w:       c.Response(),
+		rc:      http.NewResponseController(c.Response().Writer),
+		timeout: exportWriteTimeout,
+	}
+	if err = r.operation.ExportHistory(c.Request().Context(), historyInput, format, w); err != nil {
+		// если часть файла уже ушла клиенту, то статус не поменять - выгрузка просто обрывается
+		if !c.Response().Committed {
+			header.Del(echo.HeaderContentDisposition)
+			errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		}
+		return err
+	}
+	return nil
+}
+
+// deadlineWriter перед каждой записью продлевает дедлайн записи соединения. WriteTimeout сервера рассчитан на обычные
+// ответы и оборвал бы длинную выгрузку, а совсем снять дедлайн - значит держать соединение с зависшим клиентом вечно
+type deadlineWriter struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	if err := w.rc.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// Диапазоны фильтра истории: начало периода раньше конца, минимальная сумма не больше максимальной.
+// Нулевые границы не заданы
+func historyRangesValid(createdFrom, createdTo time.Time, amountFrom, amountTo money.Amount) bool {
+	if !createdFrom.IsZero() && !createdTo.IsZero() && !createdFrom.Before(createdTo) {
+		return false
+	}
+	return amountTo == 0 || amountFrom <= amountTo
+}
+
+// поля, по которым можно сортировать историю
+var historySortFields = map[string]bool{"created_at": true, "amount": true, "type": true, "id": true}
+
+// Сортировка истории вида "amount:asc,created_at:desc". Направление по умолчанию - по убыванию, как было раньше.
+// Поле не может повторяться
+func parseHistorySort(value string) ([]service.HistorySort, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var result []service.HistorySort
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		field, direction, _ := strings.Cut(strings.TrimSpace(item), ":")
+		if !historySortFields[field] || seen[field] {
+			return nil, ErrInvalidSort
+		}
+		seen[field] = true
+
+		sort := service.HistorySort{Field: field}
+		switch direction {
+		case "", "desc":
+			sort.Desc = true
+		case "asc":
+		default:
+			return nil, ErrInvalidSort
+		}
+		result = append(result, sort)
+	}
+	return result, nil
+}
+
 type operationReportInput struct {
-	Year  int `json:"year" validate:"required"`
-	Month int `json:"month" validate:"required"`
+	Year   int    `json:"year" query:"year" validate:"required"`
+	Month  int    `json:"month" query:"month" validate:"required,min=1,max=12"`
+	Format string `json:"format" query:"format" validate:"omitempty,oneof=csv json xlsx"`
 }
 
 //	@Summary		Get report
-//	@Description	Get monthly report, ordered by products ids
+//	@Description	Get monthly revenue report, ordered by products ids and currencies: revenue, number of revenue operations, refunds and net revenue.
+//	@Description	Format is taken from the format parameter or the Accept header, csv by default. Admin token only
 //	@Tags			operation
 //	@Accept			json
-//	@Produce		json
-//	@Param			input	body	operationReportInput	true	"input"
-//	@Success		200
-//	@Failure		400	{object}	echo.HTTPError
-//	@Failure		500	{object}	echo.HTTPError
+//	@Produce		text/csv,json,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+//	@Param			input	body		operationReportInput	true	"input"
+//	@Success		200		{object}	service.ReportOutput
+//	@Failure		400		{object}	echo.HTTPError
+//	@Failure		403		{object}	echo.HTTPError
+//	@Failure		500		{object}	echo.HTTPError
 //	@Security		JWT
 //	@Router			/api/v1/operations/report [get]
 func (r *operationRouter) report(c echo.Context) error {
@@ -94,11 +302,223 @@ func (r *operationRouter) report(c echo.Context) error {
 		return err
 	}
 
-	report, err := r.operation.CreateReport(c.Request().Context(), input.Year, input.Month)
+	format := reportFormat(input.Format, c.Request().Header.Get(echo.HeaderAccept), service.ReportFormatCSV)
+	if format == service.ReportFormatJSON {
+		report, err := r.operation.GetReport(c.Request().Context(), input.Year, input.Month)
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+			return err
+		}
+		return c.JSON(http.StatusOK, report)
+	}
+
+	report, err := r.operation.CreateReport(c.Request().Context(), input.Year, input.Month, format)
 	if err != nil {
 		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
 		return err
 	}
+	return reportFile(c, fmt.Sprintf("report_%d_%02d", input.Year, input.Month), format, report)
+}
+
+type operationRevenueReportInput struct {
+	From      string `query:"from" validate:"required"`
+	To        string `query:"to" validate:"required"`
+	Period    string `query:"period" validate:"omitempty,oneof=day week month quarter"`
+	ByProduct bool   `query:"by_product"`
+	ByUser    bool   `query:"by_user"`
+	Format    string `query:"format" validate:"omitempty,oneof=csv json xlsx"`
+}
+
+//	@Summary		Get revenue report
+//	@Description	Get revenue report for period grouped by day, week (from monday), month or quarter and currency, optionally by products and users:
+//	@Description	revenue, number of revenue operations, refunds and net revenue. Period bounds are RFC 3339 time or date (2006-01-02), date in "to" means end of that day.
+//	@Description	Format is taken from the format parameter or the Accept header, json by default. Admin token only
+//	@Tags			operation
+//	@Produce		json,text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+//	@Param			from		query		string	true	"period start"
+//	@Param			to			query		string	true	"period end"
+//	@Param			period		query		string	false	"grouping, month by default"	Enums(day, week, month, quarter)
+//	@Param			by_product	query		bool	false	"group by products"
+//	@Param			by_user		query		bool	false	"group by users"
+//	@Param			format		query		string	false	"json, csv or xlsx"	Enums(json, csv, xlsx)
+//	@Success		200			{object}	service.RevenueReportOutput
+//	@Failure		400			{object}	echo.HTTPError
+//	@Failure		403			{object}	echo.HTTPError
+//	@Failure		500			{object}	echo.HTTPError
+//	@Security		JWT
+//	@Router			/api/v1/operations/report/revenue [get]
+func (r *operationRouter) revenueReport(c echo.Context) error {
+	var input operationRevenueReportInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+	from, err := parsePeriodStart(input.From)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, ErrInvalidPeriod)
+		return nil
+	}
+	to, err := parseAsOf(input.To)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, ErrInvalidPeriod)
+		return nil
+	}
+	reportInput := service.RevenueReportInput{
+		From:      from,
+		To:        to,
+		Period:    input.Period,
+		ByProduct: input.ByProduct,
+		ByUser:    input.ByUser,
+	}
+
+	// в отличие от старого месячного отчета, тут по умолчанию json
+	format := reportFormat(input.Format, c.Request().Header.Get(echo.HeaderAccept), service.ReportFormatJSON)
+	if format == service.ReportFormatJSON {
+		report, err := r.operation.GetRevenueReport(c.Request().Context(), reportInput)
+		if err != nil {
+			return revenueReportError(c, err)
+		}
+		return c.JSON(http.StatusOK, report)
+	}
+
+	report, err := r.operation.CreateRevenueReport(c.Request().Context(), reportInput, format)
+	if err != nil {
+		return revenueReportError(c, err)
+	}
+	return reportFile(c, fmt.Sprintf("revenue_%s_%s", from.Format(time.DateOnly), to.Format(time.DateOnly)), format, report)
+}
+
+func revenueReportError(c echo.Context, err error) error {
+	if errors.Is(err, service.ErrReportPeriod) {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+	errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+	return err
+}
+
+// Формат отчета: явно указанный format, иначе первый известный тип из Accept, иначе byDefault.
+// Веса q в Accept не учитываются
+func reportFormat(format, accept, byDefault string) string {
+	if format != "" {
+		return format
+	}
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(item, ";")
+		switch strings.TrimSpace(mediaType) {
+		case "text/csv":
+			return service.ReportFormatCSV
+		case echo.MIMEApplicationJSON:
+			return service.ReportFormatJSON
+		case xlsx.ContentType:
+			return service.ReportFormatXLSX
+		}
+	}
+	return byDefault
+}
+
+// Отчет файлом для скачивания
+func reportFile(c echo.Context, name, format string, data []byte) error {
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"%s.%s\"", name, format))
+	return c.Blob(http.StatusOK, reportContentType(format), data)
+}
+
+func reportContentType(format string) string {
+	switch format {
+	case service.ReportFormatJSON:
+		return echo.MIMEApplicationJSON
+	case service.ReportFormatXLSX:
+		return xlsx.ContentType
+	default:
+		return "text/csv"
+	}
+}
+
+type operationStatementInput struct {
+	UserId   int    `query:"user_id" validate:"required"`
+	Currency string `query:"currency" validate:"omitempty,iso4217"`
+	From     string `query:"from" validate:"required"`
+	To       string `query:"to" validate:"required"`
+	Format   string `query:"format" validate:"omitempty,oneof=json csv"`
+}
+
+//	@Summary		Get statement
+//	@Description	Get account statement in one currency (RUB by default) for period: opening balance, operations with balance after each and closing balance.
+//	@Description	Period bounds are RFC 3339 time or date (2006-01-02), date in "to" means end of that day. Balances exclude money held by active reservations
+//	@Tags			operation
+//	@Accept			json
+//	@Produce		json,text/csv
+//	@Param			user_id		query		int		true	"user id"
+//	@Param			currency	query		string	false	"currency code (ISO 4217)"
+//	@Param			from		query		string	true	"period start"
+//	@Param			to			query		string	true	"period end"
+//	@Param			format		query		string	false	"json (default) or csv"
+//	@Success		200			{object}	service.StatementOutput
+//	@Failure		400			{object}	echo.HTTPError
+//	@Failure		500			{object}	echo.HTTPError
+//	@Security		JWT
+//	@Router			/api/v1/operations/statement [get]
+func (r *operationRouter) statement(c echo.Context) error {
+	var input operationStatementInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+	from, err := parsePeriodStart(input.From)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, ErrInvalidPeriod)
+		return err
+	}
+	to, err := parseAsOf(input.To)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, ErrInvalidPeriod)
+		return err
+	}
 
-	return c.Blob(http.StatusOK, "text/csv", report)
+	statementInput := service.StatementInput{
+		UserId:   input.UserId,
+		Currency: input.Currency,
+		From:     from,
+		To:       to,
+	}
+	if input.Format == "csv" {
+		statement, err := r.operation.CreateStatementCSV(c.Request().Context(), statementInput)
+		if err != nil {
+			return statementError(c, err)
+		}
+		return c.Blob(http.StatusOK, "text/csv", statement)
+	}
+
+	statement, err := r.operation.GetStatement(c.Request().Context(), statementInput)
+	if err != nil {
+		return statementError(c, err)
+	}
+	return c.JSON(http.StatusOK, statement)
+}
+
+func statementError(c echo.Context, err error) error {
+	if errors.Is(err, service.ErrAccountNotFound) || errors.Is(err, service.ErrStatementPeriod) {
+		errorResponse(c, http.StatusBadRequest, err)
+		return nil
+	}
+	errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+	return err
+}
+
+// Начало периода. Дата без времени - начало этого дня
+func parsePeriodStart(value string) (time.Time, error) {
+	if day, err := time.Parse(time.DateOnly, value); err == nil {
+		return day, nil
+	}
+	return time.Parse(time.RFC3339, value)
 }
@@ -0,0 +1,104 @@
+package v1
+
+import (
+	"avito_intership/internal/service"
+	"avito_intership/pkg/money"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+type rateRouter struct {
+	rate service.Rate
+}
+
+// Курсы читают все, а меняет только администратор: иначе клиент мог бы выставить курс и перевести по нему
+func newRateRouter(g *echo.Group, rate service.Rate, admin echo.MiddlewareFunc) {
+	r := &rateRouter{rate: rate}
+
+	g.POST("/set", r.set, admin)
+	g.GET("/get", r.get)
+}
+
+type rateSetInput struct {
+	Base  string     `json:"base" validate:"required,iso4217"`
+	Quote string     `json:"quote" validate:"required,iso4217,nefield=Base"`
+	Rate  money.Rate `json:"rate" validate:"required" swaggertype:"number"`
+}
+
+// @Summary		Set exchange rate
+// @Description	Create or update exchange rate: how many quote currency units are given for one base currency unit. Admin token only
+// @Tags			rate
+// @Accept			json
+// @Produce		json
+// @Param			input	body	rateSetInput	true	"input"
+// @Success		200
+// @Failure		400	{object}	echo.HTTPError
+// @Failure		403	{object}	echo.HTTPError
+// @Failure		500	{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/rates/set [post]
+func (r *rateRouter) set(c echo.Context) error {
+	var input rateSetInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	err := r.rate.SetRate(c.Request().Context(), service.RateInput{
+		Base:  input.Base,
+		Quote: input.Quote,
+		Rate:  input.Rate,
+	})
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+type rateGetInput struct {
+	Base  string `query:"base" validate:"required,iso4217"`
+	Quote string `query:"quote" validate:"required,iso4217"`
+}
+
+// @Summary		Get exchange rate
+// @Description	Get stored exchange rate for currency pair
+// @Tags			rate
+// @Accept			json
+// @Produce		json
+// @Param			base	query		string	true	"base currency"
+// @Param			quote	query		string	true	"quote currency"
+// @Success		200		{object}	service.RateOutput
+// @Failure		400		{object}	echo.HTTPError
+// @Failure		500		{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/rates/get [get]
+func (r *rateRouter) get(c echo.Context) error {
+	var input rateGetInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	rate, err := r.rate.GetRate(c.Request().Context(), input.Base, input.Quote)
+	if err != nil {
+		if errors.Is(err, service.ErrRateNotFound) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.JSON(http.StatusOK, rate)
+}
@@ -0,0 +1,206 @@
+package v1
+
+import (
+	"avito_intership/internal/service"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+)
+
+type reportRouter struct {
+	report service.Report
+}
+
+// Отчеты по всем пользователям (в том числе с разбивкой by_user), поэтому группа только для администратора,
+// как и /admin. Задачи при этом общие для всех администраторов
+func newReportRouter(g *echo.Group, report service.Report) {
+	r := &reportRouter{report: report}
+
+	g.POST("/jobs", r.createJob)
+	g.GET("/jobs/:id", r.getJob)
+	g.GET("/jobs/:id/file", r.downloadJob)
+}
+
+type reportJobCreateInput struct {
+	Kind      string `json:"kind" validate:"required,oneof=monthly revenue"`
+	Format    string `json:"format" validate:"omitempty,oneof=csv json xlsx"`
+	Year      int    `json:"year"`
+	Month     int    `json:"month" validate:"omitempty,min=1,max=12"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Period    string `json:"period" validate:"omitempty,oneof=day week month quarter"`
+	ByProduct bool   `json:"by_product"`
+	ByUser    bool   `json:"by_user"`
+}
+
+// @Summary		Create report job
+// @Description	Queue report generation in background, admin token only. Monthly report needs year and month, revenue report needs from and to (RFC 3339 time or date, date in "to" means end of that day),
+// @Description	period and breakdowns as in the revenue report. If the same report is already queued, or is generated and its period is over, that job is returned with 200 instead of a new one
+// @Tags			report
+// @Accept			json
+// @Produce		json
+// @Param			input	body		reportJobCreateInput	true	"input"
+// @Success		200		{object}	service.ReportJobOutput
+// @Success		202		{object}	service.ReportJobOutput
+// @Failure		400		{object}	echo.HTTPError
+// @Failure		403		{object}	echo.HTTPError
+// @Failure		500		{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/reports/jobs [post]
+func (r *reportRouter) createJob(c echo.Context) error {
+	var input reportJobCreateInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	jobInput := service.ReportJobInput{
+		Kind:   input.Kind,
+		Format: input.Format,
+		Year:   input.Year,
+		Month:  input.Month,
+	}
+	if input.Kind == "revenue" {
+		if input.From == "" || input.To == "" {
+			errorResponse(c, http.StatusBadRequest, ErrReportParamsRequired)
+			return nil
+		}
+		from, err := parsePeriodStart(input.From)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, ErrInvalidPeriod)
+			return nil
+		}
+		to, err := parseAsOf(input.To)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, ErrInvalidPeriod)
+			return nil
+		}
+		jobInput.Revenue = service.RevenueReportInput{
+			From:      from,
+			To:        to,
+			Period:    input.Period,
+			ByProduct: input.ByProduct,
+			ByUser:    input.ByUser,
+		}
+	} else if input.Year == 0 || input.Month == 0 {
+		errorResponse(c, http.StatusBadRequest, ErrReportParamsRequired)
+		return nil
+	}
+
+	job, created, err := r.report.CreateJob(c.Request().Context(), jobInput)
+	if err != nil {
+		if errors.Is(err, service.ErrReportPeriod) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	if created {
+		return c.JSON(http.StatusAccepted, job)
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+type reportJobInput struct {
+	JobId int `param:"id" validate:"required"`
+}
+
+// @Summary		Get report job
+// @Description	Get report job status: pending, running, done or failed. File of done job is available for download
+// @Tags			report
+// @Accept			json
+// @Produce		json
+// @Param			id	path		int	true	"job id"
+// @Success		200	{object}	service.ReportJobOutput
+// @Failure		400	{object}	echo.HTTPError
+// @Failure		403	{object}	echo.HTTPError
+// @Failure		404	{object}	echo.HTTPError
+// @Failure		500	{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/reports/jobs/{id} [get]
+func (r *reportRouter) getJob(c echo.Context) error {
+	var input reportJobInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	job, err := r.report.GetJob(c.Request().Context(), input.JobId)
+	if err != nil {
+		if errors.Is(err, service.ErrReportJobNotFound) {
+			errorResponse(c, http.StatusNotFound, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// @Summary		Download report
+// @Description	Download file of done report job in the format of the job
+// @Tags			report
+// @Produce		text/csv,json,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param			id	path		int	true	"job id"
+// @Success		200	{file}		file
+// @Failure		400	{object}	echo.HTTPError
+// @Failure		403	{object}	echo.HTTPError
+// @Failure		404	{object}	echo.HTTPError
+// @Failure		409	{object}	echo.HTTPError
+// @Failure		500	{object}	echo.HTTPError
+// @Security		JWT
+// @Router			/api/v1/reports/jobs/{id}/file [get]
+func (r *reportRouter) downloadJob(c echo.Context) error {
+	var input reportJobInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	file, err := r.report.OpenFile(c.Request().Context(), input.JobId)
+	if err != nil {
+		if errors.Is(err, service.ErrReportJobNotFound) {
+			errorResponse(c, http.StatusNotFound, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrReportJobNotDone) {
+			errorResponse(c, http.StatusConflict, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+	defer func() { _ = file.File.Close() }()
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, reportContentType(file.Format))
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"%s\"", file.Name))
+	c.Response().WriteHeader(http.StatusOK)
+
+	// большой файл не успеет уйти за WriteTimeout сервера, поэтому дедлайн продлевается, как и в выгрузке истории
+	w := &deadlineWriter{
+		w:       c.Response(),
+		rc:      http.NewResponseController(c.Response().Writer),
+		timeout: exportWriteTimeout,
+	}
+	_, err = io.Copy(w, file.File)
+	return err
+}
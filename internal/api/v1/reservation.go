@@ -2,9 +2,12 @@ package v1
 
 import (
 	"avito_intership/internal/service"
+	"avito_intership/pkg/money"
+	"context"
 	"errors"
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"time"
 )
 
 type reservationRouter struct {
@@ -17,13 +20,21 @@ func newReservationRouter(g *echo.Group, reservation service.Reservation) {
 	g.POST("/create", r.create)
 	g.DELETE("/cancel", r.cancel)
 	g.POST("/revenue", r.revenue)
+	g.DELETE("/order/cancel", r.cancelOrder)
+	g.POST("/order/revenue", r.revenueOrder)
+	g.POST("/refund", r.refund)
+	g.POST("/order/refund", r.refundOrder)
+	g.GET("", r.list)
+	g.GET("/:id", r.get)
 }
 
 type reservationCreateInput struct {
-	UserId    int     `json:"user_id" validate:"required"`
-	ProductId int     `json:"product_id" validate:"required"`
-	OrderId   int     `json:"order_id" validate:"required"`
-	Amount    float64 `json:"amount" validate:"amount,required"`
+	UserId    int          `json:"user_id" validate:"required"`
+	ProductId int          `json:"product_id" validate:"required"`
+	OrderId   int          `json:"order_id" validate:"required"`
+	Amount    money.Amount `json:"amount" validate:"amount,required" swaggertype:"number"`
+	Currency  string       `json:"currency" validate:"omitempty,iso4217"`
+	ExpiresAt *time.Time   `json:"expires_at"` // если не указано, то используется срок из настроек сервиса
 }
 
 type reservationResponse struct {
@@ -31,13 +42,16 @@ type reservationResponse struct {
 }
 
 //	@Summary		create reservation
-//	@Description	Create product amount reservation
+//	@Description	Create product amount reservation. Reservation without expires_at expires after default service TTL (if configured)
 //	@Tags			reservation
 //	@Accept			json
 //	@Produce		json
 //	@Param			input	body		reservationCreateInput	true	"input"
+//	@Param			Idempotency-Key	header		string					false	"idempotency key, repeated request with the same key and body returns stored result"
 //	@Success		200		{object}	reservationResponse
 //	@Failure		400		{object}	echo.HTTPError
+//	@Failure		403		{object}	limitExceededResponse
+//	@Failure		422		{object}	echo.HTTPError
 //	@Failure		500		{object}	echo.HTTPError
 //	@Security		JWT
 //	@Router			/api/v1/reservations/create [post]
@@ -52,14 +66,33 @@ func (r *reservationRouter) create(c echo.Context) error {
 		errorResponse(c, http.StatusBadRequest, err)
 		return err
 	}
+	key, ok := parseIdempotencyKey(c.Request())
+	if !ok {
+		errorResponse(c, http.StatusBadRequest, ErrInvalidIdempotencyKey)
+		return nil
+	}
 
 	reservationId, err := r.reservation.CreateReservation(c.Request().Context(), service.ReservationInput{
-		UserId:    input.UserId,
-		ProductId: input.ProductId,
-		OrderId:   input.OrderId,
-		Amount:    input.Amount,
+		UserId:         input.UserId,
+		ProductId:      input.ProductId,
+		OrderId:        input.OrderId,
+		Amount:         input.Amount,
+		Currency:       input.Currency,
+		ExpiresAt:      input.ExpiresAt,
+		IdempotencyKey: key,
 	})
 	if err != nil {
+		if errors.Is(err, service.ErrIdempotencyKeyReused) {
+			errorResponse(c, http.StatusUnprocessableEntity, err)
+			return nil
+		}
+		if limitExceeded(c, err) {
+			return nil
+		}
+		if errors.Is(err, service.ErrAccountFrozen) || errors.Is(err, service.ErrAccountClosed) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
 		if !errors.Is(err, service.ErrReservationCannotCreate) {
 			errorResponse(c, http.StatusBadRequest, err)
 			return nil
@@ -83,6 +116,7 @@ type reservationCancelInput struct {
 //	@Param			input	body	reservationCancelInput	true	"input"
 //	@Success		200
 //	@Failure		400	{object}	echo.HTTPError
+//	@Failure		409	{object}	echo.HTTPError
 //	@Failure		500	{object}	echo.HTTPError
 //	@Security		JWT
 //	@Router			/api/v1/reservations/cancel [delete]
@@ -103,6 +137,10 @@ func (r *reservationRouter) cancel(c echo.Context) error {
 			errorResponse(c, http.StatusBadRequest, err)
 			return nil
 		}
+		if errors.Is(err, service.ErrReservationNotActive) {
+			errorResponse(c, http.StatusConflict, err)
+			return nil
+		}
 		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
 		return err
 	}
@@ -111,17 +149,19 @@ func (r *reservationRouter) cancel(c echo.Context) error {
 }
 
 type reservationRevenueInput struct {
-	ReservationId int `json:"reservation_id"`
+	ReservationId int          `json:"reservation_id"`
+	Amount        money.Amount `json:"amount" validate:"omitempty,amount" swaggertype:"number"` // если не указано, то признается вся сумма
 }
 
 //	@Summary		revenue reservation
-//	@Description	confirm reservation
+//	@Description	confirm reservation. If amount is less than reserved, the remainder is returned to the account
 //	@Tags			reservation
 //	@Accept			json
 //	@Produce		json
 //	@Param			input	body	reservationRevenueInput	true	"input"
 //	@Success		200
 //	@Failure		400	{object}	echo.HTTPError
+//	@Failure		409	{object}	echo.HTTPError
 //	@Failure		500	{object}	echo.HTTPError
 //	@Security		JWT
 //	@Router			/api/v1/reservations/revenue [post]
@@ -137,14 +177,273 @@ func (r *reservationRouter) revenue(c echo.Context) error {
 		return err
 	}
 
-	if err := r.reservation.RevenueReservation(c.Request().Context(), input.ReservationId); err != nil {
-		if errors.Is(err, service.ErrReservationNotFound) {
+	err := r.reservation.RevenueReservation(c.Request().Context(), service.RevenueInput{
+		ReservationId: input.ReservationId,
+		Amount:        input.Amount,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrReservationNotFound) || errors.Is(err, service.ErrRevenueExceedsAmount) {
 			errorResponse(c, http.StatusBadRequest, err)
 			return nil
 		}
+		if errors.Is(err, service.ErrReservationNotActive) {
+			errorResponse(c, http.StatusConflict, err)
+			return nil
+		}
 		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
 		return err
 	}
 
 	return c.NoContent(http.StatusOK)
 }
+
+type reservationGetInput struct {
+	ReservationId int `param:"id" validate:"required"`
+}
+
+//	@Summary		Get reservation
+//	@Description	Get reservation with its status and transition timestamps
+//	@Tags			reservation
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		int	true	"reservation id"
+//	@Success		200	{object}	service.ReservationOutput
+//	@Failure		400	{object}	echo.HTTPError
+//	@Failure		404	{object}	echo.HTTPError
+//	@Failure		500	{object}	echo.HTTPError
+//	@Security		JWT
+//	@Router			/api/v1/reservations/{id} [get]
+func (r *reservationRouter) get(c echo.Context) error {
+	var input reservationGetInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	reservation, err := r.reservation.GetReservation(c.Request().Context(), input.ReservationId)
+	if err != nil {
+		if errors.Is(err, service.ErrReservationNotFound) {
+			errorResponse(c, http.StatusNotFound, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, reservation)
+}
+
+type reservationListInput struct {
+	UserId    int    `query:"user_id"`
+	OrderId   int    `query:"order_id"`
+	ProductId int    `query:"product_id"`
+	Status    string `query:"status" validate:"omitempty,oneof=active cancelled recognized expired"`
+	Offset    int    `query:"offset" validate:"min=0"`
+	Limit     int    `query:"limit" validate:"min=0"`
+}
+
+//	@Summary		List reservations
+//	@Description	List reservations by user, order or product, newest first. At least one of user_id, order_id, product_id is required
+//	@Tags			reservation
+//	@Accept			json
+//	@Produce		json
+//	@Param			user_id		query		int		false	"user id"
+//	@Param			order_id	query		int		false	"order id"
+//	@Param			product_id	query		int		false	"product id"
+//	@Param			status		query		string	false	"status"	Enums(active, cancelled, recognized, expired)
+//	@Param			offset		query		int		false	"offset"
+//	@Param			limit		query		int		false	"limit, 20 at most"
+//	@Success		200			{array}		service.ReservationOutput
+//	@Failure		400			{object}	echo.HTTPError
+//	@Failure		500			{object}	echo.HTTPError
+//	@Security		JWT
+//	@Router			/api/v1/reservations [get]
+func (r *reservationRouter) list(c echo.Context) error {
+	var input reservationListInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+	// без фильтра пришлось бы отдавать все резервирования подряд
+	if input.UserId == 0 && input.OrderId == 0 && input.ProductId == 0 {
+		errorResponse(c, http.StatusBadRequest, ErrReservationFilterRequired)
+		return nil
+	}
+
+	reservations, err := r.reservation.GetReservations(c.Request().Context(), service.ReservationsInput{
+		UserId:    input.UserId,
+		OrderId:   input.OrderId,
+		ProductId: input.ProductId,
+		Status:    input.Status,
+		Offset:    input.Offset,
+		Limit:     input.Limit,
+	})
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, reservations)
+}
+
+type reservationOrderInput struct {
+	OrderId int `json:"order_id" validate:"required"`
+}
+
+//	@Summary		cancel order
+//	@Description	cancel all active reservations of the order in one transaction and return money to accounts
+//	@Tags			reservation
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		reservationOrderInput	true	"input"
+//	@Success		200		{object}	service.OrderSettlementOutput
+//	@Failure		400		{object}	echo.HTTPError
+//	@Failure		409		{object}	echo.HTTPError
+//	@Failure		500		{object}	echo.HTTPError
+//	@Security		JWT
+//	@Router			/api/v1/reservations/order/cancel [delete]
+func (r *reservationRouter) cancelOrder(c echo.Context) error {
+	return r.settleOrder(c, r.reservation.CancelOrder)
+}
+
+//	@Summary		revenue order
+//	@Description	confirm all active reservations of the order in one transaction
+//	@Tags			reservation
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		reservationOrderInput	true	"input"
+//	@Success		200		{object}	service.OrderSettlementOutput
+//	@Failure		400		{object}	echo.HTTPError
+//	@Failure		409		{object}	echo.HTTPError
+//	@Failure		500		{object}	echo.HTTPError
+//	@Security		JWT
+//	@Router			/api/v1/reservations/order/revenue [post]
+func (r *reservationRouter) revenueOrder(c echo.Context) error {
+	return r.settleOrder(c, r.reservation.RevenueOrder)
+}
+
+//	@Summary		refund order
+//	@Description	return all recognized and not yet refunded revenue of the order to accounts in one transaction
+//	@Tags			reservation
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		reservationOrderInput	true	"input"
+//	@Success		200		{object}	service.OrderSettlementOutput
+//	@Failure		400		{object}	echo.HTTPError
+//	@Failure		403		{object}	echo.HTTPError
+//	@Failure		409		{object}	echo.HTTPError
+//	@Failure		500		{object}	echo.HTTPError
+//	@Security		JWT
+//	@Router			/api/v1/reservations/order/refund [post]
+func (r *reservationRouter) refundOrder(c echo.Context) error {
+	return r.settleOrder(c, r.reservation.RefundOrder)
+}
+
+func (r *reservationRouter) settleOrder(c echo.Context, settle func(ctx context.Context, orderId int) (service.OrderSettlementOutput, error)) error {
+	var input reservationOrderInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+
+	result, err := settle(c.Request().Context(), input.OrderId)
+	if err != nil {
+		if errors.Is(err, service.ErrOrderNotFound) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrOrderNotActive) || errors.Is(err, service.ErrNothingToRefund) {
+			errorResponse(c, http.StatusConflict, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrAccountClosed) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+type reservationRefundInput struct {
+	ReservationId int          `json:"reservation_id" validate:"required"`
+	Amount        money.Amount `json:"amount" validate:"omitempty,amount" swaggertype:"number"` // если не указано, то возвращается все, что еще не вернули
+}
+
+//	@Summary		refund reservation
+//	@Description	return recognized revenue of the reservation to account, at most recognized amount in total
+//	@Tags			reservation
+//	@Accept			json
+//	@Produce		json
+//	@Param			input			body		reservationRefundInput	true	"input"
+//	@Param			Idempotency-Key	header		string					false	"idempotency key, repeated request with the same key and body returns stored result"
+//	@Success		200				{object}	service.ReservationOutput
+//	@Failure		400				{object}	echo.HTTPError
+//	@Failure		403				{object}	echo.HTTPError
+//	@Failure		409				{object}	echo.HTTPError
+//	@Failure		422				{object}	echo.HTTPError
+//	@Failure		500				{object}	echo.HTTPError
+//	@Security		JWT
+//	@Router			/api/v1/reservations/refund [post]
+func (r *reservationRouter) refund(c echo.Context) error {
+	var input reservationRefundInput
+
+	if err := c.Bind(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, echo.ErrBadRequest)
+		return err
+	}
+	if err := c.Validate(&input); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return err
+	}
+	key, ok := parseIdempotencyKey(c.Request())
+	if !ok {
+		errorResponse(c, http.StatusBadRequest, ErrInvalidIdempotencyKey)
+		return nil
+	}
+
+	reservation, err := r.reservation.RefundReservation(c.Request().Context(), service.RefundInput{
+		ReservationId:  input.ReservationId,
+		Amount:         input.Amount,
+		IdempotencyKey: key,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrReservationNotFound) || errors.Is(err, service.ErrRefundExceedsRevenue) {
+			errorResponse(c, http.StatusBadRequest, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrReservationNotRecognized) || errors.Is(err, service.ErrNothingToRefund) {
+			errorResponse(c, http.StatusConflict, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrAccountClosed) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
+		if errors.Is(err, service.ErrIdempotencyKeyReused) {
+			errorResponse(c, http.StatusUnprocessableEntity, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, echo.ErrInternalServerError)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, reservation)
+}
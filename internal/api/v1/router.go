@@ -3,6 +3,7 @@ package v1
 import (
 	_ "avito_intership/docs"
 	"avito_intership/internal/service"
+	"errors"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	echoSwagger "github.com/swaggo/echo-swagger"
@@ -16,11 +17,16 @@ func NewRouter(h *echo.Echo, services *service.Services) {
 
 	auth := authMiddleware{auth: services.Auth}
 	h.GET("/token", auth.getToken)
+	h.GET("/token/admin", auth.getAdminToken)
 
 	v1 := h.Group("/api/v1", auth.authHandler)
 	newAccountRouter(v1.Group("/accounts"), services.Account)
 	newReservationRouter(v1.Group("/reservations"), services.Reservation)
-	newOperationRouter(v1.Group("/operations"), services.Operation)
+	newOperationRouter(v1.Group("/operations"), services.Operation, auth.adminHandler)
+	newRateRouter(v1.Group("/rates"), services.Rate, auth.adminHandler)
+	newLedgerRouter(v1.Group("/ledger", auth.adminHandler), services.Ledger)
+	newAdminRouter(v1.Group("/admin", auth.adminHandler), services.Account, services.Limit)
+	newReportRouter(v1.Group("/reports", auth.adminHandler), services.Report)
 }
 
 func ping(c echo.Context) error {
@@ -39,3 +45,20 @@ func (h *authMiddleware) getToken(c echo.Context) error {
 	}
 	return c.JSON(http.StatusOK, response{Token: token})
 }
+
+// Админский токен для ручек /admin. Выдается только по ключу администратора в заголовке X-Admin-Key
+func (h *authMiddleware) getAdminToken(c echo.Context) error {
+	type response struct {
+		Token string `json:"token"`
+	}
+	token, err := h.auth.CreateAdminToken(c.Request().Header.Get(adminKeyHeader))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAdminKey) {
+			errorResponse(c, http.StatusForbidden, err)
+			return nil
+		}
+		errorResponse(c, http.StatusInternalServerError, err)
+		return err
+	}
+	return c.JSON(http.StatusOK, response{Token: token})
+}
@@ -6,10 +6,12 @@ import (
 	"avito_intership/internal/repo"
 	"avito_intership/internal/service"
 	"avito_intership/pkg/broker"
+	"avito_intership/pkg/filestore"
 	"avito_intership/pkg/httpserver"
 	"avito_intership/pkg/postgres"
 	"avito_intership/pkg/redis"
 	"avito_intership/pkg/validator"
+	"context"
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	log "github.com/sirupsen/logrus"
@@ -46,8 +48,19 @@ func Run() {
 	}
 	defer pg.Close()
 
-	// redis
-	rdb := redis.NewRedis(cfg.Redis.Url, redis.SetPassword(cfg.Redis.Password))
+	// cache: redis или кэш в памяти процесса для локального запуска без redis
+	var rdb redis.Redis
+	switch cfg.Cache.Backend {
+	case "redis":
+		if cfg.Redis.Url == "" {
+			log.Fatalf("Config error: REDIS_URL is required for redis cache backend")
+		}
+		rdb = redis.NewRedis(cfg.Redis.Url, redis.SetPassword(cfg.Redis.Password))
+	case "memory":
+		rdb = redis.NewMemory(redis.MaxEntries(cfg.Cache.MaxEntries))
+	default:
+		log.Fatalf("Config error: unknown cache backend %q", cfg.Cache.Backend)
+	}
 	defer rdb.Close()
 
 	// database repositories
@@ -60,14 +73,35 @@ func Run() {
 	}
 	defer producer.Close()
 
+	// хранилище файлов отчетов, которые генерируются в фоне
+	reportStore, err := filestore.NewLocal(cfg.Report.StorageDir)
+	if err != nil {
+		log.Fatalf("Initializing report storage error: %s", err)
+	}
+
 	d := &service.ServicesDependencies{
 		Repos:      repos,
 		Producer:   producer,
 		PrivateKey: cfg.JWT.PrivateKey,
 		PublicKey:  cfg.JWT.PublicKey,
+		AdminKey:   cfg.JWT.AdminKey,
+
+		ReservationTTL: cfg.Reservation.TTL,
+		SnapshotDelay:  cfg.Snapshot.Delay,
+
+		ReportStore:      reportStore,
+		ReportJobTimeout: cfg.Report.JobTimeout,
 	}
 	services := service.NewServices(d)
 
+	// фоновые воркеры: relay событий из outbox в kafka, отмена резервирований с истекшим сроком, снимки балансов
+	// и генерация отчетов
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	relayDone := runOutboxRelay(workersCtx, services.Outbox, cfg.Outbox.RelayInterval, cfg.Outbox.Retention)
+	expiryDone := runReservationExpiry(workersCtx, services.Reservation, cfg.Reservation.ExpiryInterval)
+	snapshotDone := runBalanceSnapshots(workersCtx, services.Account, cfg.Snapshot.Interval)
+	reportDone := runReportJobs(workersCtx, services.Report, cfg.Report.JobInterval)
+
 	// validator for incoming messages
 	v, err := validator.NewValidator()
 	if err != nil {
@@ -100,6 +134,11 @@ func Run() {
 	if err != nil {
 		log.Errorf("/app/run http server shutdown error: %s", err)
 	}
+	stopWorkers()
+	<-relayDone
+	<-expiryDone
+	<-snapshotDone
+	<-reportDone
 
 	log.Infof("App shutdown with exit code 0")
 }
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"avito_intership/internal/service"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Фоновая отмена истекших резервирований. Канал закрывается, когда цикл завершился после отмены ctx
+func runReservationExpiry(ctx context.Context, reservation service.Reservation, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// ошибки уже залогированы в сервисе, оставшиеся резервирования отменятся на следующем тике
+				if expired, err := reservation.ExpireReservations(ctx); err == nil && expired > 0 {
+					log.Infof("/app/expiry cancelled %d expired reservations", expired)
+				}
+			}
+		}
+	}()
+	return done
+}
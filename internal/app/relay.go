@@ -0,0 +1,39 @@
+package app
+
+import (
+	"avito_intership/internal/service"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// как часто чистить уже отправленные события
+const outboxCleanupInterval = time.Hour
+
+// Фоновая отправка событий из outbox. Канал закрывается, когда цикл завершился после отмены ctx
+func runOutboxRelay(ctx context.Context, outbox service.Outbox, interval, retention time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		relay := time.NewTicker(interval)
+		defer relay.Stop()
+		cleanup := time.NewTicker(outboxCleanupInterval)
+		defer cleanup.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-relay.C:
+				// ошибки уже залогированы в сервисе, событие отправится на следующем тике
+				_, _ = outbox.Relay(ctx)
+			case <-cleanup.C:
+				if deleted, err := outbox.Cleanup(ctx, retention); err == nil && deleted > 0 {
+					log.Infof("/app/outbox deleted %d sent events", deleted)
+				}
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,34 @@
+package app
+
+import (
+	"avito_intership/internal/service"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Фоновая генерация отчетов из очереди. За один тик очередь разбирается до конца.
+// Канал закрывается, когда цикл завершился после отмены ctx
+func runReportJobs(ctx context.Context, report service.Report, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			// ошибки уже залогированы в сервисе, задачи останутся в очереди до следующего тика
+			if processed, err := report.ProcessJobs(ctx); err == nil && processed > 0 {
+				log.Infof("/app/report processed %d report jobs", processed)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
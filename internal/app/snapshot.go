@@ -0,0 +1,34 @@
+package app
+
+import (
+	"avito_intership/internal/service"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Фоновые снимки балансов на границе дня. Первый запуск сразу, чтобы после простоя не ждать интервал.
+// Канал закрывается, когда цикл завершился после отмены ctx
+func runBalanceSnapshots(ctx context.Context, account service.Account, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			// ошибки уже залогированы в сервисе, неснятые дни снимутся на следующем тике
+			if taken, err := account.TakeBalanceSnapshots(ctx); err == nil && taken > 0 {
+				log.Infof("/app/snapshot took balance snapshots for %d days", taken)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
@@ -1,10 +1,50 @@
 package dbmodel
 
-import "time"
+import (
+	"avito_intership/pkg/money"
+	"time"
+)
+
+// Статусы аккаунта
+const (
+	AccountActive = "active"
+	AccountFrozen = "frozen" // деньги можно зачислить, но нельзя списать
+	AccountClosed = "closed" // никаких движений денег, статус больше не меняется
+)
 
 type Account struct {
+	Id              int       `db:"id"`
+	UserId          int       `db:"user_id"`
+	Status          string    `db:"status"`
+	StatusChangedAt time.Time `db:"status_changed_at"`
+	CreatedAt       time.Time `db:"created_at"`
+}
+
+// OrderHold сумма, удержанная активными резервированиями заказа в одной валюте
+type OrderHold struct {
+	OrderId      int          `db:"order_id"`
+	Currency     string       `db:"currency"`
+	Amount       money.Amount `db:"amount"`
+	Reservations int          `db:"reservations"`
+	ExpiresAt    *time.Time   `db:"expires_at"` // ближайший срок резервирования заказа, если есть
+}
+
+// AccountStatusChange запись истории смены статуса аккаунта
+type AccountStatusChange struct {
 	Id        int       `db:"id"`
 	UserId    int       `db:"user_id"`
-	Balance   float64   `db:"balance"`
+	Status    string    `db:"status"`
+	Reason    string    `db:"reason"`
 	CreatedAt time.Time `db:"created_at"`
 }
+
+// Balance баланс аккаунта в одной валюте. У аккаунта может быть несколько балансов
+type Balance struct {
+	UserId      int          `db:"user_id"`
+	Currency    string       `db:"currency"`
+	Balance     money.Amount `db:"balance"`
+	CreditLimit money.Amount `db:"credit_limit"` // насколько баланс может уйти в минус
+	Reserved    money.Amount `db:"reserved"`     // удержано активными резервированиями, в Balance не входит
+	Version     int64        `db:"version"`      // увеличивается при каждом изменении баланса, нужна для кэша
+	CreatedAt   time.Time    `db:"created_at"`
+}
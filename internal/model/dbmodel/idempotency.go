@@ -0,0 +1,20 @@
+package dbmodel
+
+import "time"
+
+// Области ключей идемпотентности. Один и тот же ключ можно использовать для разных операций
+const (
+	IdempotencyScopeDeposit     = "deposit"
+	IdempotencyScopeWithdraw    = "withdraw"
+	IdempotencyScopeTransfer    = "transfer"
+	IdempotencyScopeReservation = "reservation"
+	IdempotencyScopeRefund      = "refund"
+)
+
+type IdempotencyKey struct {
+	Scope       string    `db:"scope"`
+	Key         string    `db:"key"`
+	RequestHash string    `db:"request_hash"` // хэш тела запроса, чтобы отличить повтор от другого запроса с тем же ключом
+	Response    []byte    `db:"response"`
+	CreatedAt   time.Time `db:"created_at"`
+}
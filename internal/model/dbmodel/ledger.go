@@ -0,0 +1,52 @@
+package dbmodel
+
+import (
+	"avito_intership/pkg/money"
+	"time"
+)
+
+// Системные счета журнала. Деньги пользователей не появляются и не исчезают из ниоткуда:
+// каждое движение - это перемещение между счетом пользователя и системным счетом (или между пользователями)
+const (
+	SystemAccountCashIn   = "cash-in"         // Деньги, пришедшие извне (пополнения)
+	SystemAccountCashOut  = "cash-out"        // Деньги, выведенные наружу (снятия)
+	SystemAccountHolds    = "holds"           // Зарезервированные деньги
+	SystemAccountRevenue  = "revenue"         // Признанная выручка
+	SystemAccountExchange = "exchange"        // Конвертация между валютами
+	SystemAccountOpening  = "opening-balance" // Входящие остатки на момент запуска журнала
+)
+
+// Типы записей журнала, помимо типов операций
+const (
+	EntryTransfer       = "transfer"
+	EntryOpeningBalance = "opening-balance"
+)
+
+// JournalEntry запись журнала - одна бизнес операция
+type JournalEntry struct {
+	Id        int       `db:"id"`
+	Type      string    `db:"type"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Posting проводка по одному счету. Положительная сумма - деньги пришли на счет, отрицательная - ушли.
+// Счет - либо аккаунт пользователя (UserId), либо системный (SystemAccount).
+// Сумма проводок одной записи журнала по каждой валюте всегда равна нулю
+type Posting struct {
+	Id            int          `db:"id"`
+	EntryId       int          `db:"entry_id"`
+	UserId        *int         `db:"user_id"`
+	SystemAccount *string      `db:"system_account"`
+	Currency      string       `db:"currency"`
+	Amount        money.Amount `db:"amount"`
+	CreatedAt     time.Time    `db:"created_at"`
+}
+
+// LedgerImbalance нарушение инварианта журнала, найденное при проверке
+type LedgerImbalance struct {
+	Kind     string       `db:"kind"`
+	Subject  string       `db:"subject"` // запись журнала, счет или валюта, в зависимости от Kind
+	Currency string       `db:"currency"`
+	Expected money.Amount `db:"expected"`
+	Actual   money.Amount `db:"actual"`
+}
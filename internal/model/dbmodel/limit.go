@@ -0,0 +1,43 @@
+package dbmodel
+
+import (
+	"avito_intership/pkg/money"
+	"time"
+)
+
+// Limits - названия лимитов расходов, по ним клиент понимает, какой лимит превышен
+const (
+	LimitPerOperation  = "per_operation"
+	LimitDailyAmount   = "daily_amount"
+	LimitMonthlyAmount = "monthly_amount"
+	LimitDailyCount    = "daily_count"
+	LimitMonthlyCount  = "monthly_count"
+)
+
+// Чей лимит сработал
+const (
+	LimitScopeAccount = "account"
+	LimitScopeGlobal  = "global"
+)
+
+// SpendingLimit лимиты расходов в одной валюте. Пустой UserId - глобальный лимит для всех аккаунтов,
+// пустое поле лимита - ограничения нет
+type SpendingLimit struct {
+	Id            int           `db:"id"`
+	UserId        *int          `db:"user_id"`
+	Currency      string        `db:"currency"`
+	PerOperation  *money.Amount `db:"per_operation"`
+	DailyAmount   *money.Amount `db:"daily_amount"`
+	MonthlyAmount *money.Amount `db:"monthly_amount"`
+	DailyCount    *int          `db:"daily_count"`
+	MonthlyCount  *int          `db:"monthly_count"`
+	UpdatedAt     time.Time     `db:"updated_at"`
+}
+
+// SpendingUsage сколько аккаунт уже потратил в валюте за текущий день и месяц
+type SpendingUsage struct {
+	DailyAmount   money.Amount
+	DailyCount    int
+	MonthlyAmount money.Amount
+	MonthlyCount  int
+}
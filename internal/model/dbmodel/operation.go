@@ -1,6 +1,9 @@
 package dbmodel
 
-import "time"
+import (
+	"avito_intership/pkg/money"
+	"time"
+)
 
 // Operations
 const (
@@ -13,14 +16,109 @@ const (
 	OperationReservation   = "reservation"    // Резервация денег (удержание)
 	OperationDereservation = "de-reservation" // Дерезервация денег (возврат)
 	OperationRevenue       = "revenue"        // Признание выручки
+	OperationRefund        = "refund"         // Возврат денег пользователю после признания выручки
 )
 
 type Operation struct {
-	Id        int       `db:"id"`
-	UserId    int       `db:"user_id"`
-	ProductId *int      `db:"product_id"` // pointer because value in db can be null
-	OrderId   *int      `db:"order_id"`   // pointer because value in db can be null
-	Amount    float64   `db:"amount"`
-	Type      string    `db:"type"`
-	CreatedAt time.Time `db:"created_at"`
+	Id        int          `db:"id"`
+	UserId    int          `db:"user_id"`
+	ProductId *int         `db:"product_id"` // pointer because value in db can be null
+	OrderId   *int         `db:"order_id"`   // pointer because value in db can be null
+	Amount    money.Amount `db:"amount"`
+	Currency  string       `db:"currency"`
+	Rate      *money.Rate  `db:"rate"` // курс конвертации, заполняется только для переводов между разными валютами
+	Type      string       `db:"type"`
+	Overdraft bool         `db:"overdraft"` // списание увело баланс в минус в пределах кредитного лимита
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+// HistoryOrder поле сортировки истории операций
+type HistoryOrder struct {
+	Field string
+	Desc  bool
+}
+
+// HistoryQuery страница истории операций. Нулевые значения фильтров не учитываются.
+// Order всегда заканчивается полем id, чтобы порядок был однозначным.
+// After - значения полей Order последней строки предыдущей страницы (keyset), пустой - страница с начала
+type HistoryQuery struct {
+	UserId      int
+	Types       []string
+	CreatedFrom time.Time    // включительно
+	CreatedTo   time.Time    // не включительно
+	AmountFrom  money.Amount // включительно
+	AmountTo    money.Amount // включительно
+	ProductId   int
+	OrderId     int
+	Order       []HistoryOrder
+	After       []string
+	Offset      int
+	Limit       int
+}
+
+// BalanceChange как операция изменила свободный баланс пользователя. Признание выручки баланс не меняет:
+// деньги ушли с баланса еще при резервировании
+func (o Operation) BalanceChange() money.Amount {
+	switch o.Type {
+	case OperationDeposit, OperationIncomingTransfer, OperationDereservation, OperationRefund:
+		return o.Amount
+	case OperationWithdraw, OperationOutgoingTransfer, OperationReservation:
+		return -o.Amount
+	}
+	return 0
+}
+
+// Statement выписка по аккаунту в одной валюте за период [From, To)
+type Statement struct {
+	OpeningBalance money.Amount
+	Operations     []Operation
+}
+
+// ProductRevenue выручка по услуге в одной валюте: признанная выручка, число признаний и возвраты
+type ProductRevenue struct {
+	ProductId  int          `db:"product_id"`
+	Currency   string       `db:"currency"`
+	Revenue    money.Amount `db:"revenue"`
+	Operations int          `db:"operations"`
+	Refunds    money.Amount `db:"refunds"`
+}
+
+// NetRevenue выручка за вычетом возвратов
+func (r ProductRevenue) NetRevenue() money.Amount {
+	return r.Revenue - r.Refunds
+}
+
+// Периоды группировки отчета по выручке. Неделя начинается с понедельника
+const (
+	ReportPeriodDay     = "day"
+	ReportPeriodWeek    = "week"
+	ReportPeriodMonth   = "month"
+	ReportPeriodQuarter = "quarter"
+)
+
+// RevenueQuery отчет по выручке за [From, To) с группировкой по периодам Period
+// и, если нужно, по услугам и пользователям
+type RevenueQuery struct {
+	From      time.Time
+	To        time.Time
+	Period    string
+	ByProduct bool
+	ByUser    bool
+}
+
+// RevenueGroup выручка за период в одной валюте. ProductId и UserId заполнены, только если была такая разбивка.
+// Period - начало периода, у первого периода оно может быть раньше начала отчета
+type RevenueGroup struct {
+	Period     time.Time
+	ProductId  *int
+	UserId     *int
+	Currency   string
+	Revenue    money.Amount
+	Operations int
+	Refunds    money.Amount
+}
+
+// NetRevenue выручка за вычетом возвратов
+func (r RevenueGroup) NetRevenue() money.Amount {
+	return r.Revenue - r.Refunds
 }
@@ -0,0 +1,42 @@
+package dbmodel
+
+import (
+	"avito_intership/pkg/money"
+	"time"
+)
+
+// OutboxMessage событие, ожидающее отправки в брокер
+type OutboxMessage struct {
+	Id            int64      `db:"id"`
+	UserId        int        `db:"user_id"`
+	Key           string     `db:"key"`
+	Payload       []byte     `db:"payload"`
+	Attempts      int        `db:"attempts"`
+	LastError     *string    `db:"last_error"`
+	NextAttemptAt time.Time  `db:"next_attempt_at"`
+	CreatedAt     time.Time  `db:"created_at"`
+	SentAt        *time.Time `db:"sent_at"`
+}
+
+// Ключи событий по заказу целиком
+const (
+	EventOrderCancelled  = "order-cancellation"
+	EventOrderRecognized = "order-revenue"
+	EventOrderRefunded   = "order-refund"
+)
+
+// Event тело сообщения для микросервиса нотификаций (вымышленного): пользователь + сумма операции
+type Event struct {
+	UserId   int
+	Amount   money.Amount
+	Currency string
+}
+
+// OrderEvent событие на закрытие заказа для одного пользователя: какие его резервирования закрыты и на какие суммы по валютам
+type OrderEvent struct {
+	OrderId        int
+	UserId         int
+	Status         string
+	ReservationIds []int
+	Amounts        map[string]money.Amount
+}
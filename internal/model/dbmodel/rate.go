@@ -0,0 +1,13 @@
+package dbmodel
+
+import (
+	"avito_intership/pkg/money"
+	"time"
+)
+
+type ExchangeRate struct {
+	Base      string     `db:"base"`
+	Quote     string     `db:"quote"`
+	Rate      money.Rate `db:"rate"`
+	UpdatedAt time.Time  `db:"updated_at"`
+}
@@ -0,0 +1,35 @@
+package dbmodel
+
+import "time"
+
+// Статусы задачи на генерацию отчета
+const (
+	ReportJobPending = "pending" // ждет воркера
+	ReportJobRunning = "running" // генерируется
+	ReportJobDone    = "done"    // файл готов
+	ReportJobFailed  = "failed"  // генерация не удалась, нужно создать задачу заново
+)
+
+// Виды отчетов, которые можно сгенерировать в фоне
+const (
+	ReportKindMonthly = "monthly" // месячный отчет по услугам
+	ReportKindRevenue = "revenue" // отчет по выручке за произвольный период
+)
+
+// ReportJob задача на генерацию отчета. Params - параметры отчета в json, их формат зависит от Kind.
+// Одинаковые запросы узнаются по ParamsHash
+type ReportJob struct {
+	Id         int        `db:"id"`
+	Kind       string     `db:"kind"`
+	Format     string     `db:"format"`
+	Params     []byte     `db:"params"`
+	ParamsHash string     `db:"params_hash"`
+	PeriodEnd  time.Time  `db:"period_end"`
+	Status     string     `db:"status"`
+	Attempts   int        `db:"attempts"`
+	Error      *string    `db:"error"`
+	FileName   *string    `db:"file_name"`
+	CreatedAt  time.Time  `db:"created_at"`
+	StartedAt  *time.Time `db:"started_at"`
+	FinishedAt *time.Time `db:"finished_at"`
+}
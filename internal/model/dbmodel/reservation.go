@@ -1,12 +1,48 @@
 package dbmodel
 
-import "time"
+import (
+	"avito_intership/pkg/money"
+	"time"
+)
+
+// Статусы резервирования. Из active можно перейти только в один из конечных статусов
+const (
+	ReservationActive     = "active"
+	ReservationCancelled  = "cancelled"  // деньги вернулись пользователю
+	ReservationRecognized = "recognized" // деньги признаны выручкой
+	ReservationExpired    = "expired"    // вышел срок резервирования, деньги вернулись пользователю
+)
 
 type Reservation struct {
-	Id        int       `db:"id"`
-	UserId    int       `db:"user_id"`
-	ProductId int       `db:"product_id"`
-	OrderId   int       `db:"order_id"`
-	Amount    float64   `db:"amount"`
-	CreatedAt time.Time `db:"created_at"`
+	Id               int           `db:"id"`
+	UserId           int           `db:"user_id"`
+	ProductId        int           `db:"product_id"`
+	OrderId          int           `db:"order_id"`
+	Amount           money.Amount  `db:"amount"`
+	Currency         string        `db:"currency"`
+	Status           string        `db:"status"`
+	RecognizedAmount *money.Amount `db:"recognized_amount"` // может быть меньше Amount, тогда остаток вернулся пользователю
+	RefundedAmount   money.Amount  `db:"refunded_amount"`   // сколько из признанной выручки вернули пользователю
+	CreatedAt        time.Time     `db:"created_at"`
+	ExpiresAt        *time.Time    `db:"expires_at"` // если не указано, то резервирование не истекает
+	CancelledAt      *time.Time    `db:"cancelled_at"`
+	RecognizedAt     *time.Time    `db:"recognized_at"`
+	ExpiredAt        *time.Time    `db:"expired_at"`
+}
+
+// ReservationFilter фильтр для списка резервирований. Нулевые значения не учитываются
+type ReservationFilter struct {
+	UserId    int
+	OrderId   int
+	ProductId int
+	Status    string
+	Offset    int
+	Limit     int
+}
+
+// OrderSettlement результат закрытия заказа по одному резервированию.
+// Settled = false, если резервирование уже было закрыто раньше и осталось как есть
+type OrderSettlement struct {
+	Reservation Reservation
+	Settled     bool
 }
@@ -3,11 +3,12 @@ package pgdb
 import (
 	"avito_intership/internal/model/dbmodel"
 	"avito_intership/internal/repo/pgerrs"
+	"avito_intership/pkg/money"
 	"avito_intership/pkg/postgres"
 	"avito_intership/pkg/redis"
 	"context"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -15,12 +16,7 @@ import (
 	"time"
 )
 
-const (
-	accountPrefixLog = "/pgdb/account"
-	defaultBalanceTL = time.Hour * 72
-)
-
-var key = func(id int) string { return fmt.Sprintf("balance:%d", id) }
+const accountPrefixLog = "/pgdb/account"
 
 type AccountRepo struct {
 	*postgres.Postgres
@@ -56,266 +52,708 @@ func (r *AccountRepo) CreateAccount(ctx context.Context, userId int) error {
 
 // GetBalance смотрим сначала в кэш. Если нет, то идем в базу, там получаем. В конце пытаемся записать баланс в кэш
 // Ошибка не хэндлится, потому что не критично, если не запишем
-func (r *AccountRepo) GetBalance(ctx context.Context, userId int) (float64, error) {
-	balance, err := getCacheBalance(ctx, r.redis, userId)
+func (r *AccountRepo) GetBalance(ctx context.Context, userId int, currency string) (dbmodel.Balance, error) {
+	balance, err := getCacheBalance(ctx, r.redis, userId, currency)
 	if err == nil {
 		return balance, nil
 	}
 	if err != nil {
 		if !errors.Is(err, pgerrs.ErrNotFound) {
-			return 0, err
+			return dbmodel.Balance{}, err
 		}
 	}
 
-	sql, args, _ := r.Builder.
-		Select("balance").
-		From("account").
-		Where("user_id = ?", userId).
-		ToSql()
+	sql, args, _ := balanceQuery(r.Builder, userId, currency).ToSql()
 
-	if err = r.Pool.QueryRow(ctx, sql, args...).Scan(&balance); err != nil {
+	stored := dbmodel.Balance{UserId: userId, Currency: currency}
+	if err = r.Pool.QueryRow(ctx, sql, args...).Scan(&stored.Balance, &stored.CreditLimit, &stored.Reserved, &stored.Version); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return 0, pgerrs.ErrNotFound
+			return dbmodel.Balance{}, pgerrs.ErrNotFound
 		}
 		log.Errorf("%s/GetBalance error get balance: %s", accountPrefixLog, err)
-		return 0, err
+		return dbmodel.Balance{}, err
 	}
 
-	_ = setCacheBalance(ctx, r.redis, userId, balance)
+	// версия из бд не даст перезаписать кэш, если параллельно успело закоммититься новое списание
+	_ = setCacheBalance(ctx, r.redis, stored)
 
-	return balance, nil
+	return stored, nil
 }
 
-// Получение баланса из кэша. Если не найдено, то возвращает ошибку ErrNotFound
-func getCacheBalance(ctx context.Context, redis redis.Redis, userId int) (float64, error) {
-	ok, err := redis.Exists(ctx, key(userId)).Result()
+// GetBalances все балансы аккаунта по валютам. Кэш тут не используется, потому что неизвестно, какие валюты есть у аккаунта
+func (r *AccountRepo) GetBalances(ctx context.Context, userId int) ([]dbmodel.Balance, error) {
+	sql, args, _ := r.Builder.
+		Select("a.user_id", "b.currency", "b.balance", "b.credit_limit", "b.reserved", "b.created_at").
+		From("account a").
+		LeftJoin("account_balance b on b.user_id = a.user_id").
+		Where("a.user_id = ?", userId).
+		OrderBy("b.currency").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
 	if err != nil {
-		log.Errorf("%s/getCacheBalance error check user balance exist: %s", accountPrefixLog, err)
-		return 0, err
+		log.Errorf("%s/GetBalances error get balances: %s", accountPrefixLog, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		found  bool
+		result []dbmodel.Balance
+	)
+	for rows.Next() {
+		var (
+			balance     dbmodel.Balance
+			currency    *string
+			amount      *money.Amount
+			creditLimit *money.Amount
+			reserved    *money.Amount
+			createdAt   *time.Time
+		)
+		if err = rows.Scan(&balance.UserId, &currency, &amount, &creditLimit, &reserved, &createdAt); err != nil {
+			log.Errorf("%s/GetBalances error scan balance: %s", accountPrefixLog, err)
+			return nil, err
+		}
+		found = true
+		if currency == nil { // аккаунт есть, но балансов еще нет
+			continue
+		}
+		balance.Currency, balance.Balance, balance.CreditLimit, balance.Reserved, balance.CreatedAt = *currency, *amount, *creditLimit, *reserved, *createdAt
+		result = append(result, balance)
 	}
-	if ok == 0 {
-		return 0, pgerrs.ErrNotFound
+	if !found {
+		return nil, pgerrs.ErrNotFound
 	}
-	balance, err := redis.Get(ctx, key(userId)).Float64()
-	if err != nil {
-		log.Errorf("%s/getCacheBalance error get balance: %s", accountPrefixLog, err)
-		return 0, err
+	return result, nil
+}
+
+// Запрос баланса в конкретной валюте. Если аккаунт есть, а баланса в этой валюте нет, то баланс нулевой.
+// Если нет самого аккаунта, то запрос вернет pgx.ErrNoRows
+func balanceQuery(builder squirrel.StatementBuilderType, userId int, currency string) squirrel.SelectBuilder {
+	return builder.
+		Select("coalesce(b.balance, 0)", "coalesce(b.credit_limit, 0)", "coalesce(b.reserved, 0)", "coalesce(b.version, 0)").
+		From("account a").
+		LeftJoin("account_balance b on b.user_id = a.user_id and b.currency = ?", currency).
+		Where("a.user_id = ?", userId)
+}
+
+// Вынес в отдельную функцию получение баланса. Сделано чисто под транзакции, хотя даже там можно использовать обычный GetBalance (наверно)
+func getBalanceTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, userId int, currency string) (dbmodel.Balance, error) {
+	balance := dbmodel.Balance{UserId: userId, Currency: currency}
+
+	sql, args, _ := balanceQuery(builder, userId, currency).ToSql()
+
+	if err := tx.QueryRow(ctx, sql, args...).Scan(&balance.Balance, &balance.CreditLimit, &balance.Reserved, &balance.Version); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.Balance{}, pgerrs.ErrNotFound
+		}
+		log.Errorf("%s/getBalanceTx error get balance: %s", accountPrefixLog, err)
+		return dbmodel.Balance{}, err
 	}
 	return balance, nil
 }
 
-// Сохранение (обновление) баланса в кэш. Дефолтное время хранения - 3 дня.
-func setCacheBalance(ctx context.Context, redis redis.Redis, userId int, amount float64) error {
-	if err := redis.Set(ctx, key(userId), amount, defaultBalanceTL).Err(); err != nil {
-		log.Errorf("%s/setCacheBalance error set balance to cache: %s", accountPrefixLog, err)
-		return err
+// Зачисление денег на баланс в нужной валюте. Если баланса в этой валюте еще нет, то он создается.
+// Если аккаунта нет, то возвращается ErrNotFound (срабатывает внешний ключ).
+// Каждое изменение баланса увеличивает его версию, по ней кэш отличает новое значение от старого
+func addBalanceTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, userId int, currency string, amount money.Amount) (dbmodel.Balance, error) {
+	sql, args, _ := builder.
+		Insert("account_balance").
+		Columns("user_id", "currency", "balance", "version").
+		Values(userId, currency, amount, 1).
+		Suffix("on conflict (user_id, currency) do update " +
+			"set balance = account_balance.balance + excluded.balance, version = account_balance.version + 1 " +
+			"returning balance, credit_limit, reserved, version").
+		ToSql()
+
+	balance := dbmodel.Balance{UserId: userId, Currency: currency}
+	if err := tx.QueryRow(ctx, sql, args...).Scan(&balance.Balance, &balance.CreditLimit, &balance.Reserved, &balance.Version); err != nil {
+		var pgErr *pgconn.PgError
+		if ok := errors.As(err, &pgErr); ok {
+			if pgErr.Code == "23503" {
+				return dbmodel.Balance{}, pgerrs.ErrNotFound
+			}
+		}
+		log.Errorf("%s/addBalanceTx error update account balance: %s", accountPrefixLog, err)
+		return dbmodel.Balance{}, err
 	}
-	return nil
+	return balance, nil
 }
 
-// Вынес в отдельную функцию получение баланса. Сделано чисто под транзакции, хотя даже там можно использовать обычный GetBalance (наверно)
-func getBalanceTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, userId int) (float64, error) {
-	var balance float64
+// Списание денег с баланса. Проверка достаточности и списание делаются одним условным update под блокировкой строки,
+// поэтому параллельные списания не могут увести баланс ниже кредитного лимита. Если строка не обновилась, то либо
+// не хватает денег, либо нет аккаунта - это различается отдельным запросом
+func subBalanceTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, userId int, currency string, amount money.Amount) (dbmodel.Balance, error) {
+	return debitBalanceTx(ctx, tx, builder, userId, currency, amount, 0)
+}
 
+// Удержание денег под резервирование: списание с баланса с теми же проверками, что и в subBalanceTx,
+// и перенос суммы в резерв тем же update
+func holdBalanceTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, userId int, currency string, amount money.Amount) (dbmodel.Balance, error) {
+	return debitBalanceTx(ctx, tx, builder, userId, currency, amount, amount)
+}
+
+func debitBalanceTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, userId int, currency string, amount, reserve money.Amount) (dbmodel.Balance, error) {
 	sql, args, _ := builder.
-		Select("balance").
-		From("account").
-		Where("user_id = ?", userId).
+		Update("account_balance").
+		Set("balance", squirrel.Expr("balance - ?", amount)).
+		Set("reserved", squirrel.Expr("reserved + ?", reserve)).
+		Set("version", squirrel.Expr("version + 1")).
+		Where("user_id = ? and currency = ? and balance + credit_limit >= ?", userId, currency, amount).
+		Suffix("returning balance, credit_limit, reserved, version").
 		ToSql()
 
-	if err := tx.QueryRow(ctx, sql, args...).Scan(&balance); err != nil {
+	balance := dbmodel.Balance{UserId: userId, Currency: currency}
+	if err := tx.QueryRow(ctx, sql, args...).Scan(&balance.Balance, &balance.CreditLimit, &balance.Reserved, &balance.Version); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return 0, pgerrs.ErrNotFound
+			if _, err = getBalanceTx(ctx, tx, builder, userId, currency); err != nil {
+				return dbmodel.Balance{}, err
+			}
+			return dbmodel.Balance{}, pgerrs.ErrNotEnoughBalance
 		}
-		log.Errorf("%s/getBalanceTx error get balance: %s", accountPrefixLog, err)
-		return 0, err
+		var pgErr *pgconn.PgError
+		if ok := errors.As(err, &pgErr); ok {
+			if pgErr.Code == "23514" { // на случай, если баланс увели за лимит в обход этой функции
+				return dbmodel.Balance{}, pgerrs.ErrNotEnoughBalance
+			}
+		}
+		log.Errorf("%s/debitBalanceTx error update account balance: %s", accountPrefixLog, err)
+		return dbmodel.Balance{}, err
 	}
 	return balance, nil
 }
 
-func (r *AccountRepo) Deposit(ctx context.Context, userId int, amount float64) error {
-	tx, err := r.Pool.Begin(ctx)
-	if err != nil {
-		log.Errorf("%s/Deposit error init tx: %s", accountPrefixLog, err)
-		return err
+// Снятие удержания: из резерва уходит hold, на баланс возвращается back. При отмене они равны,
+// при признании выручки возвращается только непризнанный остаток
+func releaseHoldTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, userId int, currency string, hold, back money.Amount) (dbmodel.Balance, error) {
+	sql, args, _ := builder.
+		Update("account_balance").
+		Set("balance", squirrel.Expr("balance + ?", back)).
+		Set("reserved", squirrel.Expr("reserved - ?", hold)).
+		Set("version", squirrel.Expr("version + 1")).
+		Where("user_id = ? and currency = ?", userId, currency).
+		Suffix("returning balance, credit_limit, reserved, version").
+		ToSql()
+
+	balance := dbmodel.Balance{UserId: userId, Currency: currency}
+	if err := tx.QueryRow(ctx, sql, args...).Scan(&balance.Balance, &balance.CreditLimit, &balance.Reserved, &balance.Version); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.Balance{}, pgerrs.ErrNotFound
+		}
+		log.Errorf("%s/releaseHoldTx error update account balance: %s", accountPrefixLog, err)
+		return dbmodel.Balance{}, err
 	}
-	defer func() { _ = tx.Rollback(ctx) }()
+	return balance, nil
+}
 
-	var balance float64
-	sql, args, _ := r.Builder.
-		Update("account").
-		Set("balance", squirrel.Expr("balance + ?", amount)).
+// Проверка статуса аккаунта перед движением денег. Строка аккаунта блокируется на чтение до конца транзакции,
+// чтобы статус не поменялся посреди операции. debit - операция списывает деньги с аккаунта:
+// замороженный аккаунт может только получать деньги, закрытый - ничего
+func checkAccountStatusTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, userId int, debit bool) error {
+	sql, args, _ := builder.
+		Select("status").
+		From("account").
 		Where("user_id = ?", userId).
-		Suffix("returning balance").
+		Suffix("for share").
 		ToSql()
 
-	if err = tx.QueryRow(ctx, sql, args...).Scan(&balance); err != nil {
+	var status string
+	if err := tx.QueryRow(ctx, sql, args...).Scan(&status); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return pgerrs.ErrNotFound
 		}
-		log.Errorf("%s/Deposit error update account balance: %s", accountPrefixLog, err)
+		log.Errorf("%s/checkAccountStatusTx error get account status: %s", accountPrefixLog, err)
+		return err
+	}
+	switch {
+	case status == dbmodel.AccountClosed:
+		return pgerrs.ErrAccountClosed
+	case status == dbmodel.AccountFrozen && debit:
+		return pgerrs.ErrAccountFrozen
+	}
+	return nil
+}
+
+// Блокировка строки баланса до списания, чтобы лимиты расходов проверялись раньше достаточности денег,
+// а параллельные списания в той же валюте все равно проверялись по очереди. Если баланса нет, то ничего не блокируется
+func lockBalanceTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, userId int, currency string) error {
+	sql, args, _ := builder.
+		Select("1").
+		From("account_balance").
+		Where("user_id = ? and currency = ?", userId, currency).
+		Suffix("for update").
+		ToSql()
+
+	if _, err := tx.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/lockBalanceTx error lock balance: %s", accountPrefixLog, err)
 		return err
 	}
+	return nil
+}
+
+// Блокировка балансов участников перевода всегда в одном порядке (по user_id, currency),
+// чтобы встречные переводы A->B и B->A не ловили deadlock. Балансы, которых еще нет, просто пропускаются
+func lockBalancesTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, first, second dbmodel.Balance) error {
+	sql, args, _ := builder.
+		Select("1").
+		From("account_balance").
+		Where(squirrel.Or{
+			squirrel.Eq{"user_id": first.UserId, "currency": first.Currency},
+			squirrel.Eq{"user_id": second.UserId, "currency": second.Currency},
+		}).
+		OrderBy("user_id", "currency").
+		Suffix("for update").
+		ToSql()
 
-	if err = setCacheBalance(ctx, r.redis, userId, balance); err != nil {
+	if _, err := tx.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/lockBalancesTx error lock balances: %s", accountPrefixLog, err)
 		return err
 	}
+	return nil
+}
 
-	sql, args, _ = r.Builder.
+func (r *AccountRepo) Deposit(ctx context.Context, userId int, currency string, amount money.Amount, key *dbmodel.IdempotencyKey) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		log.Errorf("%s/Deposit error init tx: %s", accountPrefixLog, err)
+		return err
+	}
+	cache := newCacheTx(r.redis)
+	defer cache.rollback(ctx, tx)
+
+	if _, err = claimIdempotencyKeyTx(ctx, tx, r.Builder, key); err != nil {
+		return err
+	}
+
+	if err = checkAccountStatusTx(ctx, tx, r.Builder, userId, false); err != nil {
+		return err
+	}
+
+	balance, err := addBalanceTx(ctx, tx, r.Builder, userId, currency, amount)
+	if err != nil {
+		return err
+	}
+
+	cache.stage(balance)
+
+	entryId, err := postEntryTx(ctx, tx, r.Builder, dbmodel.OperationDeposit,
+		userPosting(userId, currency, amount),
+		systemPosting(dbmodel.SystemAccountCashIn, currency, -amount),
+	)
+	if err != nil {
+		return err
+	}
+
+	sql, args, _ := r.Builder.
 		Insert("operation").
-		Columns("user_id", "amount", "type").
-		Values(userId, amount, dbmodel.OperationDeposit).
+		Columns("user_id", "amount", "currency", "type", "entry_id").
+		Values(userId, amount, currency, dbmodel.OperationDeposit, entryId).
 		ToSql()
 
 	if _, err = tx.Exec(ctx, sql, args...); err != nil {
 		log.Errorf("%s/Deposit error create operation: %s", accountPrefixLog, err)
 		return err
 	}
-	if err = tx.Commit(ctx); err != nil {
+	if err = pushOutboxTx(ctx, tx, r.Builder, dbmodel.OperationDeposit, dbmodel.Event{
+		UserId:   userId,
+		Amount:   amount,
+		Currency: currency,
+	}); err != nil {
+		return err
+	}
+	if err = saveIdempotentResponseTx(ctx, tx, r.Builder, key, nil); err != nil {
+		return err
+	}
+	if err = cache.commit(ctx, tx); err != nil {
 		log.Errorf("%s/Deposit error commit: %s", accountPrefixLog, err)
 		return err
 	}
 	return nil
 }
 
-func (r *AccountRepo) Withdraw(ctx context.Context, userId int, amount float64) error {
+func (r *AccountRepo) Withdraw(ctx context.Context, userId int, currency string, amount money.Amount, key *dbmodel.IdempotencyKey) error {
 	tx, err := r.Pool.Begin(ctx)
 	if err != nil {
 		log.Errorf("%s/Withdraw error init tx: %s", accountPrefixLog, err)
 		return err
 	}
-	defer func() { _ = tx.Rollback(ctx) }()
+	cache := newCacheTx(r.redis)
+	defer cache.rollback(ctx, tx)
 
-	balance, err := getCacheBalance(ctx, r.redis, userId)
-	if err != nil {
-		if !errors.Is(err, pgerrs.ErrNotFound) {
-			return err
-		}
-		balance, err = getBalanceTx(ctx, tx, r.Builder, userId)
-		if err != nil {
-			return err
-		}
+	if _, err = claimIdempotencyKeyTx(ctx, tx, r.Builder, key); err != nil {
+		return err
 	}
 
-	if balance < amount {
-		return pgerrs.ErrNotEnoughBalance
+	if err = checkAccountStatusTx(ctx, tx, r.Builder, userId, true); err != nil {
+		return err
 	}
 
-	sql, args, _ := r.Builder.
-		Update("account").
-		Set("balance", squirrel.Expr("balance - ?", amount)).
-		Where("user_id = ?", userId).
-		Suffix("returning balance").
-		ToSql()
+	if err = lockBalanceTx(ctx, tx, r.Builder, userId, currency); err != nil {
+		return err
+	}
+	if err = checkSpendingLimitsTx(ctx, tx, r.Builder, userId, currency, amount); err != nil {
+		return err
+	}
 
-	if err = tx.QueryRow(ctx, sql, args...).Scan(&balance); err != nil {
-		log.Errorf("%s/Withdraw error update account balance: %s", accountPrefixLog, err)
+	// кэш для проверки не используется: он может отставать от бд, а решение о списании принимает только бд
+	balance, err := subBalanceTx(ctx, tx, r.Builder, userId, currency, amount)
+	if err != nil {
 		return err
 	}
 
-	if err = setCacheBalance(ctx, r.redis, userId, balance); err != nil {
+	cache.stage(balance)
+
+	entryId, err := postEntryTx(ctx, tx, r.Builder, dbmodel.OperationWithdraw,
+		userPosting(userId, currency, -amount),
+		systemPosting(dbmodel.SystemAccountCashOut, currency, amount),
+	)
+	if err != nil {
 		return err
 	}
 
-	sql, args, _ = r.Builder.
+	sql, args, _ := r.Builder.
 		Insert("operation").
-		Columns("user_id", "amount", "type").
-		Values(userId, amount, dbmodel.OperationWithdraw).
+		Columns("user_id", "amount", "currency", "type", "entry_id", "overdraft").
+		Values(userId, amount, currency, dbmodel.OperationWithdraw, entryId, balance.Balance < 0).
 		ToSql()
 
 	if _, err = tx.Exec(ctx, sql, args...); err != nil {
 		log.Errorf("%s/Withdraw error create operation: %s", accountPrefixLog, err)
 		return err
 	}
-	if err = tx.Commit(ctx); err != nil {
+	if err = pushOutboxTx(ctx, tx, r.Builder, dbmodel.OperationWithdraw, dbmodel.Event{
+		UserId:   userId,
+		Amount:   amount,
+		Currency: currency,
+	}); err != nil {
+		return err
+	}
+	if err = saveIdempotentResponseTx(ctx, tx, r.Builder, key, nil); err != nil {
+		return err
+	}
+	if err = cache.commit(ctx, tx); err != nil {
 		log.Errorf("%s/Withdraw error commit: %s", accountPrefixLog, err)
 		return err
 	}
 	return nil
 }
 
-func (r *AccountRepo) Transfer(ctx context.Context, sendId, receiveId int, amount float64) error {
+// Transfer перевод между аккаунтами. Если валюты отправителя и получателя отличаются,
+// то сумма конвертируется по курсу из exchange_rate, а курс записывается в обе операции.
+// Возвращает сумму, зачисленную получателю (в его валюте)
+func (r *AccountRepo) Transfer(ctx context.Context, sendId, receiveId int, amount money.Amount, fromCurrency, toCurrency string, key *dbmodel.IdempotencyKey) (money.Amount, error) {
 	tx, err := r.Pool.Begin(ctx)
 	if err != nil {
 		log.Errorf("%s/Transfer error init tx: %s", accountPrefixLog, err)
-		return err
+		return 0, err
 	}
-	defer func() { _ = tx.Rollback(ctx) }()
+	cache := newCacheTx(r.redis)
+	defer cache.rollback(ctx, tx)
 
-	balance, err := getCacheBalance(ctx, r.redis, sendId)
+	stored, err := claimIdempotencyKeyTx(ctx, tx, r.Builder, key)
 	if err != nil {
-		if !errors.Is(err, pgerrs.ErrNotFound) {
-			return err
+		if errors.Is(err, pgerrs.ErrIdempotentReplay) {
+			var received money.Amount
+			_ = json.Unmarshal(stored, &received)
+			return received, err
 		}
-		balance, err = getBalanceTx(ctx, tx, r.Builder, sendId)
+		return 0, err
+	}
+
+	if err = checkAccountStatusTx(ctx, tx, r.Builder, sendId, true); err != nil {
+		return 0, err
+	}
+	if err = checkAccountStatusTx(ctx, tx, r.Builder, receiveId, false); err != nil {
+		return 0, err
+	}
+
+	err = lockBalancesTx(ctx, tx, r.Builder,
+		dbmodel.Balance{UserId: sendId, Currency: fromCurrency},
+		dbmodel.Balance{UserId: receiveId, Currency: toCurrency},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	received := amount
+	var rate *money.Rate
+	if fromCurrency != toCurrency {
+		exchangeRate, err := getRateTx(ctx, tx, r.Builder, fromCurrency, toCurrency)
 		if err != nil {
-			return err
+			return 0, err
 		}
+		rate = &exchangeRate
+		received = exchangeRate.Convert(amount)
 	}
 
-	if balance < amount {
-		return pgerrs.ErrNotEnoughBalance
+	// баланс отправителя уже заблокирован, поэтому лимиты проверяются до списания
+	if err = checkSpendingLimitsTx(ctx, tx, r.Builder, sendId, fromCurrency, amount); err != nil {
+		return 0, err
+	}
+	balance, err := subBalanceTx(ctx, tx, r.Builder, sendId, fromCurrency, amount)
+	if err != nil {
+		return 0, err
+	}
+
+	cache.stage(balance)
+	overdraft := balance.Balance < 0
+
+	balance, err = addBalanceTx(ctx, tx, r.Builder, receiveId, toCurrency, received)
+	if err != nil {
+		return 0, err
+	}
+
+	// важно обновить (создать) новый баланс в кэше, потому что если до этого существовало какое-то значение,
+	// то появится проблема несоответствия значений в основной бд и кэше
+	cache.stage(balance)
+
+	// при конвертации деньги проходят через системный счет обмена, чтобы каждая валюта сходилась в ноль отдельно
+	postings := []dbmodel.Posting{
+		userPosting(sendId, fromCurrency, -amount),
+		userPosting(receiveId, toCurrency, received),
+	}
+	if fromCurrency != toCurrency {
+		postings = append(postings,
+			systemPosting(dbmodel.SystemAccountExchange, fromCurrency, amount),
+			systemPosting(dbmodel.SystemAccountExchange, toCurrency, -received),
+		)
+	}
+	entryId, err := postEntryTx(ctx, tx, r.Builder, dbmodel.EntryTransfer, postings...)
+	if err != nil {
+		return 0, err
 	}
 
+	// нужно записать туда и обратно, каждая сторона в своей валюте
 	sql, args, _ := r.Builder.
-		Update("account").
-		Set("balance", squirrel.Expr("balance - ?", amount)).
-		Where("user_id = ?", sendId).
-		Suffix("returning balance").
+		Insert("operation").
+		Columns("user_id", "amount", "currency", "rate", "type", "entry_id", "overdraft").
+		Values(sendId, amount, fromCurrency, rate, dbmodel.OperationOutgoingTransfer, entryId, overdraft).
+		Values(receiveId, received, toCurrency, rate, dbmodel.OperationIncomingTransfer, entryId, false).
 		ToSql()
 
-	if err = tx.QueryRow(ctx, sql, args...).Scan(&balance); err != nil {
-		log.Errorf("%s/Transfer error update sender account balance: %s", accountPrefixLog, err)
-		return err
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/Transfer error create operation: %s", accountPrefixLog, err)
+		return 0, err
+	}
+	// очевидно, что для того кто отправил перевод уведомление не нужно
+	if err = pushOutboxTx(ctx, tx, r.Builder, dbmodel.OperationIncomingTransfer, dbmodel.Event{
+		UserId:   receiveId,
+		Amount:   received,
+		Currency: toCurrency,
+	}); err != nil {
+		return 0, err
+	}
+	if err = saveIdempotentResponseTx(ctx, tx, r.Builder, key, received); err != nil {
+		return 0, err
 	}
 
-	if err = setCacheBalance(ctx, r.redis, sendId, balance); err != nil {
-		return err
+	if err = cache.commit(ctx, tx); err != nil {
+		log.Errorf("%s/Transfer error commit: %s", accountPrefixLog, err)
+		return 0, err
 	}
+	return received, nil
+}
 
+// GetHolds удержания аккаунта по заказам: сумма и количество активных резервирований заказа в каждой валюте.
+// Пустая валюта - все валюты
+func (r *AccountRepo) GetHolds(ctx context.Context, userId int, currency string) ([]dbmodel.OrderHold, error) {
+	query := r.Builder.
+		Select("order_id", "currency", "sum(amount)::bigint as amount", "count(*)::int as reservations", "min(expires_at) as expires_at").
+		From("reservation").
+		Where("user_id = ? and status = ?", userId, dbmodel.ReservationActive).
+		GroupBy("order_id", "currency").
+		OrderBy("order_id", "currency")
+	if currency != "" {
+		query = query.Where("currency = ?", currency)
+	}
+	sql, args, _ := query.ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/GetHolds error get holds: %s", accountPrefixLog, err)
+		return nil, err
+	}
+	holds, err := pgx.CollectRows(rows, pgx.RowToStructByName[dbmodel.OrderHold])
+	if err != nil {
+		log.Errorf("%s/GetHolds error scan holds: %s", accountPrefixLog, err)
+		return nil, err
+	}
+	if len(holds) > 0 {
+		return holds, nil
+	}
+
+	// удержаний нет - отличаем пустой аккаунт от несуществующего
 	sql, args, _ = r.Builder.
-		Update("account").
-		Set("balance", squirrel.Expr("balance + ?", amount)).
-		Where("user_id = ?", receiveId).
-		Suffix("returning balance").
+		Select("1").
+		From("account").
+		Where("user_id = ?", userId).
 		ToSql()
+	var exists int
+	if err = r.Pool.QueryRow(ctx, sql, args...).Scan(&exists); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, pgerrs.ErrNotFound
+		}
+		log.Errorf("%s/GetHolds error check account: %s", accountPrefixLog, err)
+		return nil, err
+	}
+	return holds, nil
+}
+
+// SetCreditLimit установка кредитного лимита для баланса в валюте. Если баланса в этой валюте еще нет, то он создается.
+// Лимит нельзя сделать меньше текущего долга, иначе баланс окажется за пределами лимита
+func (r *AccountRepo) SetCreditLimit(ctx context.Context, userId int, currency string, limit money.Amount) (dbmodel.Balance, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		log.Errorf("%s/SetCreditLimit error init tx: %s", accountPrefixLog, err)
+		return dbmodel.Balance{}, err
+	}
+	cache := newCacheTx(r.redis)
+	defer cache.rollback(ctx, tx)
 
-	if err = tx.QueryRow(ctx, sql, args...).Scan(&balance); err != nil {
-		log.Errorf("%s/Transfer error update receiver account balance: %s", accountPrefixLog, err)
+	if err = checkAccountStatusTx(ctx, tx, r.Builder, userId, false); err != nil {
+		return dbmodel.Balance{}, err
+	}
+
+	// версия увеличивается, чтобы в кэше не остался старый лимит
+	sql, args, _ := r.Builder.
+		Insert("account_balance").
+		Columns("user_id", "currency", "balance", "credit_limit", "version").
+		Values(userId, currency, 0, limit, 1).
+		Suffix("on conflict (user_id, currency) do update " +
+			"set credit_limit = excluded.credit_limit, version = account_balance.version + 1 " +
+			"returning balance, credit_limit, reserved, version").
+		ToSql()
+
+	balance := dbmodel.Balance{UserId: userId, Currency: currency}
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&balance.Balance, &balance.CreditLimit, &balance.Reserved, &balance.Version); err != nil {
+		var pgErr *pgconn.PgError
+		if ok := errors.As(err, &pgErr); ok {
+			if pgErr.Code == "23514" {
+				return dbmodel.Balance{}, pgerrs.ErrCreditLimitDebt
+			}
+		}
+		log.Errorf("%s/SetCreditLimit error update credit limit: %s", accountPrefixLog, err)
+		return dbmodel.Balance{}, err
+	}
+
+	cache.stage(balance)
+
+	if err = cache.commit(ctx, tx); err != nil {
+		log.Errorf("%s/SetCreditLimit error commit: %s", accountPrefixLog, err)
+		return dbmodel.Balance{}, err
+	}
+	return balance, nil
+}
+
+// SetStatus смена статуса аккаунта с записью причины в историю. Закрытый аккаунт больше не меняет статус,
+// закрыть можно только аккаунт без денег и активных резервирований
+func (r *AccountRepo) SetStatus(ctx context.Context, userId int, status, reason string) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		log.Errorf("%s/SetStatus error init tx: %s", accountPrefixLog, err)
 		return err
 	}
+	defer func() { _ = tx.Rollback(ctx) }()
 
-	// важно обновить (создать) новый баланс в кэше, потому что если до этого существовало какое-то значение,
-	// то появится проблема несоответствия значений в основной бд и кэше
-	if err = setCacheBalance(ctx, r.redis, receiveId, balance); err != nil {
+	// блокировка на запись ждет, пока закончатся операции, которые уже проверили статус
+	sql, args, _ := r.Builder.
+		Select("status").
+		From("account").
+		Where("user_id = ?", userId).
+		Suffix("for update").
+		ToSql()
+
+	var current string
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&current); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pgerrs.ErrNotFound
+		}
+		log.Errorf("%s/SetStatus error get account status: %s", accountPrefixLog, err)
 		return err
 	}
+	if current == dbmodel.AccountClosed {
+		return pgerrs.ErrAccountClosed
+	}
+
+	if status == dbmodel.AccountClosed {
+		sql, args, _ = r.Builder.
+			Select().
+			Column(squirrel.Expr("exists(select 1 from account_balance where user_id = ? and balance <> 0) or "+
+				"exists(select 1 from reservation where user_id = ? and status = ?)", userId, userId, dbmodel.ReservationActive)).
+			ToSql()
+
+		var notEmpty bool
+		if err = tx.QueryRow(ctx, sql, args...).Scan(&notEmpty); err != nil {
+			log.Errorf("%s/SetStatus error check account is empty: %s", accountPrefixLog, err)
+			return err
+		}
+		if notEmpty {
+			return pgerrs.ErrAccountNotEmpty
+		}
+	}
 
 	sql, args, _ = r.Builder.
-		Insert("operation").
-		Columns("user_id", "amount", "type").
-		Values(sendId, amount, dbmodel.OperationOutgoingTransfer).
+		Update("account").
+		Set("status", status).
+		Set("status_changed_at", squirrel.Expr("now()")).
+		Where("user_id = ?", userId).
 		ToSql()
-
 	if _, err = tx.Exec(ctx, sql, args...); err != nil {
-		log.Errorf("%s/Transfer error create operation: %s", accountPrefixLog, err)
+		log.Errorf("%s/SetStatus error update account status: %s", accountPrefixLog, err)
 		return err
 	}
-	args[0], args[2] = receiveId, dbmodel.OperationIncomingTransfer // нужно записать туда и обратно
+
+	sql, args, _ = r.Builder.
+		Insert("account_status_history").
+		Columns("user_id", "status", "reason").
+		Values(userId, status, reason).
+		ToSql()
 	if _, err = tx.Exec(ctx, sql, args...); err != nil {
-		var pgErr *pgconn.PgError
-		if ok := errors.As(err, &pgErr); ok {
-			if pgErr.Code == "23503" { // Ошибка несуществующего получателя появляется только в этом месте
-				return pgerrs.ErrNotFound
-			}
-		}
-		log.Errorf("%s/Transfer error create operation: %s", accountPrefixLog, err)
+		log.Errorf("%s/SetStatus error create status history: %s", accountPrefixLog, err)
 		return err
 	}
 
 	if err = tx.Commit(ctx); err != nil {
-		log.Errorf("%s/Transfer error commit: %s", accountPrefixLog, err)
+		log.Errorf("%s/SetStatus error commit: %s", accountPrefixLog, err)
 		return err
 	}
 	return nil
 }
+
+// GetStatus текущий статус аккаунта и история его изменений, последние изменения сначала
+func (r *AccountRepo) GetStatus(ctx context.Context, userId int) (dbmodel.Account, []dbmodel.AccountStatusChange, error) {
+	sql, args, _ := r.Builder.
+		Select("id", "user_id", "status", "status_changed_at", "created_at").
+		From("account").
+		Where("user_id = ?", userId).
+		ToSql()
+
+	var account dbmodel.Account
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&account.Id, &account.UserId, &account.Status, &account.StatusChangedAt, &account.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.Account{}, nil, pgerrs.ErrNotFound
+		}
+		log.Errorf("%s/GetStatus error get account: %s", accountPrefixLog, err)
+		return dbmodel.Account{}, nil, err
+	}
+
+	sql, args, _ = r.Builder.
+		Select("id", "user_id", "status", "reason", "created_at").
+		From("account_status_history").
+		Where("user_id = ?", userId).
+		OrderBy("id desc").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/GetStatus error get status history: %s", accountPrefixLog, err)
+		return dbmodel.Account{}, nil, err
+	}
+	history, err := pgx.CollectRows(rows, pgx.RowToStructByPos[dbmodel.AccountStatusChange])
+	if err != nil {
+		log.Errorf("%s/GetStatus error scan status history: %s", accountPrefixLog, err)
+		return dbmodel.Account{}, nil, err
+	}
+	return account, history, nil
+}
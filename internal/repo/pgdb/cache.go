@@ -0,0 +1,129 @@
+package pgdb
+
+import (
+	"avito_intership/internal/model/dbmodel"
+	"avito_intership/internal/repo/pgerrs"
+	"avito_intership/pkg/money"
+	"avito_intership/pkg/redis"
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cachePrefixLog   = "/pgdb/cache"
+	defaultBalanceTL = time.Hour * 72
+)
+
+// в ключе указаны единицы хранения и формат значения, чтобы не читать старые значения в другом формате
+var key = func(id int, currency string) string { return fmt.Sprintf("balance:v4:%d:%s", id, currency) }
+
+// Получение баланса из кэша. Если не найдено, то возвращает ошибку ErrNotFound
+func getCacheBalance(ctx context.Context, redis redis.Redis, userId int, currency string) (dbmodel.Balance, error) {
+	ok, err := redis.Exists(ctx, key(userId, currency)).Result()
+	if err != nil {
+		log.Errorf("%s/getCacheBalance error check user balance exist: %s", cachePrefixLog, err)
+		return dbmodel.Balance{}, err
+	}
+	if ok == 0 {
+		return dbmodel.Balance{}, pgerrs.ErrNotFound
+	}
+	value, err := redis.Get(ctx, key(userId, currency)).Result()
+	if err != nil {
+		log.Errorf("%s/getCacheBalance error get balance: %s", cachePrefixLog, err)
+		return dbmodel.Balance{}, err
+	}
+	// значение хранится как "version:balance:credit_limit:reserved"
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 {
+		log.Errorf("%s/getCacheBalance error parse balance %q: unexpected format", cachePrefixLog, value)
+		return dbmodel.Balance{}, pgerrs.ErrNotFound
+	}
+	balance, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		log.Errorf("%s/getCacheBalance error parse balance %q: %s", cachePrefixLog, value, err)
+		return dbmodel.Balance{}, pgerrs.ErrNotFound
+	}
+	creditLimit, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		log.Errorf("%s/getCacheBalance error parse credit limit %q: %s", cachePrefixLog, value, err)
+		return dbmodel.Balance{}, pgerrs.ErrNotFound
+	}
+	reserved, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		log.Errorf("%s/getCacheBalance error parse reserved %q: %s", cachePrefixLog, value, err)
+		return dbmodel.Balance{}, pgerrs.ErrNotFound
+	}
+	return dbmodel.Balance{
+		UserId:      userId,
+		Currency:    currency,
+		Balance:     money.Amount(balance),
+		CreditLimit: money.Amount(creditLimit),
+		Reserved:    money.Amount(reserved),
+	}, nil
+}
+
+// Сохранение баланса в кэш с версией из бд. Дефолтное время хранения - 3 дня. Суммы хранятся в копейках.
+// Если в кэше уже лежит более новая версия, то ничего не меняется
+func setCacheBalance(ctx context.Context, redis redis.Redis, balance dbmodel.Balance) error {
+	value := fmt.Sprintf("%d:%d:%d", balance.Balance, balance.CreditLimit, balance.Reserved)
+	err := redis.SetNewer(ctx, key(balance.UserId, balance.Currency), balance.Version, value, defaultBalanceTL).Err()
+	if err != nil {
+		log.Errorf("%s/setCacheBalance error set balance to cache: %s", cachePrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+// cacheTx копит изменения балансов, сделанные в транзакции, и пишет их в кэш только после успешного коммита.
+// Если транзакция откатилась или коммит завершился ошибкой (исход неизвестен), то ключи удаляются из кэша,
+// и следующее чтение возьмет баланс из бд
+type cacheTx struct {
+	redis     redis.Redis
+	balances  []dbmodel.Balance
+	committed bool
+}
+
+func newCacheTx(redis redis.Redis) *cacheTx {
+	return &cacheTx{redis: redis}
+}
+
+// stage запоминает новый баланс, в кэш он попадет после коммита
+func (c *cacheTx) stage(balance dbmodel.Balance) {
+	c.balances = append(c.balances, balance)
+}
+
+// commit коммитит транзакцию и после этого обновляет кэш. Ошибка кэша не ломает операцию - в бд все уже записано
+func (c *cacheTx) commit(ctx context.Context, tx pgx.Tx) error {
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	c.committed = true
+	for _, balance := range c.balances {
+		if err := setCacheBalance(ctx, c.redis, balance); err != nil {
+			c.invalidate(ctx, balance)
+		}
+	}
+	return nil
+}
+
+// rollback вызывается через defer. После успешного коммита ничего не делает
+func (c *cacheTx) rollback(ctx context.Context, tx pgx.Tx) {
+	if c.committed {
+		return
+	}
+	_ = tx.Rollback(ctx)
+	for _, balance := range c.balances {
+		c.invalidate(ctx, balance)
+	}
+}
+
+func (c *cacheTx) invalidate(ctx context.Context, balance dbmodel.Balance) {
+	if err := c.redis.Del(ctx, key(balance.UserId, balance.Currency)).Err(); err != nil {
+		log.Errorf("%s/invalidate error delete balance %d %s from cache: %s", cachePrefixLog, balance.UserId, balance.Currency, err)
+	}
+}
@@ -0,0 +1,83 @@
+package pgdb
+
+import (
+	"avito_intership/internal/model/dbmodel"
+	"avito_intership/internal/repo/pgerrs"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+const idempotencyPrefixLog = "/pgdb/idempotency"
+
+// Захват ключа идемпотентности в начале транзакции операции. Если ключа нет (nil), то ничего не делает.
+// Если такой ключ уже был сохранен, то возвращает сохраненный ответ и ErrIdempotentReplay,
+// а если ключ был использован с другим телом запроса - ErrIdempotencyKeyReused.
+// Параллельный запрос с тем же ключом ждет на вставке, пока первая транзакция не завершится
+func claimIdempotencyKeyTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, key *dbmodel.IdempotencyKey) ([]byte, error) {
+	if key == nil {
+		return nil, nil
+	}
+
+	sql, args, _ := builder.
+		Insert("idempotency_key").
+		Columns("scope", "key", "request_hash").
+		Values(key.Scope, key.Key, key.RequestHash).
+		Suffix("on conflict (scope, key) do nothing").
+		ToSql()
+
+	tag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/claimIdempotencyKeyTx error insert key: %s", idempotencyPrefixLog, err)
+		return nil, err
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	sql, args, _ = builder.
+		Select("request_hash", "response").
+		From("idempotency_key").
+		Where("scope = ? and key = ?", key.Scope, key.Key).
+		ToSql()
+
+	var stored dbmodel.IdempotencyKey
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&stored.RequestHash, &stored.Response); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, pgerrs.ErrNotFound
+		}
+		log.Errorf("%s/claimIdempotencyKeyTx error get stored key: %s", idempotencyPrefixLog, err)
+		return nil, err
+	}
+	if stored.RequestHash != key.RequestHash {
+		return nil, pgerrs.ErrIdempotencyKeyReused
+	}
+	return stored.Response, pgerrs.ErrIdempotentReplay
+}
+
+// Сохранение ответа операции рядом с ключом. Вызывается в той же транзакции, что и claimIdempotencyKeyTx
+func saveIdempotentResponseTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, key *dbmodel.IdempotencyKey, response any) error {
+	if key == nil {
+		return nil
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		log.Errorf("%s/saveIdempotentResponseTx error marshal response: %s", idempotencyPrefixLog, err)
+		return err
+	}
+
+	sql, args, _ := builder.
+		Update("idempotency_key").
+		Set("response", body).
+		Where("scope = ? and key = ?", key.Scope, key.Key).
+		ToSql()
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/saveIdempotentResponseTx error save response: %s", idempotencyPrefixLog, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package pgdb
+
+import (
+	"avito_intership/internal/model/dbmodel"
+	"avito_intership/internal/repo/pgerrs"
+	"avito_intership/pkg/money"
+	"avito_intership/pkg/postgres"
+	"context"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+const ledgerPrefixLog = "/pgdb/ledger"
+
+// Виды нарушений, которые ищет проверка журнала
+const (
+	imbalanceEntry    = "unbalanced-entry"  // проводки записи журнала не сходятся в ноль
+	imbalanceTotal    = "unbalanced-total"  // сумма всех проводок по валюте не равна нулю
+	imbalanceBalance  = "balance-mismatch"  // баланс аккаунта не совпадает с суммой его проводок
+	imbalanceHolds    = "holds-mismatch"    // счет резервов не совпадает с суммой активных резервирований
+	imbalanceReserved = "reserved-mismatch" // резерв аккаунта не совпадает с суммой его активных резервирований
+)
+
+type LedgerRepo struct {
+	*postgres.Postgres
+}
+
+func NewLedgerRepo(pg *postgres.Postgres) *LedgerRepo {
+	return &LedgerRepo{pg}
+}
+
+func userPosting(userId int, currency string, amount money.Amount) dbmodel.Posting {
+	return dbmodel.Posting{UserId: &userId, Currency: currency, Amount: amount}
+}
+
+func systemPosting(account string, currency string, amount money.Amount) dbmodel.Posting {
+	return dbmodel.Posting{SystemAccount: &account, Currency: currency, Amount: amount}
+}
+
+// Запись операции в журнал. Создает запись журнала и ее проводки, возвращает id записи для связи с operation.
+// Баланс проверяется здесь, чтобы ошибка была понятной, и еще раз триггером в бд при коммите
+func postEntryTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, entryType string, postings ...dbmodel.Posting) (int, error) {
+	sums := make(map[string]money.Amount)
+	for _, p := range postings {
+		sums[p.Currency] += p.Amount
+	}
+	for currency, sum := range sums {
+		if sum != 0 {
+			log.Errorf("%s/postEntryTx %s entry is not balanced in %s: %s", ledgerPrefixLog, entryType, currency, sum)
+			return 0, pgerrs.ErrUnbalancedEntry
+		}
+	}
+
+	sql, args, _ := builder.
+		Insert("journal_entry").
+		Columns("type").
+		Values(entryType).
+		Suffix("returning id").
+		ToSql()
+
+	var entryId int
+	if err := tx.QueryRow(ctx, sql, args...).Scan(&entryId); err != nil {
+		log.Errorf("%s/postEntryTx error create journal entry: %s", ledgerPrefixLog, err)
+		return 0, err
+	}
+
+	insert := builder.
+		Insert("posting").
+		Columns("entry_id", "user_id", "system_account", "currency", "amount")
+	for _, p := range postings {
+		if p.Amount == 0 { // например, при конвертации маленькой суммы; нулевые проводки не пишем
+			continue
+		}
+		insert = insert.Values(entryId, p.UserId, p.SystemAccount, p.Currency, p.Amount)
+	}
+
+	sql, args, _ = insert.ToSql()
+	if _, err := tx.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/postEntryTx error create postings: %s", ledgerPrefixLog, err)
+		return 0, err
+	}
+	return entryId, nil
+}
+
+// Check проверка инвариантов журнала: каждая запись и журнал в целом сходятся в ноль,
+// балансы аккаунтов и сумма активных резервирований совпадают с проводками, резерв каждого аккаунта - с его резервированиями.
+// Пустой результат - журнал в порядке
+func (r *LedgerRepo) Check(ctx context.Context) ([]dbmodel.LedgerImbalance, error) {
+	checks := map[string]squirrel.SelectBuilder{
+		imbalanceEntry: r.Builder.
+			Select("entry_id::text", "currency", "0::bigint", "sum(amount)::bigint").
+			From("posting").
+			GroupBy("entry_id", "currency").
+			Having("sum(amount) <> 0"),
+		imbalanceTotal: r.Builder.
+			Select("'ledger'", "currency", "0::bigint", "sum(amount)::bigint").
+			From("posting").
+			GroupBy("currency").
+			Having("sum(amount) <> 0"),
+		imbalanceBalance: r.Builder.
+			Select("b.user_id::text", "b.currency", "b.balance", "coalesce(sum(p.amount), 0)::bigint").
+			From("account_balance b").
+			LeftJoin("posting p on p.user_id = b.user_id and p.currency = b.currency").
+			GroupBy("b.user_id", "b.currency", "b.balance").
+			Having("b.balance <> coalesce(sum(p.amount), 0)"),
+		imbalanceHolds: r.Builder.
+			Select("'"+dbmodel.SystemAccountHolds+"'", "currency", "sum(expected)::bigint", "sum(actual)::bigint").
+			From("(select currency, amount as expected, 0 as actual from reservation where status = '" + dbmodel.ReservationActive + "' " +
+				"union all " +
+				"select currency, 0, amount from posting where system_account = '" + dbmodel.SystemAccountHolds + "') h").
+			GroupBy("currency").
+			Having("sum(expected) <> sum(actual)"),
+		imbalanceReserved: r.Builder.
+			Select("b.user_id::text", "b.currency", "coalesce(sum(r.amount), 0)::bigint", "b.reserved").
+			From("account_balance b").
+			LeftJoin("reservation r on r.user_id = b.user_id and r.currency = b.currency and r.status = '"+dbmodel.ReservationActive+"'").
+			GroupBy("b.user_id", "b.currency", "b.reserved").
+			Having("b.reserved <> coalesce(sum(r.amount), 0)"),
+	}
+
+	var result []dbmodel.LedgerImbalance
+	for _, kind := range []string{imbalanceEntry, imbalanceTotal, imbalanceBalance, imbalanceHolds, imbalanceReserved} {
+		sql, args, _ := checks[kind].ToSql()
+
+		rows, err := r.Pool.Query(ctx, sql, args...)
+		if err != nil {
+			log.Errorf("%s/Check error check %s: %s", ledgerPrefixLog, kind, err)
+			return nil, err
+		}
+		for rows.Next() {
+			imbalance := dbmodel.LedgerImbalance{Kind: kind}
+			if err = rows.Scan(&imbalance.Subject, &imbalance.Currency, &imbalance.Expected, &imbalance.Actual); err != nil {
+				rows.Close()
+				log.Errorf("%s/Check error scan %s: %s", ledgerPrefixLog, kind, err)
+				return nil, err
+			}
+			result = append(result, imbalance)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			log.Errorf("%s/Check error read %s: %s", ledgerPrefixLog, kind, err)
+			return nil, err
+		}
+	}
+	return result, nil
+}
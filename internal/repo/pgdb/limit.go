@@ -0,0 +1,233 @@
+package pgdb
+
+import (
+	"avito_intership/internal/model/dbmodel"
+	"avito_intership/internal/repo/pgerrs"
+	"avito_intership/pkg/money"
+	"avito_intership/pkg/postgres"
+	"context"
+	"errors"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	limitPrefixLog = "/pgdb/limit"
+
+	spendingLimitColumns = "id, user_id, currency, per_operation, daily_amount, monthly_amount, daily_count, monthly_count, updated_at"
+)
+
+// списания, которые учитываются в лимитах расходов. Резервирования считаются по таблице reservation,
+// потому что отмененное или истекшее резервирование деньги вернуло и в расходы не входит
+var (
+	spendingOperations   = []string{dbmodel.OperationWithdraw, dbmodel.OperationOutgoingTransfer}
+	spendingReservations = []string{dbmodel.ReservationActive, dbmodel.ReservationRecognized}
+)
+
+type LimitRepo struct {
+	*postgres.Postgres
+}
+
+func NewLimitRepo(pg *postgres.Postgres) *LimitRepo {
+	return &LimitRepo{pg}
+}
+
+// SetLimit создание или замена лимитов аккаунта (или глобальных, если UserId пустой) в валюте.
+// Поля лимита заменяются целиком: если поле не указано, то ограничение снимается
+func (r *LimitRepo) SetLimit(ctx context.Context, limit dbmodel.SpendingLimit) (dbmodel.SpendingLimit, error) {
+	// у глобальных и аккаунтных лимитов разные уникальные индексы, поэтому и конфликт разный
+	conflict := "on conflict (user_id, currency) where user_id is not null"
+	if limit.UserId == nil {
+		conflict = "on conflict (currency) where user_id is null"
+	}
+
+	sql, args, _ := r.Builder.
+		Insert("spending_limit").
+		Columns("user_id", "currency", "per_operation", "daily_amount", "monthly_amount", "daily_count", "monthly_count").
+		Values(limit.UserId, limit.Currency, limit.PerOperation, limit.DailyAmount, limit.MonthlyAmount, limit.DailyCount, limit.MonthlyCount).
+		Suffix(conflict + " do update set " +
+			"per_operation = excluded.per_operation, daily_amount = excluded.daily_amount, " +
+			"monthly_amount = excluded.monthly_amount, daily_count = excluded.daily_count, " +
+			"monthly_count = excluded.monthly_count, updated_at = now() " +
+			"returning " + spendingLimitColumns).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/SetLimit error set limit: %s", limitPrefixLog, err)
+		return dbmodel.SpendingLimit{}, err
+	}
+	stored, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[dbmodel.SpendingLimit])
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if ok := errors.As(err, &pgErr); ok {
+			if pgErr.Code == "23503" {
+				return dbmodel.SpendingLimit{}, pgerrs.ErrNotFound
+			}
+		}
+		log.Errorf("%s/SetLimit error set limit: %s", limitPrefixLog, err)
+		return dbmodel.SpendingLimit{}, err
+	}
+	return stored, nil
+}
+
+// GetLimits лимиты, которые действуют на аккаунт: его собственные и глобальные. Если userId пустой, то только глобальные
+func (r *LimitRepo) GetLimits(ctx context.Context, userId *int) ([]dbmodel.SpendingLimit, error) {
+	query := r.Builder.
+		Select(spendingLimitColumns).
+		From("spending_limit").
+		OrderBy("user_id nulls last", "currency")
+	if userId != nil {
+		query = query.Where("user_id = ? or user_id is null", *userId)
+	} else {
+		query = query.Where("user_id is null")
+	}
+	sql, args, _ := query.ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/GetLimits error get limits: %s", limitPrefixLog, err)
+		return nil, err
+	}
+	limits, err := pgx.CollectRows(rows, pgx.RowToStructByName[dbmodel.SpendingLimit])
+	if err != nil {
+		log.Errorf("%s/GetLimits error scan limits: %s", limitPrefixLog, err)
+		return nil, err
+	}
+	return limits, nil
+}
+
+// DeleteLimit снятие всех лимитов аккаунта (или глобальных) в валюте
+func (r *LimitRepo) DeleteLimit(ctx context.Context, userId *int, currency string) error {
+	query := r.Builder.
+		Delete("spending_limit").
+		Where("currency = ?", currency)
+	if userId != nil {
+		query = query.Where("user_id = ?", *userId)
+	} else {
+		query = query.Where("user_id is null")
+	}
+	sql, args, _ := query.ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/DeleteLimit error delete limit: %s", limitPrefixLog, err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrNotFound
+	}
+	return nil
+}
+
+// Проверка лимитов расходов перед списанием. Вызывается, когда строка баланса уже заблокирована, но деньги еще
+// не списаны: параллельные списания в той же валюте проверяются по очереди и видят друг друга, а превышение лимита
+// возвращается раньше нехватки денег. Проверяются и лимиты аккаунта, и глобальные - срабатывает первый превышенный
+func checkSpendingLimitsTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, userId int, currency string, amount money.Amount) error {
+	sql, args, _ := builder.
+		Select(spendingLimitColumns).
+		From("spending_limit").
+		Where("currency = ? and (user_id = ? or user_id is null)", currency, userId).
+		OrderBy("user_id nulls last").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/checkSpendingLimitsTx error get limits: %s", limitPrefixLog, err)
+		return err
+	}
+	limits, err := pgx.CollectRows(rows, pgx.RowToStructByName[dbmodel.SpendingLimit])
+	if err != nil {
+		log.Errorf("%s/checkSpendingLimitsTx error scan limits: %s", limitPrefixLog, err)
+		return err
+	}
+	if len(limits) == 0 {
+		return nil
+	}
+
+	usage, err := getSpendingUsageTx(ctx, tx, builder, userId, currency)
+	if err != nil {
+		return err
+	}
+
+	for _, limit := range limits {
+		scope := dbmodel.LimitScopeGlobal
+		if limit.UserId != nil {
+			scope = dbmodel.LimitScopeAccount
+		}
+		exceeded := func(name string, max, current, add int64) error {
+			if current+add <= max {
+				return nil
+			}
+			return &pgerrs.LimitExceededError{Limit: name, Scope: scope, Currency: currency, Max: max, Current: current}
+		}
+
+		if limit.PerOperation != nil {
+			if err = exceeded(dbmodel.LimitPerOperation, int64(*limit.PerOperation), int64(amount), 0); err != nil {
+				return err
+			}
+		}
+		if limit.DailyAmount != nil {
+			if err = exceeded(dbmodel.LimitDailyAmount, int64(*limit.DailyAmount), int64(usage.DailyAmount), int64(amount)); err != nil {
+				return err
+			}
+		}
+		if limit.MonthlyAmount != nil {
+			if err = exceeded(dbmodel.LimitMonthlyAmount, int64(*limit.MonthlyAmount), int64(usage.MonthlyAmount), int64(amount)); err != nil {
+				return err
+			}
+		}
+		if limit.DailyCount != nil {
+			if err = exceeded(dbmodel.LimitDailyCount, int64(*limit.DailyCount), int64(usage.DailyCount), 1); err != nil {
+				return err
+			}
+		}
+		if limit.MonthlyCount != nil {
+			if err = exceeded(dbmodel.LimitMonthlyCount, int64(*limit.MonthlyCount), int64(usage.MonthlyCount), 1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Сколько аккаунт потратил в валюте с начала текущего дня и месяца. Окна календарные, по времени бд.
+// Резервирование входит в расходы, пока оно активно или признано, признанное - на признанную сумму
+// (остаток вернулся пользователю). Отмененные и истекшие не учитываются вовсе
+func getSpendingUsageTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, userId int, currency string) (dbmodel.SpendingUsage, error) {
+	reservations := builder.
+		Select("created_at", "coalesce(recognized_amount, amount)").
+		From("reservation").
+		Where("user_id = ? and currency = ?", userId, currency).
+		Where(squirrel.Eq{"status": spendingReservations}).
+		Where("created_at >= date_trunc('month', now())")
+
+	spent := builder.
+		Select("created_at", "amount").
+		From("operation").
+		Where("user_id = ? and currency = ?", userId, currency).
+		Where(squirrel.Eq{"type": spendingOperations}).
+		Where("created_at >= date_trunc('month', now())").
+		// вложенный запрос с "?", иначе его $1... совпали бы с номерами аргументов внешнего
+		SuffixExpr(squirrel.ConcatExpr("union all ", reservations.PlaceholderFormat(squirrel.Question)))
+
+	sql, args, _ := builder.
+		Select(
+			"coalesce(sum(amount) filter (where created_at >= date_trunc('day', now())), 0)::bigint",
+			"count(*) filter (where created_at >= date_trunc('day', now()))",
+			"coalesce(sum(amount), 0)::bigint",
+			"count(*)",
+		).
+		FromSelect(spent, "spent").
+		ToSql()
+
+	var usage dbmodel.SpendingUsage
+	err := tx.QueryRow(ctx, sql, args...).Scan(&usage.DailyAmount, &usage.DailyCount, &usage.MonthlyAmount, &usage.MonthlyCount)
+	if err != nil {
+		log.Errorf("%s/getSpendingUsageTx error get spending usage: %s", limitPrefixLog, err)
+		return dbmodel.SpendingUsage{}, err
+	}
+	return usage, nil
+}
@@ -6,8 +6,12 @@ import (
 	"avito_intership/pkg/postgres"
 	"context"
 	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 const operationPrefixLog = "/pgdb/operation"
@@ -20,27 +24,79 @@ func NewOperationRepo(pg *postgres.Postgres) *OperationRepo {
 	return &OperationRepo{pg}
 }
 
-func (r *OperationRepo) GetHistory(ctx context.Context, userId int, sort string, offset, limit int) ([]dbmodel.Operation, error) {
-	sql, args, _ := r.Builder.
-		Select("*").
+// поля, по которым можно сортировать историю, и их типы для сравнения со значениями из курсора
+var historyOrderFields = map[string]string{
+	"id":         "int",
+	"created_at": "timestamp",
+	"amount":     "bigint",
+	"type":       "varchar",
+}
+
+// GetHistory страница истории операций по фильтру. Если задан After, то страница начинается сразу после строки с этими значениями
+// полей сортировки (keyset), и новые операции не сдвигают страницы. Offset оставлен для старых клиентов
+func (r *OperationRepo) GetHistory(ctx context.Context, query dbmodel.HistoryQuery) ([]dbmodel.Operation, error) {
+	var result []dbmodel.Operation
+	err := r.queryHistory(ctx, query, func(operation dbmodel.Operation) error {
+		result = append(result, operation)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// StreamHistory вся история операций по фильтру без лимита. Строки читаются курсором и по одной передаются в fn,
+// так что история целиком в памяти не собирается. Ошибка из fn останавливает чтение и возвращается как есть
+func (r *OperationRepo) StreamHistory(ctx context.Context, query dbmodel.HistoryQuery, fn func(dbmodel.Operation) error) error {
+	query.Limit = 0
+	query.Offset = 0
+	query.After = nil
+	return r.queryHistory(ctx, query, fn)
+}
+
+func (r *OperationRepo) queryHistory(ctx context.Context, query dbmodel.HistoryQuery, fn func(dbmodel.Operation) error) error {
+	builder := r.Builder.
+		Select("id", "user_id", "product_id", "order_id", "amount", "currency", "rate", "type", "overdraft", "created_at").
 		From("operation").
-		Where("user_id = ?", userId).
-		OrderBy(sort).
-		Offset(uint64(offset)).
-		Limit(uint64(limit)).
-		ToSql()
+		Where(historyFilter(query))
+	if query.Limit > 0 {
+		builder = builder.Limit(uint64(query.Limit))
+	}
+
+	for _, order := range query.Order {
+		if _, ok := historyOrderFields[order.Field]; !ok {
+			return fmt.Errorf("unknown history order field %q", order.Field)
+		}
+		if order.Desc {
+			builder = builder.OrderBy(order.Field + " desc")
+		} else {
+			builder = builder.OrderBy(order.Field)
+		}
+	}
+	if len(query.After) > 0 {
+		if len(query.After) != len(query.Order) {
+			return pgerrs.ErrInvalidCursor
+		}
+		builder = builder.Where(keysetCondition(query.Order, query.After))
+	} else if query.Offset > 0 {
+		builder = builder.Offset(uint64(query.Offset))
+	}
+	sql, args, _ := builder.ToSql()
 
 	rows, err := r.Pool.Query(ctx, sql, args...)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, pgerrs.ErrNotFound
+		var pgErr *pgconn.PgError
+		if ok := errors.As(err, &pgErr); ok {
+			if pgErr.Code == "22P02" || pgErr.Code == "22007" || pgErr.Code == "22008" { // значение из курсора не приводится к типу поля
+				return pgerrs.ErrInvalidCursor
+			}
 		}
-		log.Errorf("%s/GetHistory error get operations: %s", operationPrefixLog, err)
-		return nil, err
+		log.Errorf("%s/queryHistory error get operations: %s", operationPrefixLog, err)
+		return err
 	}
 	defer rows.Close()
 
-	var result []dbmodel.Operation
 	for rows.Next() {
 		var operation dbmodel.Operation
 
@@ -50,24 +106,134 @@ func (r *OperationRepo) GetHistory(ctx context.Context, userId int, sort string,
 			&operation.ProductId,
 			&operation.OrderId,
 			&operation.Amount,
+			&operation.Currency,
+			&operation.Rate,
 			&operation.Type,
+			&operation.Overdraft,
 			&operation.CreatedAt,
 		)
 		if err != nil {
-			log.Errorf("%s/GetHistory error get operation: %s", operationPrefixLog, err)
-			continue
+			log.Errorf("%s/queryHistory error get operation: %s", operationPrefixLog, err)
+			return err
+		}
+		if err = fn(operation); err != nil {
+			return err
 		}
-		result = append(result, operation)
 	}
-	return result, nil
+	if err = rows.Err(); err != nil {
+		log.Errorf("%s/queryHistory error read operations: %s", operationPrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+// Условия фильтра истории. Значения передаются только аргументами запроса
+func historyFilter(query dbmodel.HistoryQuery) squirrel.And {
+	where := squirrel.Eq{"user_id": query.UserId}
+	if len(query.Types) > 0 {
+		where["type"] = query.Types
+	}
+	if query.ProductId != 0 {
+		where["product_id"] = query.ProductId
+	}
+	if query.OrderId != 0 {
+		where["order_id"] = query.OrderId
+	}
+
+	result := squirrel.And{where}
+	if !query.CreatedFrom.IsZero() {
+		result = append(result, squirrel.GtOrEq{"created_at": query.CreatedFrom})
+	}
+	if !query.CreatedTo.IsZero() {
+		result = append(result, squirrel.Lt{"created_at": query.CreatedTo})
+	}
+	if query.AmountFrom != 0 {
+		result = append(result, squirrel.GtOrEq{"amount": query.AmountFrom})
+	}
+	if query.AmountTo != 0 {
+		result = append(result, squirrel.LtOrEq{"amount": query.AmountTo})
+	}
+	return result
 }
 
-func (r *OperationRepo) GroupProductRevenue(ctx context.Context, year, month int) (map[int]float64, error) {
+// Условие "строка идет после after" для сортировки по нескольким полям с разными направлениями:
+// (f1 > v1) or (f1 = v1 and f2 > v2) or ... где > заменяется на < для полей по убыванию
+func keysetCondition(order []dbmodel.HistoryOrder, after []string) squirrel.Sqlizer {
+	condition := squirrel.Or{}
+	for i, current := range order {
+		and := squirrel.And{}
+		for j := 0; j < i; j++ {
+			and = append(and, squirrel.Expr(order[j].Field+" = ?::"+historyOrderFields[order[j].Field], after[j]))
+		}
+		op := ">"
+		if current.Desc {
+			op = "<"
+		}
+		and = append(and, squirrel.Expr(current.Field+" "+op+" ?::"+historyOrderFields[current.Field], after[i]))
+		condition = append(condition, and)
+	}
+	return condition
+}
+
+// GetStatement выписка: входящий остаток на from и все операции в валюте за [from, to) по времени.
+// Читается в одной транзакции repeatable read, чтобы остаток и операции были из одного снимка бд
+func (r *OperationRepo) GetStatement(ctx context.Context, userId int, currency string, from, to time.Time) (dbmodel.Statement, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		log.Errorf("%s/GetStatement error init tx: %s", operationPrefixLog, err)
+		return dbmodel.Statement{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err = tx.Exec(ctx, "set transaction isolation level repeatable read, read only"); err != nil {
+		log.Errorf("%s/GetStatement error set isolation level: %s", operationPrefixLog, err)
+		return dbmodel.Statement{}, err
+	}
+
+	opening, err := balanceAsOf(ctx, tx, r.Builder, userId, currency, from, false)
+	if err != nil {
+		return dbmodel.Statement{}, err
+	}
+
+	sql, args, _ := r.Builder.
+		Select("id", "user_id", "product_id", "order_id", "amount", "currency", "rate", "type", "overdraft", "created_at").
+		From("operation").
+		Where("user_id = ? and currency = ? and created_at >= ? and created_at < ?", userId, currency, from, to).
+		OrderBy("created_at", "id").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/GetStatement error get operations: %s", operationPrefixLog, err)
+		return dbmodel.Statement{}, err
+	}
+	operations, err := pgx.CollectRows(rows, pgx.RowToStructByName[dbmodel.Operation])
+	if err != nil {
+		log.Errorf("%s/GetStatement error scan operations: %s", operationPrefixLog, err)
+		return dbmodel.Statement{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Errorf("%s/GetStatement error commit: %s", operationPrefixLog, err)
+		return dbmodel.Statement{}, err
+	}
+	return dbmodel.Statement{OpeningBalance: opening, Operations: operations}, nil
+}
+
+// GroupProductRevenue выручка по услугам за месяц. Суммы в разных валютах не складываются, поэтому группировка еще и по валюте.
+// Возвраты попадают в тот месяц, в котором сделан возврат, а не в месяц признания выручки
+func (r *OperationRepo) GroupProductRevenue(ctx context.Context, year, month int) ([]dbmodel.ProductRevenue, error) {
+	from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 	sql, args, _ := r.Builder.
-		Select("product_id", "sum(amount)").
+		Select("product_id", "currency").
+		Column(squirrel.Expr("coalesce(sum(amount) filter (where type = ?), 0)::bigint", dbmodel.OperationRevenue)).
+		Column(squirrel.Expr("count(*) filter (where type = ?)", dbmodel.OperationRevenue)).
+		Column(squirrel.Expr("coalesce(sum(amount) filter (where type = ?), 0)::bigint", dbmodel.OperationRefund)).
 		From("operation").
-		Where("type = ? and extract(year from operation.created_at) = ? and extract(month from operation.created_at) = ?", dbmodel.OperationRevenue, year, month).
-		GroupBy("product_id").
+		Where(squirrel.Eq{"type": []string{dbmodel.OperationRevenue, dbmodel.OperationRefund}}).
+		Where("created_at >= ? and created_at < ?", from, from.AddDate(0, 1, 0)).
+		GroupBy("product_id", "currency").
+		OrderBy("product_id", "currency").
 		ToSql()
 
 	rows, err := r.Pool.Query(ctx, sql, args...)
@@ -77,17 +243,83 @@ func (r *OperationRepo) GroupProductRevenue(ctx context.Context, year, month int
 	}
 	defer rows.Close()
 
-	result := make(map[int]float64)
+	var result []dbmodel.ProductRevenue
 
 	for rows.Next() {
-		var productId int
-		var amount float64
+		var revenue dbmodel.ProductRevenue
 
-		if err = rows.Scan(&productId, &amount); err != nil {
+		if err = rows.Scan(&revenue.ProductId, &revenue.Currency, &revenue.Revenue, &revenue.Operations, &revenue.Refunds); err != nil {
 			log.Errorf("%s/GroupProductRevenue error get product: %s", operationPrefixLog, err)
-			continue
+			return nil, err
 		}
-		result[productId] = amount
+		result = append(result, revenue)
 	}
-	return result, nil
+	return result, rows.Err()
+}
+
+// группировка created_at по периодам отчета. Период подставляется в запрос текстом только из этого списка
+var revenuePeriods = map[string]string{
+	dbmodel.ReportPeriodDay:     "date_trunc('day', created_at)",
+	dbmodel.ReportPeriodWeek:    "date_trunc('week', created_at)",
+	dbmodel.ReportPeriodMonth:   "date_trunc('month', created_at)",
+	dbmodel.ReportPeriodQuarter: "date_trunc('quarter', created_at)",
+}
+
+// GroupRevenue выручка, число признаний и возвраты за [From, To) по периодам и валютам, а если нужно,
+// то и по услугам и пользователям. Порядок: период, услуга, пользователь, валюта.
+// Как и в месячном отчете, возврат попадает в период, в котором он сделан
+func (r *OperationRepo) GroupRevenue(ctx context.Context, query dbmodel.RevenueQuery) ([]dbmodel.RevenueGroup, error) {
+	period, ok := revenuePeriods[query.Period]
+	if !ok {
+		return nil, fmt.Errorf("unknown report period %q", query.Period)
+	}
+
+	groupBy := []string{period}
+	if query.ByProduct {
+		groupBy = append(groupBy, "product_id")
+	}
+	if query.ByUser {
+		groupBy = append(groupBy, "user_id")
+	}
+	groupBy = append(groupBy, "currency")
+
+	sql, args, _ := r.Builder.
+		Select(groupBy...).
+		Column(squirrel.Expr("coalesce(sum(amount) filter (where type = ?), 0)::bigint", dbmodel.OperationRevenue)).
+		Column(squirrel.Expr("count(*) filter (where type = ?)", dbmodel.OperationRevenue)).
+		Column(squirrel.Expr("coalesce(sum(amount) filter (where type = ?), 0)::bigint", dbmodel.OperationRefund)).
+		From("operation").
+		Where(squirrel.Eq{"type": []string{dbmodel.OperationRevenue, dbmodel.OperationRefund}}).
+		Where("created_at >= ? and created_at < ?", query.From, query.To).
+		GroupBy(groupBy...).
+		OrderBy(groupBy...).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/GroupRevenue error get revenue: %s", operationPrefixLog, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []dbmodel.RevenueGroup
+	for rows.Next() {
+		var group dbmodel.RevenueGroup
+
+		dest := []any{&group.Period}
+		if query.ByProduct {
+			dest = append(dest, &group.ProductId)
+		}
+		if query.ByUser {
+			dest = append(dest, &group.UserId)
+		}
+		dest = append(dest, &group.Currency, &group.Revenue, &group.Operations, &group.Refunds)
+
+		if err = rows.Scan(dest...); err != nil {
+			log.Errorf("%s/GroupRevenue error scan revenue: %s", operationPrefixLog, err)
+			return nil, err
+		}
+		result = append(result, group)
+	}
+	return result, rows.Err()
 }
@@ -0,0 +1,181 @@
+package pgdb
+
+import (
+	"avito_intership/internal/model/dbmodel"
+	"avito_intership/pkg/postgres"
+	"context"
+	"encoding/json"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	outboxPrefixLog = "/pgdb/outbox"
+
+	// ключ advisory lock, чтобы outbox разбирала только одна реплика и порядок сообщений не нарушался
+	outboxRelayLockKey = 7_340_001
+
+	maxOutboxRetryDelay = time.Minute * 5
+)
+
+type OutboxRepo struct {
+	*postgres.Postgres
+}
+
+func NewOutboxRepo(pg *postgres.Postgres) *OutboxRepo {
+	return &OutboxRepo{pg}
+}
+
+// Запись события в outbox в транзакции операции. Отправится в брокер только если транзакция закоммитится
+func pushOutboxTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, key string, event dbmodel.Event) error {
+	return pushOutboxPayloadTx(ctx, tx, builder, key, event.UserId, event)
+}
+
+// То же, что и pushOutboxTx, но для событий другого формата. userId нужен для порядка отправки
+func pushOutboxPayloadTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, key string, userId int, event any) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("%s/pushOutboxPayloadTx error marshal event: %s", outboxPrefixLog, err)
+		return err
+	}
+
+	sql, args, _ := builder.
+		Insert("outbox").
+		Columns("user_id", "key", "payload").
+		Values(userId, key, payload).
+		ToSql()
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/pushOutboxPayloadTx error insert event: %s", outboxPrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+// Relay отправка неотправленных событий через publish в порядке их создания.
+// События пользователя, у которого более раннее событие ждет повтора, не отправляются, чтобы не нарушить порядок.
+// Такие события отсекаются еще в запросе, чтобы не занимать место в пачке. Неудачная попытка откладывает
+// событие с экспоненциальной задержкой и останавливает пачку: скорее всего брокер недоступен, и остальные
+// события ждали бы таймаута каждое, держа транзакцию и блокировку. Возвращает количество отправленных событий
+func (r *OutboxRepo) Relay(ctx context.Context, limit int, publish func(context.Context, dbmodel.OutboxMessage) error) (int, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		log.Errorf("%s/Relay error init tx: %s", outboxPrefixLog, err)
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, _ := r.Builder.
+		Select().
+		Column(squirrel.Expr("pg_try_advisory_xact_lock(?)", outboxRelayLockKey)).
+		ToSql()
+
+	var locked bool
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&locked); err != nil {
+		log.Errorf("%s/Relay error acquire lock: %s", outboxPrefixLog, err)
+		return 0, err
+	}
+	if !locked { // outbox сейчас разбирает другая реплика
+		return 0, nil
+	}
+
+	sql, args, _ = r.Builder.
+		Select("id", "user_id", "key", "payload", "attempts", "next_attempt_at").
+		From("outbox o").
+		Where("sent_at is null and next_attempt_at <= now()").
+		Where("not exists (select 1 from outbox p where p.user_id = o.user_id and p.sent_at is null and p.id < o.id and p.next_attempt_at > now())").
+		OrderBy("id").
+		Limit(uint64(limit)).
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/Relay error get pending events: %s", outboxPrefixLog, err)
+		return 0, err
+	}
+
+	var messages []dbmodel.OutboxMessage
+	for rows.Next() {
+		var m dbmodel.OutboxMessage
+		if err = rows.Scan(&m.Id, &m.UserId, &m.Key, &m.Payload, &m.Attempts, &m.NextAttemptAt); err != nil {
+			rows.Close()
+			log.Errorf("%s/Relay error scan event: %s", outboxPrefixLog, err)
+			return 0, err
+		}
+		messages = append(messages, m)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		log.Errorf("%s/Relay error read pending events: %s", outboxPrefixLog, err)
+		return 0, err
+	}
+	rows.Close()
+
+	var sent []int64
+	for _, m := range messages {
+		if err = publish(ctx, m); err != nil {
+			if err = r.markFailedTx(ctx, tx, m, err); err != nil {
+				return 0, err
+			}
+			break
+		}
+		sent = append(sent, m.Id)
+	}
+
+	if len(sent) > 0 {
+		sql, args, _ = r.Builder.
+			Update("outbox").
+			Set("sent_at", squirrel.Expr("now()")).
+			Where(squirrel.Eq{"id": sent}).
+			ToSql()
+
+		if _, err = tx.Exec(ctx, sql, args...); err != nil {
+			log.Errorf("%s/Relay error mark events sent: %s", outboxPrefixLog, err)
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Errorf("%s/Relay error commit: %s", outboxPrefixLog, err)
+		return 0, err
+	}
+	return len(sent), nil
+}
+
+func (r *OutboxRepo) markFailedTx(ctx context.Context, tx pgx.Tx, m dbmodel.OutboxMessage, publishErr error) error {
+	delay := time.Second << min(m.Attempts, 16)
+	if delay > maxOutboxRetryDelay {
+		delay = maxOutboxRetryDelay
+	}
+
+	sql, args, _ := r.Builder.
+		Update("outbox").
+		Set("attempts", squirrel.Expr("attempts + 1")).
+		Set("last_error", publishErr.Error()).
+		Set("next_attempt_at", squirrel.Expr("now() + make_interval(secs => ?)", delay.Seconds())).
+		Where("id = ?", m.Id).
+		ToSql()
+
+	if _, err := tx.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/markFailedTx error update event: %s", outboxPrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteSent удаление событий, отправленных раньше, чем retention назад
+func (r *OutboxRepo) DeleteSent(ctx context.Context, retention time.Duration) (int64, error) {
+	sql, args, _ := r.Builder.
+		Delete("outbox").
+		Where("sent_at is not null and sent_at < now() - make_interval(secs => ?)", retention.Seconds()).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/DeleteSent error delete events: %s", outboxPrefixLog, err)
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
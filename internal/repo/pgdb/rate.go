@@ -0,0 +1,76 @@
+package pgdb
+
+import (
+	"avito_intership/internal/model/dbmodel"
+	"avito_intership/internal/repo/pgerrs"
+	"avito_intership/pkg/money"
+	"avito_intership/pkg/postgres"
+	"context"
+	"errors"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+const ratePrefixLog = "/pgdb/rate"
+
+type RateRepo struct {
+	*postgres.Postgres
+}
+
+func NewRateRepo(pg *postgres.Postgres) *RateRepo {
+	return &RateRepo{pg}
+}
+
+func (r *RateRepo) SetRate(ctx context.Context, base, quote string, rate money.Rate) error {
+	sql, args, _ := r.Builder.
+		Insert("exchange_rate").
+		Columns("base", "quote", "rate").
+		Values(base, quote, rate).
+		Suffix("on conflict (base, quote) do update set rate = excluded.rate, updated_at = now()").
+		ToSql()
+
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/SetRate error exec stmt: %s", ratePrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+func (r *RateRepo) GetRate(ctx context.Context, base, quote string) (dbmodel.ExchangeRate, error) {
+	sql, args, _ := r.Builder.
+		Select("base", "quote", "rate", "updated_at").
+		From("exchange_rate").
+		Where("base = ? and quote = ?", base, quote).
+		ToSql()
+
+	var rate dbmodel.ExchangeRate
+	if err := r.Pool.QueryRow(ctx, sql, args...).Scan(&rate.Base, &rate.Quote, &rate.Rate, &rate.UpdatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.ExchangeRate{}, pgerrs.ErrRateNotFound
+		}
+		log.Errorf("%s/GetRate error get rate: %s", ratePrefixLog, err)
+		return dbmodel.ExchangeRate{}, err
+	}
+	return rate, nil
+}
+
+// Курс внутри транзакции перевода. Строка блокируется на чтение, чтобы курс не поменялся посреди перевода
+func getRateTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, base, quote string) (money.Rate, error) {
+	sql, args, _ := builder.
+		Select("rate").
+		From("exchange_rate").
+		Where("base = ? and quote = ?", base, quote).
+		Suffix("for share").
+		ToSql()
+
+	var rate money.Rate
+	if err := tx.QueryRow(ctx, sql, args...).Scan(&rate); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, pgerrs.ErrRateNotFound
+		}
+		log.Errorf("%s/getRateTx error get rate: %s", ratePrefixLog, err)
+		return 0, err
+	}
+	return rate, nil
+}
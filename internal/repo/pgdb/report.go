@@ -0,0 +1,220 @@
+package pgdb
+
+import (
+	"avito_intership/internal/model/dbmodel"
+	"avito_intership/internal/repo/pgerrs"
+	"avito_intership/pkg/postgres"
+	"context"
+	"errors"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	reportPrefixLog = "/pgdb/report"
+
+	reportJobColumns = "id, kind, format, params, params_hash, period_end, status, attempts, error, file_name, created_at, started_at, finished_at"
+)
+
+type ReportRepo struct {
+	*postgres.Postgres
+}
+
+func NewReportRepo(pg *postgres.Postgres) *ReportRepo {
+	return &ReportRepo{pg}
+}
+
+// CreateJob создание задачи на отчет. Если такой же отчет уже ждет, генерируется или сгенерирован после конца
+// своего периода (то есть уже не устареет), то возвращается эта задача и false
+func (r *ReportRepo) CreateJob(ctx context.Context, job dbmodel.ReportJob) (dbmodel.ReportJob, bool, error) {
+	// две попытки: между поиском и вставкой такую же задачу может создать параллельный запрос,
+	// тогда вставка упрется в уникальный индекс, а повторный поиск ее найдет
+	for i := 0; i < 2; i++ {
+		existing, err := r.findSameJob(ctx, job.ParamsHash)
+		if err == nil {
+			return existing, false, nil
+		}
+		if !errors.Is(err, pgerrs.ErrNotFound) {
+			return dbmodel.ReportJob{}, false, err
+		}
+
+		sql, args, _ := r.Builder.
+			Insert("report_job").
+			Columns("kind", "format", "params", "params_hash", "period_end").
+			Values(job.Kind, job.Format, job.Params, job.ParamsHash, job.PeriodEnd).
+			Suffix("on conflict (params_hash) where status in ('pending', 'running') do nothing returning " + reportJobColumns).
+			ToSql()
+
+		rows, err := r.Pool.Query(ctx, sql, args...)
+		if err != nil {
+			log.Errorf("%s/CreateJob error insert job: %s", reportPrefixLog, err)
+			return dbmodel.ReportJob{}, false, err
+		}
+		created, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[dbmodel.ReportJob])
+		if err == nil {
+			return created, true, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Errorf("%s/CreateJob error insert job: %s", reportPrefixLog, err)
+			return dbmodel.ReportJob{}, false, err
+		}
+	}
+	log.Errorf("%s/CreateJob error insert job: concurrent job is not found", reportPrefixLog)
+	return dbmodel.ReportJob{}, false, pgerrs.ErrNotFound
+}
+
+// Последняя задача с теми же параметрами, которую можно отдать вместо новой
+func (r *ReportRepo) findSameJob(ctx context.Context, paramsHash string) (dbmodel.ReportJob, error) {
+	sql, args, _ := r.Builder.
+		Select(reportJobColumns).
+		From("report_job").
+		Where("params_hash = ?", paramsHash).
+		Where(squirrel.Or{
+			squirrel.Eq{"status": []string{dbmodel.ReportJobPending, dbmodel.ReportJobRunning}},
+			squirrel.And{squirrel.Eq{"status": dbmodel.ReportJobDone}, squirrel.Expr("period_end <= started_at")},
+		}).
+		OrderBy("id desc").
+		Limit(1).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/findSameJob error get job: %s", reportPrefixLog, err)
+		return dbmodel.ReportJob{}, err
+	}
+	job, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[dbmodel.ReportJob])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.ReportJob{}, pgerrs.ErrNotFound
+		}
+		log.Errorf("%s/findSameJob error get job: %s", reportPrefixLog, err)
+		return dbmodel.ReportJob{}, err
+	}
+	return job, nil
+}
+
+func (r *ReportRepo) GetJob(ctx context.Context, id int) (dbmodel.ReportJob, error) {
+	sql, args, _ := r.Builder.
+		Select(reportJobColumns).
+		From("report_job").
+		Where("id = ?", id).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/GetJob error get job: %s", reportPrefixLog, err)
+		return dbmodel.ReportJob{}, err
+	}
+	job, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[dbmodel.ReportJob])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.ReportJob{}, pgerrs.ErrNotFound
+		}
+		log.Errorf("%s/GetJob error get job: %s", reportPrefixLog, err)
+		return dbmodel.ReportJob{}, err
+	}
+	return job, nil
+}
+
+// ClaimJob берет в работу самую старую ждущую задачу. Задача, которая генерируется дольше timeout, считается
+// брошенной (реплика упала) и берется заново, а после maxAttempts таких попыток помечается failed.
+// skip locked позволяет нескольким репликам разбирать очередь параллельно. Если задач нет - ErrNotFound
+func (r *ReportRepo) ClaimJob(ctx context.Context, timeout time.Duration, maxAttempts int) (dbmodel.ReportJob, error) {
+	stale := squirrel.Expr("started_at < now() - make_interval(secs => ?)", timeout.Seconds())
+
+	sql, args, _ := r.Builder.
+		Update("report_job").
+		Set("status", dbmodel.ReportJobFailed).
+		Set("error", "report generation timed out").
+		Set("finished_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"status": dbmodel.ReportJobRunning}).
+		Where(stale).
+		Where("attempts >= ?", maxAttempts).
+		ToSql()
+
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/ClaimJob error fail stale jobs: %s", reportPrefixLog, err)
+		return dbmodel.ReportJob{}, err
+	}
+
+	next := r.Builder.
+		Select("id").
+		From("report_job").
+		Where(squirrel.Or{
+			squirrel.Eq{"status": dbmodel.ReportJobPending},
+			squirrel.And{squirrel.Eq{"status": dbmodel.ReportJobRunning}, stale},
+		}).
+		OrderBy("id").
+		Limit(1).
+		Suffix("for update skip locked")
+
+	sql, args, _ = r.Builder.
+		Update("report_job").
+		Set("status", dbmodel.ReportJobRunning).
+		Set("started_at", squirrel.Expr("now()")).
+		Set("attempts", squirrel.Expr("attempts + 1")).
+		Where(next.Prefix("id = (").Suffix(")")).
+		Suffix("returning " + reportJobColumns).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/ClaimJob error claim job: %s", reportPrefixLog, err)
+		return dbmodel.ReportJob{}, err
+	}
+	job, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[dbmodel.ReportJob])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.ReportJob{}, pgerrs.ErrNotFound
+		}
+		log.Errorf("%s/ClaimJob error claim job: %s", reportPrefixLog, err)
+		return dbmodel.ReportJob{}, err
+	}
+	return job, nil
+}
+
+// FinishJob попытка attempt выполнена, файл отчета сохранен под именем fileName.
+// Если задачу уже взяла заново другая попытка (или она завершена), то ничего не меняется и возвращается ErrReportJobLost
+func (r *ReportRepo) FinishJob(ctx context.Context, id, attempt int, fileName string) error {
+	sql, args, _ := r.Builder.
+		Update("report_job").
+		Set("status", dbmodel.ReportJobDone).
+		Set("file_name", fileName).
+		Set("finished_at", squirrel.Expr("now()")).
+		Where("id = ? and status = ? and attempts = ?", id, dbmodel.ReportJobRunning, attempt).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/FinishJob error finish job: %s", reportPrefixLog, err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrReportJobLost
+	}
+	return nil
+}
+
+// FailJob попытка attempt не удалась, причина сохраняется для клиента. Как и в FinishJob,
+// устаревшая попытка не трогает задачу и получает ErrReportJobLost
+func (r *ReportRepo) FailJob(ctx context.Context, id, attempt int, reason string) error {
+	sql, args, _ := r.Builder.
+		Update("report_job").
+		Set("status", dbmodel.ReportJobFailed).
+		Set("error", reason).
+		Set("finished_at", squirrel.Expr("now()")).
+		Where("id = ? and status = ? and attempts = ?", id, dbmodel.ReportJobRunning, attempt).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/FailJob error fail job: %s", reportPrefixLog, err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgerrs.ErrReportJobLost
+	}
+	return nil
+}
@@ -3,13 +3,16 @@ package pgdb
 import (
 	"avito_intership/internal/model/dbmodel"
 	"avito_intership/internal/repo/pgerrs"
+	"avito_intership/pkg/money"
 	"avito_intership/pkg/postgres"
 	"avito_intership/pkg/redis"
 	"context"
+	"encoding/json"
 	"errors"
 	"github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
 	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 const reservationPrefixLog = "/pgdb/reservation"
@@ -26,50 +29,52 @@ func NewReservationRepo(pg *postgres.Postgres, redis redis.Redis) *ReservationRe
 	}
 }
 
-func (r *ReservationRepo) CreateReservation(ctx context.Context, reservation dbmodel.Reservation) (int, error) {
+// CreateReservation резервирование денег на счете. Срок проверяется только после ключа идемпотентности:
+// повтор запроса отдает уже созданное резервирование, даже если его срок с тех пор прошел
+func (r *ReservationRepo) CreateReservation(ctx context.Context, reservation dbmodel.Reservation, key *dbmodel.IdempotencyKey) (int, error) {
 	tx, err := r.Pool.Begin(ctx)
 	if err != nil {
 		log.Errorf("%s/CreateReservation error init tx: %s", reservationPrefixLog, err)
 		return 0, err
 	}
-	defer func() { _ = tx.Rollback(ctx) }()
+	cache := newCacheTx(r.redis)
+	defer cache.rollback(ctx, tx)
 
-	balance, err := getCacheBalance(ctx, r.redis, reservation.UserId)
+	stored, err := claimIdempotencyKeyTx(ctx, tx, r.Builder, key)
 	if err != nil {
-		if !errors.Is(err, pgerrs.ErrNotFound) {
-			return 0, err
-		}
-		balance, err = getBalanceTx(ctx, tx, r.Builder, reservation.UserId)
-		if err != nil {
-			return 0, err
+		if errors.Is(err, pgerrs.ErrIdempotentReplay) {
+			var reservationId int
+			_ = json.Unmarshal(stored, &reservationId)
+			return reservationId, err
 		}
+		return 0, err
 	}
-
-	if balance < reservation.Amount {
-		return 0, pgerrs.ErrNotEnoughBalance
+	if reservation.ExpiresAt != nil && !reservation.ExpiresAt.After(time.Now()) {
+		return 0, pgerrs.ErrReservationExpiresAt
 	}
 
-	sql, args, _ := r.Builder.
-		Update("account").
-		Set("balance", squirrel.Expr("balance - ?", reservation.Amount)).
-		Where("user_id = ?", reservation.UserId).
-		Suffix("returning balance").
-		ToSql()
-
-	if err = tx.QueryRow(ctx, sql, args...).Scan(&balance); err != nil {
-		log.Errorf("%s/CreateReservation error update account balance: %s", reservationPrefixLog, err)
+	if err = checkAccountStatusTx(ctx, tx, r.Builder, reservation.UserId, true); err != nil {
 		return 0, err
 	}
 
-	if err = setCacheBalance(ctx, r.redis, reservation.UserId, balance); err != nil {
+	if err = lockBalanceTx(ctx, tx, r.Builder, reservation.UserId, reservation.Currency); err != nil {
+		return 0, err
+	}
+	if err = checkSpendingLimitsTx(ctx, tx, r.Builder, reservation.UserId, reservation.Currency, reservation.Amount); err != nil {
+		return 0, err
+	}
+	balance, err := holdBalanceTx(ctx, tx, r.Builder, reservation.UserId, reservation.Currency, reservation.Amount)
+	if err != nil {
 		return 0, err
 	}
 
+	cache.stage(balance)
+
 	var reservationId int
-	sql, args, _ = r.Builder.
+	sql, args, _ := r.Builder.
 		Insert("reservation").
-		Columns("user_id", "product_id", "order_id", "amount").
-		Values(reservation.UserId, reservation.ProductId, reservation.OrderId, reservation.Amount).
+		Columns("user_id", "product_id", "order_id", "amount", "currency", "expires_at").
+		Values(reservation.UserId, reservation.ProductId, reservation.OrderId, reservation.Amount, reservation.Currency, reservation.ExpiresAt).
 		Suffix("returning id").
 		ToSql()
 
@@ -78,116 +83,684 @@ func (r *ReservationRepo) CreateReservation(ctx context.Context, reservation dbm
 		return 0, err
 	}
 
+	entryId, err := postEntryTx(ctx, tx, r.Builder, dbmodel.OperationReservation,
+		userPosting(reservation.UserId, reservation.Currency, -reservation.Amount),
+		systemPosting(dbmodel.SystemAccountHolds, reservation.Currency, reservation.Amount),
+	)
+	if err != nil {
+		return 0, err
+	}
+
 	sql, args, _ = r.Builder.
 		Insert("operation").
-		Columns("user_id", "product_id", "order_id", "amount", "type").
-		Values(reservation.UserId, reservation.ProductId, reservation.OrderId, reservation.Amount, dbmodel.OperationReservation).
+		Columns("user_id", "product_id", "order_id", "amount", "currency", "type", "entry_id", "overdraft").
+		Values(reservation.UserId, reservation.ProductId, reservation.OrderId, reservation.Amount, reservation.Currency, dbmodel.OperationReservation, entryId, balance.Balance < 0).
 		ToSql()
 	if _, err = tx.Exec(ctx, sql, args...); err != nil {
 		log.Errorf("%s/CreateReservation error create operation: %s", reservationPrefixLog, err)
 		return 0, err
 	}
+	if err = pushOutboxTx(ctx, tx, r.Builder, dbmodel.OperationReservation, dbmodel.Event{
+		UserId:   reservation.UserId,
+		Amount:   reservation.Amount,
+		Currency: reservation.Currency,
+	}); err != nil {
+		return 0, err
+	}
+	if err = saveIdempotentResponseTx(ctx, tx, r.Builder, key, reservationId); err != nil {
+		return 0, err
+	}
 
-	if err = tx.Commit(ctx); err != nil {
+	if err = cache.commit(ctx, tx); err != nil {
 		log.Errorf("%s/CreateReservation error commit: %s", reservationPrefixLog, err)
 		return 0, err
 	}
 	return reservationId, nil
 }
 
-func (r *ReservationRepo) DeleteReservation(ctx context.Context, reservationId int) (int, float64, error) {
+func (r *ReservationRepo) CancelReservation(ctx context.Context, reservationId int) (dbmodel.Reservation, error) {
+	return r.releaseReservation(ctx, reservationId, dbmodel.ReservationCancelled)
+}
+
+// ExpireReservations возврат денег по активным резервированиям, у которых вышел срок. Каждое резервирование
+// отменяется в своей транзакции тем же путем, что и ручная отмена. Возвращает количество отмененных
+func (r *ReservationRepo) ExpireReservations(ctx context.Context, limit int) (int, error) {
+	sql, args, _ := r.Builder.
+		Select("id").
+		From("reservation").
+		Where("status = ? and expires_at <= now()", dbmodel.ReservationActive).
+		OrderBy("expires_at").
+		Limit(uint64(limit)).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/ExpireReservations error get expired reservations: %s", reservationPrefixLog, err)
+		return 0, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		log.Errorf("%s/ExpireReservations error scan expired reservations: %s", reservationPrefixLog, err)
+		return 0, err
+	}
+
+	var expired int
+	for _, id := range ids {
+		if _, err = r.releaseReservation(ctx, id, dbmodel.ReservationExpired); err != nil {
+			// пока выбирали, резервирование могли отменить или признать вручную - это не ошибка
+			if errors.Is(err, pgerrs.ErrReservationNotActive) {
+				continue
+			}
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// Возврат зарезервированных денег пользователю. Резервирование переходит в status (отменено вручную или по сроку)
+func (r *ReservationRepo) releaseReservation(ctx context.Context, reservationId int, status string) (dbmodel.Reservation, error) {
 	tx, err := r.Pool.Begin(ctx)
 	if err != nil {
-		log.Errorf("%s/DeleteReservation error init tx: %s", reservationPrefixLog, err)
-		return 0, 0, err
+		log.Errorf("%s/releaseReservation error init tx: %s", reservationPrefixLog, err)
+		return dbmodel.Reservation{}, err
 	}
-	defer func() { _ = tx.Rollback(ctx) }()
+	cache := newCacheTx(r.redis)
+	defer cache.rollback(ctx, tx)
 
-	sql, args, _ := r.Builder.
-		Delete("reservation").
+	reservation, err := releaseReservationTx(ctx, tx, r.Builder, cache, reservationId, status)
+	if err != nil {
+		return dbmodel.Reservation{}, err
+	}
+	if err = pushOutboxTx(ctx, tx, r.Builder, dbmodel.OperationDereservation, dbmodel.Event{
+		UserId:   reservation.UserId,
+		Amount:   reservation.Amount,
+		Currency: reservation.Currency,
+	}); err != nil {
+		return dbmodel.Reservation{}, err
+	}
+
+	if err = cache.commit(ctx, tx); err != nil {
+		log.Errorf("%s/releaseReservation error commit: %s", reservationPrefixLog, err)
+		return dbmodel.Reservation{}, err
+	}
+	return reservation, nil
+}
+
+// Возврат денег по резервированию внутри транзакции: статус, баланс, журнал и операция de-reservation.
+// Событие в outbox пишет вызывающий, потому что для заказа целиком событие одно на пользователя
+func releaseReservationTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, cache *cacheTx, reservationId int, status string) (dbmodel.Reservation, error) {
+	reservation, err := settleReservationTx(ctx, tx, builder, reservationId, status)
+	if err != nil {
+		return dbmodel.Reservation{}, err
+	}
+
+	balance, err := releaseHoldTx(ctx, tx, builder, reservation.UserId, reservation.Currency, reservation.Amount, reservation.Amount)
+	if err != nil {
+		return dbmodel.Reservation{}, err
+	}
+
+	cache.stage(balance)
+
+	entryId, err := postEntryTx(ctx, tx, builder, dbmodel.OperationDereservation,
+		systemPosting(dbmodel.SystemAccountHolds, reservation.Currency, -reservation.Amount),
+		userPosting(reservation.UserId, reservation.Currency, reservation.Amount),
+	)
+	if err != nil {
+		return dbmodel.Reservation{}, err
+	}
+
+	sql, args, _ := builder.
+		Insert("operation").
+		Columns("user_id", "product_id", "order_id", "amount", "currency", "type", "entry_id").
+		Values(reservation.UserId, reservation.ProductId, reservation.OrderId, reservation.Amount, reservation.Currency, dbmodel.OperationDereservation, entryId).
+		ToSql()
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/releaseReservationTx error create operation: %s", reservationPrefixLog, err)
+		return dbmodel.Reservation{}, err
+	}
+	return reservation, nil
+}
+
+// RevenueReservation признание резервирования выручкой. Если amount = 0, то признается вся сумма.
+// Если amount меньше зарезервированного, то остаток в той же транзакции возвращается пользователю отдельной операцией
+func (r *ReservationRepo) RevenueReservation(ctx context.Context, reservationId int, amount money.Amount) (dbmodel.Reservation, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		log.Errorf("%s/RevenueReservation error init tx: %s", reservationPrefixLog, err)
+		return dbmodel.Reservation{}, err
+	}
+	cache := newCacheTx(r.redis)
+	defer cache.rollback(ctx, tx)
+
+	reservation, err := recognizeReservationTx(ctx, tx, r.Builder, cache, reservationId, amount)
+	if err != nil {
+		return dbmodel.Reservation{}, err
+	}
+	recognized := *reservation.RecognizedAmount
+	if err = pushOutboxTx(ctx, tx, r.Builder, dbmodel.OperationRevenue, dbmodel.Event{
+		UserId:   reservation.UserId,
+		Amount:   recognized,
+		Currency: reservation.Currency,
+	}); err != nil {
+		return dbmodel.Reservation{}, err
+	}
+	if remainder := reservation.Amount - recognized; remainder > 0 {
+		if err = pushOutboxTx(ctx, tx, r.Builder, dbmodel.OperationDereservation, dbmodel.Event{
+			UserId:   reservation.UserId,
+			Amount:   remainder,
+			Currency: reservation.Currency,
+		}); err != nil {
+			return dbmodel.Reservation{}, err
+		}
+	}
+
+	if err = cache.commit(ctx, tx); err != nil {
+		log.Errorf("%s/RevenueReservation error commit: %s", reservationPrefixLog, err)
+		return dbmodel.Reservation{}, err
+	}
+	return reservation, nil
+}
+
+// Признание резервирования выручкой внутри транзакции. Остаток (если amount меньше суммы) возвращается пользователю
+// в той же записи журнала. Событие в outbox пишет вызывающий
+func recognizeReservationTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, cache *cacheTx, reservationId int, amount money.Amount) (dbmodel.Reservation, error) {
+	reservation, err := settleReservationTx(ctx, tx, builder, reservationId, dbmodel.ReservationRecognized)
+	if err != nil {
+		return dbmodel.Reservation{}, err
+	}
+
+	recognized := reservation.Amount
+	if amount != 0 {
+		if amount > reservation.Amount {
+			return dbmodel.Reservation{}, pgerrs.ErrRevenueExceedsReservation
+		}
+		recognized = amount
+	}
+	remainder := reservation.Amount - recognized
+
+	sql, args, _ := builder.
+		Update("reservation").
+		Set("recognized_amount", recognized).
 		Where("id = ?", reservationId).
-		Suffix("returning user_id, product_id, order_id, amount").
 		ToSql()
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/recognizeReservationTx error update recognized amount: %s", reservationPrefixLog, err)
+		return dbmodel.Reservation{}, err
+	}
+	reservation.RecognizedAmount = &recognized
 
-	var reservation dbmodel.Reservation
-	if err = tx.QueryRow(ctx, sql, args...).Scan(&reservation.UserId, &reservation.ProductId, &reservation.OrderId, &reservation.Amount); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return 0, 0, pgerrs.ErrNotFound
+	// удержание снимается целиком, даже если выручка признана частично
+	balance, err := releaseHoldTx(ctx, tx, builder, reservation.UserId, reservation.Currency, reservation.Amount, remainder)
+	if err != nil {
+		return dbmodel.Reservation{}, err
+	}
+	cache.stage(balance)
+
+	// остаток возвращается пользователю в той же записи журнала, что и выручка
+	postings := []dbmodel.Posting{
+		systemPosting(dbmodel.SystemAccountHolds, reservation.Currency, -reservation.Amount),
+		systemPosting(dbmodel.SystemAccountRevenue, reservation.Currency, recognized),
+	}
+	if remainder > 0 {
+		postings = append(postings, userPosting(reservation.UserId, reservation.Currency, remainder))
+	}
+	entryId, err := postEntryTx(ctx, tx, builder, dbmodel.OperationRevenue, postings...)
+	if err != nil {
+		return dbmodel.Reservation{}, err
+	}
+
+	insert := builder.
+		Insert("operation").
+		Columns("user_id", "product_id", "order_id", "amount", "currency", "type", "entry_id").
+		Values(reservation.UserId, reservation.ProductId, reservation.OrderId, recognized, reservation.Currency, dbmodel.OperationRevenue, entryId)
+	if remainder > 0 {
+		insert = insert.Values(reservation.UserId, reservation.ProductId, reservation.OrderId, remainder, reservation.Currency, dbmodel.OperationDereservation, entryId)
+	}
+	sql, args, _ = insert.ToSql()
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/recognizeReservationTx error create operation: %s", reservationPrefixLog, err)
+		return dbmodel.Reservation{}, err
+	}
+	return reservation, nil
+}
+
+// CancelOrder отмена всех активных резервирований заказа в одной транзакции
+func (r *ReservationRepo) CancelOrder(ctx context.Context, orderId int) ([]dbmodel.OrderSettlement, error) {
+	return r.settleOrder(ctx, orderId, dbmodel.ReservationCancelled)
+}
+
+// RevenueOrder признание выручкой всех активных резервирований заказа (на полную сумму) в одной транзакции
+func (r *ReservationRepo) RevenueOrder(ctx context.Context, orderId int) ([]dbmodel.OrderSettlement, error) {
+	return r.settleOrder(ctx, orderId, dbmodel.ReservationRecognized)
+}
+
+// Закрытие заказа целиком. Все резервирования заказа блокируются в порядке id, активные переводятся в status,
+// остальные возвращаются как есть с Settled = false. Либо закрываются все активные, либо ни одно.
+// В outbox пишется по событию на каждого пользователя заказа. Если резервирований нет - ErrNotFound, если активных нет - ErrReservationNotActive
+func (r *ReservationRepo) settleOrder(ctx context.Context, orderId int, status string) ([]dbmodel.OrderSettlement, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		log.Errorf("%s/settleOrder error init tx: %s", reservationPrefixLog, err)
+		return nil, err
+	}
+	cache := newCacheTx(r.redis)
+	defer cache.rollback(ctx, tx)
+
+	reservations, err := getOrderReservationsForUpdateTx(ctx, tx, r.Builder, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	events := newOrderEvents(orderId, status)
+	result := make([]dbmodel.OrderSettlement, 0, len(reservations))
+	for _, reservation := range reservations {
+		if reservation.Status != dbmodel.ReservationActive {
+			result = append(result, dbmodel.OrderSettlement{Reservation: reservation})
+			continue
+		}
+		if status == dbmodel.ReservationCancelled {
+			reservation, err = releaseReservationTx(ctx, tx, r.Builder, cache, reservation.Id, status)
+		} else {
+			reservation, err = recognizeReservationTx(ctx, tx, r.Builder, cache, reservation.Id, 0)
+		}
+		if err != nil {
+			return nil, err
 		}
-		log.Errorf("%s/DeleteReservation error delete reservation: %s", reservationPrefixLog, err)
-		return 0, 0, err
+		events.add(reservation, reservation.Amount)
+		result = append(result, dbmodel.OrderSettlement{Reservation: reservation, Settled: true})
+	}
+	if events.empty() {
+		return nil, pgerrs.ErrReservationNotActive
 	}
 
-	sql, args, _ = r.Builder.
-		Update("account").
-		Set("balance", squirrel.Expr("balance + ?", reservation.Amount)).
-		Where("user_id = ?", reservation.UserId).
-		Suffix("returning balance").
+	key := dbmodel.EventOrderCancelled
+	if status == dbmodel.ReservationRecognized {
+		key = dbmodel.EventOrderRecognized
+	}
+	if err = events.pushTx(ctx, tx, r.Builder, key); err != nil {
+		return nil, err
+	}
+
+	if err = cache.commit(ctx, tx); err != nil {
+		log.Errorf("%s/settleOrder error commit: %s", reservationPrefixLog, err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// RefundReservation возврат пользователю части или всей признанной выручки по резервированию.
+// Если amount = 0, то возвращается все, что еще не вернули. Вернуть больше признанного нельзя
+func (r *ReservationRepo) RefundReservation(ctx context.Context, reservationId int, amount money.Amount, key *dbmodel.IdempotencyKey) (dbmodel.Reservation, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		log.Errorf("%s/RefundReservation error init tx: %s", reservationPrefixLog, err)
+		return dbmodel.Reservation{}, err
+	}
+	cache := newCacheTx(r.redis)
+	defer cache.rollback(ctx, tx)
+
+	stored, err := claimIdempotencyKeyTx(ctx, tx, r.Builder, key)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrIdempotentReplay) {
+			var reservation dbmodel.Reservation
+			_ = json.Unmarshal(stored, &reservation)
+			return reservation, err
+		}
+		return dbmodel.Reservation{}, err
+	}
+
+	reservation, err := getReservationForUpdateTx(ctx, tx, r.Builder, reservationId)
+	if err != nil {
+		return dbmodel.Reservation{}, err
+	}
+	refund, err := refundReservationTx(ctx, tx, r.Builder, cache, reservation, amount)
+	if err != nil {
+		return dbmodel.Reservation{}, err
+	}
+	reservation.RefundedAmount += refund
+
+	if err = pushOutboxTx(ctx, tx, r.Builder, dbmodel.OperationRefund, dbmodel.Event{
+		UserId:   reservation.UserId,
+		Amount:   refund,
+		Currency: reservation.Currency,
+	}); err != nil {
+		return dbmodel.Reservation{}, err
+	}
+	if err = saveIdempotentResponseTx(ctx, tx, r.Builder, key, reservation); err != nil {
+		return dbmodel.Reservation{}, err
+	}
+
+	if err = cache.commit(ctx, tx); err != nil {
+		log.Errorf("%s/RefundReservation error commit: %s", reservationPrefixLog, err)
+		return dbmodel.Reservation{}, err
+	}
+	return reservation, nil
+}
+
+// RefundOrder возврат всей еще не возвращенной выручки по всем признанным резервированиям заказа в одной транзакции.
+// В outbox пишется по событию на каждого пользователя заказа. Если резервирований нет - ErrNotFound, если возвращать нечего - ErrNothingToRefund
+func (r *ReservationRepo) RefundOrder(ctx context.Context, orderId int) ([]dbmodel.OrderSettlement, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		log.Errorf("%s/RefundOrder error init tx: %s", reservationPrefixLog, err)
+		return nil, err
+	}
+	cache := newCacheTx(r.redis)
+	defer cache.rollback(ctx, tx)
+
+	reservations, err := getOrderReservationsForUpdateTx(ctx, tx, r.Builder, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	events := newOrderEvents(orderId, dbmodel.OperationRefund)
+	result := make([]dbmodel.OrderSettlement, 0, len(reservations))
+	for _, reservation := range reservations {
+		if reservation.Status != dbmodel.ReservationRecognized || *reservation.RecognizedAmount == reservation.RefundedAmount {
+			result = append(result, dbmodel.OrderSettlement{Reservation: reservation})
+			continue
+		}
+		refund, err := refundReservationTx(ctx, tx, r.Builder, cache, reservation, 0)
+		if err != nil {
+			return nil, err
+		}
+		reservation.RefundedAmount += refund
+
+		events.add(reservation, refund)
+		result = append(result, dbmodel.OrderSettlement{Reservation: reservation, Settled: true})
+	}
+	if events.empty() {
+		return nil, pgerrs.ErrNothingToRefund
+	}
+
+	if err = events.pushTx(ctx, tx, r.Builder, dbmodel.EventOrderRefunded); err != nil {
+		return nil, err
+	}
+
+	if err = cache.commit(ctx, tx); err != nil {
+		log.Errorf("%s/RefundOrder error commit: %s", reservationPrefixLog, err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// Возврат по заблокированному резервированию: деньги уходят со счета выручки пользователю, операция refund
+// привязана к резервированию через product_id и order_id. На закрытый счет возврат не зачисляется. Возвращает сумму возврата
+func refundReservationTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, cache *cacheTx, reservation dbmodel.Reservation, amount money.Amount) (money.Amount, error) {
+	if reservation.Status != dbmodel.ReservationRecognized {
+		return 0, pgerrs.ErrReservationNotRecognized
+	}
+	refundable := *reservation.RecognizedAmount - reservation.RefundedAmount
+	if amount == 0 {
+		amount = refundable
+	}
+	if amount == 0 {
+		return 0, pgerrs.ErrNothingToRefund
+	}
+	if amount > refundable {
+		return 0, pgerrs.ErrRefundExceedsRevenue
+	}
+	if err := checkAccountStatusTx(ctx, tx, builder, reservation.UserId, false); err != nil {
+		return 0, err
+	}
+
+	sql, args, _ := builder.
+		Update("reservation").
+		Set("refunded_amount", squirrel.Expr("refunded_amount + ?", amount)).
+		Where("id = ?", reservation.Id).
 		ToSql()
+	if _, err := tx.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/refundReservationTx error update refunded amount: %s", reservationPrefixLog, err)
+		return 0, err
+	}
 
-	var balance float64
-	if err = tx.QueryRow(ctx, sql, args...).Scan(&balance); err != nil {
-		log.Errorf("%s/DeleteReservation error update account balance: %s", reservationPrefixLog, err)
-		return 0, 0, err
+	balance, err := addBalanceTx(ctx, tx, builder, reservation.UserId, reservation.Currency, amount)
+	if err != nil {
+		return 0, err
 	}
+	cache.stage(balance)
 
-	if err = setCacheBalance(ctx, r.redis, reservation.UserId, balance); err != nil {
-		return 0, 0, err
+	entryId, err := postEntryTx(ctx, tx, builder, dbmodel.OperationRefund,
+		systemPosting(dbmodel.SystemAccountRevenue, reservation.Currency, -amount),
+		userPosting(reservation.UserId, reservation.Currency, amount),
+	)
+	if err != nil {
+		return 0, err
 	}
 
-	sql, args, _ = r.Builder.
+	sql, args, _ = builder.
 		Insert("operation").
-		Columns("user_id", "product_id", "order_id", "amount", "type").
-		Values(reservation.UserId, reservation.ProductId, reservation.OrderId, reservation.Amount, dbmodel.OperationDereservation).
+		Columns("user_id", "product_id", "order_id", "amount", "currency", "type", "entry_id").
+		Values(reservation.UserId, reservation.ProductId, reservation.OrderId, amount, reservation.Currency, dbmodel.OperationRefund, entryId).
 		ToSql()
 	if _, err = tx.Exec(ctx, sql, args...); err != nil {
-		log.Errorf("%s/DeleteReservation error create operation: %s", reservationPrefixLog, err)
-		return 0, 0, err
+		log.Errorf("%s/refundReservationTx error create operation: %s", reservationPrefixLog, err)
+		return 0, err
 	}
+	return amount, nil
+}
 
-	if err = tx.Commit(ctx); err != nil {
-		log.Errorf("%s/DeleteReservation error commit: %s", reservationPrefixLog, err)
-		return 0, 0, err
+func getReservationForUpdateTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, reservationId int) (dbmodel.Reservation, error) {
+	sql, args, _ := builder.
+		Select(reservationColumns).
+		From("reservation").
+		Where("id = ?", reservationId).
+		Suffix("for update").
+		ToSql()
+
+	reservation, err := scanReservation(tx.QueryRow(ctx, sql, args...))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbmodel.Reservation{}, pgerrs.ErrNotFound
+		}
+		log.Errorf("%s/getReservationForUpdateTx error get reservation: %s", reservationPrefixLog, err)
+		return dbmodel.Reservation{}, err
 	}
-	return reservation.UserId, reservation.Amount, nil
+	return reservation, nil
 }
 
-func (r *ReservationRepo) RevenueReservation(ctx context.Context, reservationId int) (int, float64, error) {
-	tx, err := r.Pool.Begin(ctx)
+// События закрытия заказа. В одном заказе могут быть резервирования разных пользователей,
+// поэтому событие собирается отдельно на каждого в порядке первого резервирования пользователя
+type orderEvents struct {
+	orderId int
+	status  string
+	events  []*dbmodel.OrderEvent
+}
+
+func newOrderEvents(orderId int, status string) *orderEvents {
+	return &orderEvents{orderId: orderId, status: status}
+}
+
+// add закрытое резервирование на сумму amount в событие его пользователя
+func (e *orderEvents) add(reservation dbmodel.Reservation, amount money.Amount) {
+	var event *dbmodel.OrderEvent
+	for _, ev := range e.events {
+		if ev.UserId == reservation.UserId {
+			event = ev
+			break
+		}
+	}
+	if event == nil {
+		event = &dbmodel.OrderEvent{
+			OrderId: e.orderId,
+			UserId:  reservation.UserId,
+			Status:  e.status,
+			Amounts: make(map[string]money.Amount),
+		}
+		e.events = append(e.events, event)
+	}
+	event.ReservationIds = append(event.ReservationIds, reservation.Id)
+	event.Amounts[reservation.Currency] += amount
+}
+
+func (e *orderEvents) empty() bool {
+	return len(e.events) == 0
+}
+
+func (e *orderEvents) pushTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, key string) error {
+	for _, event := range e.events {
+		if err := pushOutboxPayloadTx(ctx, tx, builder, key, event.UserId, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Все резервирования заказа, заблокированные в порядке id, чтобы параллельные операции над заказом не ловили deadlock.
+// Если резервирований нет - ErrNotFound
+func getOrderReservationsForUpdateTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, orderId int) ([]dbmodel.Reservation, error) {
+	sql, args, _ := builder.
+		Select(reservationColumns).
+		From("reservation").
+		Where("order_id = ?", orderId).
+		OrderBy("id").
+		Suffix("for update").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
 	if err != nil {
-		log.Errorf("%s/RevenueReservation error init tx: %s", reservationPrefixLog, err)
-		return 0, 0, err
+		log.Errorf("%s/getOrderReservationsForUpdateTx error get order reservations: %s", reservationPrefixLog, err)
+		return nil, err
+	}
+	reservations, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (dbmodel.Reservation, error) {
+		return scanReservation(row)
+	})
+	if err != nil {
+		log.Errorf("%s/getOrderReservationsForUpdateTx error scan order reservations: %s", reservationPrefixLog, err)
+		return nil, err
 	}
-	defer func() { _ = tx.Rollback(ctx) }()
+	if len(reservations) == 0 {
+		return nil, pgerrs.ErrNotFound
+	}
+	return reservations, nil
+}
 
+// Перевод активного резервирования в конечный статус. Строка не удаляется, чтобы по резервированию оставалась история.
+// Если резервирования нет - ErrNotFound, если оно уже отменено или признано - ErrReservationNotActive
+func settleReservationTx(ctx context.Context, tx pgx.Tx, builder squirrel.StatementBuilderType, reservationId int, status string) (dbmodel.Reservation, error) {
+	update := builder.
+		Update("reservation").
+		Set("status", status).
+		Where("id = ? and status = ?", reservationId, dbmodel.ReservationActive).
+		Suffix("returning " + reservationColumns)
+	switch status {
+	case dbmodel.ReservationCancelled:
+		update = update.Set("cancelled_at", squirrel.Expr("now()"))
+	case dbmodel.ReservationRecognized:
+		update = update.Set("recognized_at", squirrel.Expr("now()"))
+	case dbmodel.ReservationExpired:
+		update = update.Set("expired_at", squirrel.Expr("now()"))
+	}
+	sql, args, _ := update.ToSql()
+
+	reservation, err := scanReservation(tx.QueryRow(ctx, sql, args...))
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Errorf("%s/settleReservationTx error update reservation: %s", reservationPrefixLog, err)
+			return dbmodel.Reservation{}, err
+		}
+		sql, args, _ = builder.
+			Select("status").
+			From("reservation").
+			Where("id = ?", reservationId).
+			ToSql()
+
+		var current string
+		if err = tx.QueryRow(ctx, sql, args...).Scan(&current); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return dbmodel.Reservation{}, pgerrs.ErrNotFound
+			}
+			log.Errorf("%s/settleReservationTx error get reservation status: %s", reservationPrefixLog, err)
+			return dbmodel.Reservation{}, err
+		}
+		return dbmodel.Reservation{}, pgerrs.ErrReservationNotActive
+	}
+	return reservation, nil
+}
+
+const reservationColumns = "id, user_id, product_id, order_id, amount, currency, status, recognized_amount, refunded_amount, " +
+	"created_at, expires_at, cancelled_at, recognized_at, expired_at"
+
+func scanReservation(row pgx.Row) (dbmodel.Reservation, error) {
+	var reservation dbmodel.Reservation
+	err := row.Scan(
+		&reservation.Id,
+		&reservation.UserId,
+		&reservation.ProductId,
+		&reservation.OrderId,
+		&reservation.Amount,
+		&reservation.Currency,
+		&reservation.Status,
+		&reservation.RecognizedAmount,
+		&reservation.RefundedAmount,
+		&reservation.CreatedAt,
+		&reservation.ExpiresAt,
+		&reservation.CancelledAt,
+		&reservation.RecognizedAt,
+		&reservation.ExpiredAt,
+	)
+	return reservation, err
+}
+
+func (r *ReservationRepo) GetReservation(ctx context.Context, reservationId int) (dbmodel.Reservation, error) {
 	sql, args, _ := r.Builder.
-		Delete("reservation").
+		Select(reservationColumns).
+		From("reservation").
 		Where("id = ?", reservationId).
-		Suffix("returning user_id, product_id, order_id, amount").
 		ToSql()
 
-	var reservation dbmodel.Reservation
-	if err = tx.QueryRow(ctx, sql, args...).Scan(&reservation.UserId, &reservation.ProductId, &reservation.OrderId, &reservation.Amount); err != nil {
+	reservation, err := scanReservation(r.Pool.QueryRow(ctx, sql, args...))
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return 0, 0, pgerrs.ErrNotFound
+			return dbmodel.Reservation{}, pgerrs.ErrNotFound
 		}
-		log.Errorf("%s/RevenueReservation error delete reservation: %s", reservationPrefixLog, err)
-		return 0, 0, err
+		log.Errorf("%s/GetReservation error get reservation: %s", reservationPrefixLog, err)
+		return dbmodel.Reservation{}, err
 	}
+	return reservation, nil
+}
 
-	sql, args, _ = r.Builder.
-		Insert("operation").
-		Columns("user_id", "product_id", "order_id", "amount", "type").
-		Values(reservation.UserId, reservation.ProductId, reservation.OrderId, reservation.Amount, dbmodel.OperationRevenue).
+// GetReservations список резервирований по фильтру, новые сначала. Пустые поля фильтра не учитываются
+func (r *ReservationRepo) GetReservations(ctx context.Context, filter dbmodel.ReservationFilter) ([]dbmodel.Reservation, error) {
+	where := squirrel.Eq{}
+	if filter.UserId != 0 {
+		where["user_id"] = filter.UserId
+	}
+	if filter.OrderId != 0 {
+		where["order_id"] = filter.OrderId
+	}
+	if filter.ProductId != 0 {
+		where["product_id"] = filter.ProductId
+	}
+	if filter.Status != "" {
+		where["status"] = filter.Status
+	}
+
+	sql, args, _ := r.Builder.
+		Select(reservationColumns).
+		From("reservation").
+		Where(where).
+		OrderBy("id desc").
+		Offset(uint64(filter.Offset)).
+		Limit(uint64(filter.Limit)).
 		ToSql()
-	if _, err = tx.Exec(ctx, sql, args...); err != nil {
-		log.Errorf("%s/RevenueReservation error create operation: %s", reservationPrefixLog, err)
-		return 0, 0, err
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/GetReservations error get reservations: %s", reservationPrefixLog, err)
+		return nil, err
 	}
+	defer rows.Close()
 
-	if err = tx.Commit(ctx); err != nil {
-		log.Errorf("%s/RevenueReservation error commit: %s", reservationPrefixLog, err)
-		return 0, 0, err
+	var result []dbmodel.Reservation
+	for rows.Next() {
+		reservation, err := scanReservation(rows)
+		if err != nil {
+			log.Errorf("%s/GetReservations error scan reservation: %s", reservationPrefixLog, err)
+			return nil, err
+		}
+		result = append(result, reservation)
 	}
-	return reservation.UserId, reservation.Amount, nil
+	return result, rows.Err()
 }
@@ -0,0 +1,161 @@
+package pgdb
+
+import (
+	"avito_intership/internal/repo/pgerrs"
+	"avito_intership/pkg/money"
+	"context"
+	"errors"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const snapshotPrefixLog = "/pgdb/snapshot"
+
+// общий интерфейс пула и транзакции для запросов, которые выполняются и там, и там
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// GetBalanceAsOf баланс на момент времени по журналу: последний снимок не позже asOf плюс проводки после него.
+// Как и текущий баланс, не включает удержанное резервированиями
+func (r *AccountRepo) GetBalanceAsOf(ctx context.Context, userId int, currency string, asOf time.Time) (money.Amount, error) {
+	return balanceAsOf(ctx, r.Pool, r.Builder, userId, currency, asOf, true)
+}
+
+// Баланс по снимку и проводкам. inclusive - учитывать ли проводки, созданные ровно в asOf
+// (для входящего остатка выписки они относятся уже к самой выписке)
+func balanceAsOf(ctx context.Context, q queryRower, builder squirrel.StatementBuilderType, userId int, currency string, asOf time.Time, inclusive bool) (money.Amount, error) {
+	sql, args, _ := builder.
+		Select("s.taken_at", "s.balance").
+		From("account a").
+		LeftJoin("lateral (select taken_at, balance from balance_snapshot "+
+			"where user_id = a.user_id and currency = ? and taken_at <= ? "+
+			"order by taken_at desc limit 1) s on true", currency, asOf).
+		Where("a.user_id = ?", userId).
+		ToSql()
+
+	var (
+		takenAt *time.Time
+		balance *money.Amount
+	)
+	if err := q.QueryRow(ctx, sql, args...).Scan(&takenAt, &balance); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, pgerrs.ErrNotFound
+		}
+		log.Errorf("%s/balanceAsOf error get snapshot: %s", snapshotPrefixLog, err)
+		return 0, err
+	}
+
+	query := builder.
+		Select("coalesce(sum(amount), 0)::bigint").
+		From("posting").
+		Where("user_id = ? and currency = ?", userId, currency)
+	if inclusive {
+		query = query.Where("created_at <= ?", asOf)
+	} else {
+		query = query.Where("created_at < ?", asOf)
+	}
+	if takenAt != nil {
+		query = query.Where("created_at >= ?", *takenAt)
+	}
+	sql, args, _ = query.ToSql()
+
+	var delta money.Amount
+	if err := q.QueryRow(ctx, sql, args...).Scan(&delta); err != nil {
+		log.Errorf("%s/balanceAsOf error sum postings: %s", snapshotPrefixLog, err)
+		return 0, err
+	}
+	if balance != nil {
+		delta += *balance
+	}
+	return delta, nil
+}
+
+// TakeBalanceSnapshots снимки балансов на все прошедшие границы дня, которые еще не сняты. Граница снимается
+// только спустя delay, чтобы успели закоммититься транзакции, начатые до нее. Каждая граница - своя транзакция,
+// новый снимок считается от предыдущего снимка аккаунта. Возвращает количество снятых границ
+func (r *AccountRepo) TakeBalanceSnapshots(ctx context.Context, delay time.Duration) (int, error) {
+	taken := 0
+	for {
+		done, err := r.takeNextBalanceSnapshot(ctx, delay)
+		if err != nil {
+			return taken, err
+		}
+		if done {
+			return taken, nil
+		}
+		taken++
+	}
+}
+
+// Снимок следующей неснятой границы дня. Возвращает true, если снимать больше нечего
+func (r *AccountRepo) takeNextBalanceSnapshot(ctx context.Context, delay time.Duration) (bool, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		log.Errorf("%s/takeNextBalanceSnapshot error init tx: %s", snapshotPrefixLog, err)
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// первая граница - последний завершенный день, дальше по одному дню от последней снятой
+	sql, args, _ := r.Builder.
+		Select().
+		Column(squirrel.Expr("coalesce(max(taken_at) + interval '1 day', date_trunc('day', now() - make_interval(secs => ?)))", delay.Seconds())).
+		Column(squirrel.Expr("date_trunc('day', now() - make_interval(secs => ?))", delay.Seconds())).
+		From("balance_snapshot_run").
+		ToSql()
+
+	var next, last time.Time
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&next, &last); err != nil {
+		log.Errorf("%s/takeNextBalanceSnapshot error get next snapshot time: %s", snapshotPrefixLog, err)
+		return false, err
+	}
+	if next.After(last) {
+		return true, nil
+	}
+
+	// границу может параллельно снимать другая реплика - тогда эта ждет ее коммита и пропускает границу
+	sql, args, _ = r.Builder.
+		Insert("balance_snapshot_run").
+		Columns("taken_at").
+		Values(next).
+		Suffix("on conflict do nothing").
+		ToSql()
+	tag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		log.Errorf("%s/takeNextBalanceSnapshot error create snapshot run: %s", snapshotPrefixLog, err)
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	// снимок пишется только для аккаунтов, у которых были проводки после их предыдущего снимка
+	sql, args, _ = r.Builder.
+		Insert("balance_snapshot").
+		Columns("user_id", "currency", "taken_at", "balance").
+		Select(r.Builder.
+			Select("p.user_id", "p.currency").
+			Column(squirrel.Expr("?::timestamp", next)).
+			Column("coalesce(max(l.balance), 0) + sum(p.amount)").
+			From("posting p").
+			LeftJoin("lateral (select taken_at, balance from balance_snapshot "+
+				"where user_id = p.user_id and currency = p.currency and taken_at < ? "+
+				"order by taken_at desc limit 1) l on true", next).
+			Where("p.user_id is not null and p.created_at < ?", next).
+			Where("(l.taken_at is null or p.created_at >= l.taken_at)").
+			GroupBy("p.user_id", "p.currency")).
+		ToSql()
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		log.Errorf("%s/takeNextBalanceSnapshot error create snapshots: %s", snapshotPrefixLog, err)
+		return false, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Errorf("%s/takeNextBalanceSnapshot error commit: %s", snapshotPrefixLog, err)
+		return false, err
+	}
+	return false, nil
+}
@@ -1,9 +1,46 @@
 package pgerrs
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrNotFound         = errors.New("not found")
 	ErrAlreadyExists    = errors.New("already exists")
 	ErrNotEnoughBalance = errors.New("not enough balance")
+	ErrCreditLimitDebt  = errors.New("credit limit is less than current debt")
+	ErrAccountFrozen    = errors.New("account is frozen")
+	ErrAccountClosed    = errors.New("account is closed")
+	ErrAccountNotEmpty  = errors.New("account has money or active reservations")
+	ErrRateNotFound     = errors.New("exchange rate not found")
+	ErrUnbalancedEntry  = errors.New("journal entry is not balanced")
+
+	ErrReservationNotActive      = errors.New("reservation is not active")
+	ErrRevenueExceedsReservation = errors.New("revenue amount exceeds reserved amount")
+	ErrReservationNotRecognized  = errors.New("reservation is not recognized")
+	ErrRefundExceedsRevenue      = errors.New("refund amount exceeds recognized revenue")
+	ErrNothingToRefund           = errors.New("nothing to refund")
+	ErrReservationExpiresAt      = errors.New("reservation expiration time is in the past")
+
+	ErrIdempotentReplay     = errors.New("request with this idempotency key is already processed")
+	ErrIdempotencyKeyReused = errors.New("idempotency key is already used with another request")
+
+	ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+	ErrReportJobLost = errors.New("report job is taken by another attempt or already finished")
 )
+
+// LimitExceededError операция превышает лимит расходов. Current - сколько уже потрачено (или сколько было операций)
+// в окне лимита до этой операции, для лимита на одну операцию - сумма самой операции
+type LimitExceededError struct {
+	Limit    string
+	Scope    string
+	Currency string
+	Max      int64
+	Current  int64
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s %s limit exceeded: max %d, current %d %s", e.Scope, e.Limit, e.Max, e.Current, e.Currency)
+}
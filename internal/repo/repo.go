@@ -3,35 +3,87 @@ package repo
 import (
 	"avito_intership/internal/model/dbmodel"
 	"avito_intership/internal/repo/pgdb"
+	"avito_intership/pkg/money"
 	"avito_intership/pkg/postgres"
 	"avito_intership/pkg/redis"
 	"context"
+	"time"
 )
 
 type Account interface {
 	CreateAccount(ctx context.Context, userId int) error
-	GetBalance(ctx context.Context, userId int) (float64, error)
+	GetBalance(ctx context.Context, userId int, currency string) (dbmodel.Balance, error)
+	GetBalances(ctx context.Context, userId int) ([]dbmodel.Balance, error)
+	GetHolds(ctx context.Context, userId int, currency string) ([]dbmodel.OrderHold, error)
+	GetBalanceAsOf(ctx context.Context, userId int, currency string, asOf time.Time) (money.Amount, error)
+	TakeBalanceSnapshots(ctx context.Context, delay time.Duration) (int, error)
 
-	Deposit(ctx context.Context, userId int, amount float64) error
-	Withdraw(ctx context.Context, userId int, amount float64) error
-	Transfer(ctx context.Context, sendId, receiveId int, amount float64) error
+	Deposit(ctx context.Context, userId int, currency string, amount money.Amount, key *dbmodel.IdempotencyKey) error
+	Withdraw(ctx context.Context, userId int, currency string, amount money.Amount, key *dbmodel.IdempotencyKey) error
+	SetCreditLimit(ctx context.Context, userId int, currency string, limit money.Amount) (dbmodel.Balance, error)
+	SetStatus(ctx context.Context, userId int, status, reason string) error
+	GetStatus(ctx context.Context, userId int) (dbmodel.Account, []dbmodel.AccountStatusChange, error)
+	Transfer(ctx context.Context, sendId, receiveId int, amount money.Amount, fromCurrency, toCurrency string, key *dbmodel.IdempotencyKey) (money.Amount, error)
 }
 
 type Reservation interface {
-	CreateReservation(ctx context.Context, reservation dbmodel.Reservation) (int, error)
-	DeleteReservation(ctx context.Context, reservationId int) (int, float64, error)
-	RevenueReservation(ctx context.Context, reservationId int) (int, float64, error)
+	CreateReservation(ctx context.Context, reservation dbmodel.Reservation, key *dbmodel.IdempotencyKey) (int, error)
+	CancelReservation(ctx context.Context, reservationId int) (dbmodel.Reservation, error)
+	RevenueReservation(ctx context.Context, reservationId int, amount money.Amount) (dbmodel.Reservation, error)
+	GetReservation(ctx context.Context, reservationId int) (dbmodel.Reservation, error)
+	GetReservations(ctx context.Context, filter dbmodel.ReservationFilter) ([]dbmodel.Reservation, error)
+	ExpireReservations(ctx context.Context, limit int) (int, error)
+	CancelOrder(ctx context.Context, orderId int) ([]dbmodel.OrderSettlement, error)
+	RevenueOrder(ctx context.Context, orderId int) ([]dbmodel.OrderSettlement, error)
+	RefundReservation(ctx context.Context, reservationId int, amount money.Amount, key *dbmodel.IdempotencyKey) (dbmodel.Reservation, error)
+	RefundOrder(ctx context.Context, orderId int) ([]dbmodel.OrderSettlement, error)
 }
 
 type Operation interface {
-	GetHistory(ctx context.Context, userId int, sort string, offset, limit int) ([]dbmodel.Operation, error)
-	GroupProductRevenue(ctx context.Context, year, month int) (map[int]float64, error)
+	GetHistory(ctx context.Context, query dbmodel.HistoryQuery) ([]dbmodel.Operation, error)
+	StreamHistory(ctx context.Context, query dbmodel.HistoryQuery, fn func(dbmodel.Operation) error) error
+	GetStatement(ctx context.Context, userId int, currency string, from, to time.Time) (dbmodel.Statement, error)
+	GroupProductRevenue(ctx context.Context, year, month int) ([]dbmodel.ProductRevenue, error)
+	GroupRevenue(ctx context.Context, query dbmodel.RevenueQuery) ([]dbmodel.RevenueGroup, error)
+}
+
+type Rate interface {
+	SetRate(ctx context.Context, base, quote string, rate money.Rate) error
+	GetRate(ctx context.Context, base, quote string) (dbmodel.ExchangeRate, error)
+}
+
+type Ledger interface {
+	Check(ctx context.Context) ([]dbmodel.LedgerImbalance, error)
+}
+
+type Limit interface {
+	SetLimit(ctx context.Context, limit dbmodel.SpendingLimit) (dbmodel.SpendingLimit, error)
+	GetLimits(ctx context.Context, userId *int) ([]dbmodel.SpendingLimit, error)
+	DeleteLimit(ctx context.Context, userId *int, currency string) error
+}
+
+type Outbox interface {
+	Relay(ctx context.Context, limit int, publish func(context.Context, dbmodel.OutboxMessage) error) (int, error)
+	DeleteSent(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+type Report interface {
+	CreateJob(ctx context.Context, job dbmodel.ReportJob) (dbmodel.ReportJob, bool, error)
+	GetJob(ctx context.Context, id int) (dbmodel.ReportJob, error)
+	ClaimJob(ctx context.Context, timeout time.Duration, maxAttempts int) (dbmodel.ReportJob, error)
+	FinishJob(ctx context.Context, id, attempt int, fileName string) error
+	FailJob(ctx context.Context, id, attempt int, reason string) error
 }
 
 type Repositories struct {
 	Account
 	Reservation
 	Operation
+	Rate
+	Ledger
+	Limit
+	Outbox
+	Report
 }
 
 func NewRepositories(pg *postgres.Postgres, redis redis.Redis) *Repositories {
@@ -39,5 +91,10 @@ func NewRepositories(pg *postgres.Postgres, redis redis.Redis) *Repositories {
 		Account:     pgdb.NewAccountRepo(pg, redis),
 		Reservation: pgdb.NewReservationRepo(pg, redis),
 		Operation:   pgdb.NewOperationRepo(pg),
+		Rate:        pgdb.NewRateRepo(pg),
+		Ledger:      pgdb.NewLedgerRepo(pg),
+		Limit:       pgdb.NewLimitRepo(pg),
+		Outbox:      pgdb.NewOutboxRepo(pg),
+		Report:      pgdb.NewReportRepo(pg),
 	}
 }
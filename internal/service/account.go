@@ -4,10 +4,10 @@ import (
 	"avito_intership/internal/model/dbmodel"
 	"avito_intership/internal/repo"
 	"avito_intership/internal/repo/pgerrs"
-	"avito_intership/pkg/broker"
 	"context"
 	"errors"
 	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 const (
@@ -15,14 +15,14 @@ const (
 )
 
 type accountService struct {
-	account  repo.Account
-	producer broker.Producer
+	account       repo.Account
+	snapshotDelay time.Duration
 }
 
-func newAccountService(account repo.Account, producer broker.Producer) *accountService {
+func newAccountService(account repo.Account, snapshotDelay time.Duration) *accountService {
 	return &accountService{
-		account:  account,
-		producer: producer,
+		account:       account,
+		snapshotDelay: snapshotDelay,
 	}
 }
 
@@ -37,70 +37,260 @@ func (s *accountService) CreateAccount(ctx context.Context, userId int) error {
 	return nil
 }
 
-func (s *accountService) GetBalance(ctx context.Context, userId int) (float64, error) {
-	balance, err := s.account.GetBalance(ctx, userId)
+func (s *accountService) GetBalance(ctx context.Context, userId int, currency string) (BalanceOutput, error) {
+	currency = currencyOrDefault(currency)
+	balance, err := s.account.GetBalance(ctx, userId, currency)
 	if err != nil {
 		if errors.Is(err, pgerrs.ErrNotFound) {
-			return 0, ErrAccountNotFound
+			return BalanceOutput{}, ErrAccountNotFound
 		}
 		log.Errorf("%s/GetBalance error get balance: %s", accountServicePrefixLog, err)
-		return 0, err
+		return BalanceOutput{}, err
+	}
+	balance.Currency = currency
+	return balanceOutput(balance), nil
+}
+
+func (s *accountService) GetBalances(ctx context.Context, userId int) ([]BalanceOutput, error) {
+	balances, err := s.account.GetBalances(ctx, userId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return nil, ErrAccountNotFound
+		}
+		log.Errorf("%s/GetBalances error get balances: %s", accountServicePrefixLog, err)
+		return nil, err
+	}
+	result := make([]BalanceOutput, 0, len(balances))
+	for _, b := range balances {
+		result = append(result, balanceOutput(b))
+	}
+	return result, nil
+}
+
+// GetBalanceAsOf баланс на момент времени в прошлом, считается по журналу и снимкам балансов
+func (s *accountService) GetBalanceAsOf(ctx context.Context, userId int, currency string, asOf time.Time) (BalanceAsOfOutput, error) {
+	if asOf.After(time.Now()) {
+		return BalanceAsOfOutput{}, ErrBalanceAsOfFuture
+	}
+	currency = currencyOrDefault(currency)
+	balance, err := s.account.GetBalanceAsOf(ctx, userId, currency, asOf)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return BalanceAsOfOutput{}, ErrAccountNotFound
+		}
+		log.Errorf("%s/GetBalanceAsOf error get balance: %s", accountServicePrefixLog, err)
+		return BalanceAsOfOutput{}, err
+	}
+	return BalanceAsOfOutput{
+		Currency: currency,
+		Balance:  balance,
+		AsOf:     asOf,
+	}, nil
+}
+
+// TakeBalanceSnapshots снимки балансов на прошедшие границы дня, вызывается фоновым воркером
+func (s *accountService) TakeBalanceSnapshots(ctx context.Context) (int, error) {
+	taken, err := s.account.TakeBalanceSnapshots(ctx, s.snapshotDelay)
+	if err != nil {
+		log.Errorf("%s/TakeBalanceSnapshots error take snapshots: %s", accountServicePrefixLog, err)
+		return taken, err
+	}
+	return taken, nil
+}
+
+// GetHolds удержания аккаунта с разбивкой по заказам
+func (s *accountService) GetHolds(ctx context.Context, userId int, currency string) ([]HoldOutput, error) {
+	holds, err := s.account.GetHolds(ctx, userId, currency)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return nil, ErrAccountNotFound
+		}
+		log.Errorf("%s/GetHolds error get holds: %s", accountServicePrefixLog, err)
+		return nil, err
+	}
+	result := make([]HoldOutput, 0, len(holds))
+	for _, h := range holds {
+		result = append(result, HoldOutput{
+			OrderId:      h.OrderId,
+			Currency:     h.Currency,
+			Amount:       h.Amount,
+			Reservations: h.Reservations,
+			ExpiresAt:    h.ExpiresAt,
+		})
+	}
+	return result, nil
+}
+
+// SetCreditLimit установка кредитного лимита админом. Нулевой лимит запрещает уходить в минус
+func (s *accountService) SetCreditLimit(ctx context.Context, input CreditLimitInput) (BalanceOutput, error) {
+	balance, err := s.account.SetCreditLimit(ctx, input.UserId, currencyOrDefault(input.Currency), input.CreditLimit)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return BalanceOutput{}, ErrAccountNotFound
+		}
+		if errors.Is(err, pgerrs.ErrCreditLimitDebt) {
+			return BalanceOutput{}, ErrCreditLimitDebt
+		}
+		if statusErr := accountStatusError(err); statusErr != nil {
+			return BalanceOutput{}, statusErr
+		}
+		log.Errorf("%s/SetCreditLimit error set credit limit: %s", accountServicePrefixLog, err)
+		return BalanceOutput{}, err
+	}
+	return balanceOutput(balance), nil
+}
+
+func balanceOutput(balance dbmodel.Balance) BalanceOutput {
+	return BalanceOutput{
+		Currency:    balance.Currency,
+		Balance:     balance.Balance,
+		Reserved:    balance.Reserved,
+		Total:       balance.Balance + balance.Reserved,
+		CreditLimit: balance.CreditLimit,
+		Available:   balance.Balance + balance.CreditLimit,
 	}
-	return balance, nil
 }
 
 func (s *accountService) Deposit(ctx context.Context, input DepositInput) error {
-	if err := s.account.Deposit(ctx, input.UserId, input.Amount); err != nil {
+	input.Currency = currencyOrDefault(input.Currency)
+	key := idempotencyKey(dbmodel.IdempotencyScopeDeposit, input.IdempotencyKey, input)
+	if err := s.account.Deposit(ctx, input.UserId, input.Currency, input.Amount, key); err != nil {
+		if errors.Is(err, pgerrs.ErrIdempotentReplay) { // повтор запроса: деньги уже зачислены, уведомление уже отправлено
+			return nil
+		}
+		if errors.Is(err, pgerrs.ErrIdempotencyKeyReused) {
+			return ErrIdempotencyKeyReused
+		}
 		if errors.Is(err, pgerrs.ErrNotFound) {
 			return ErrAccountNotFound
 		}
+		if statusErr := accountStatusError(err); statusErr != nil {
+			return statusErr
+		}
 		log.Errorf("%s/Deposit error update account balance: %s", accountServicePrefixLog, err)
 		return err
 	}
-
-	if err := pushMessage(s.producer, dbmodel.OperationDeposit, input); err != nil {
-		return err
-	}
 	return nil
 }
 
 func (s *accountService) Withdraw(ctx context.Context, input WithdrawInput) error {
-	if err := s.account.Withdraw(ctx, input.UserId, input.Amount); err != nil {
+	input.Currency = currencyOrDefault(input.Currency)
+	key := idempotencyKey(dbmodel.IdempotencyScopeWithdraw, input.IdempotencyKey, input)
+	if err := s.account.Withdraw(ctx, input.UserId, input.Currency, input.Amount, key); err != nil {
+		if errors.Is(err, pgerrs.ErrIdempotentReplay) {
+			return nil
+		}
+		if errors.Is(err, pgerrs.ErrIdempotencyKeyReused) {
+			return ErrIdempotencyKeyReused
+		}
 		if errors.Is(err, pgerrs.ErrNotFound) {
 			return ErrAccountNotFound
 		}
+		if statusErr := accountStatusError(err); statusErr != nil {
+			return statusErr
+		}
 		if errors.Is(err, pgerrs.ErrNotEnoughBalance) {
 			return ErrNotEnoughBalance
 		}
+		if limitErr := limitExceededError(err); limitErr != nil {
+			return limitErr
+		}
 		log.Errorf("%s/Withdraw error update account balance: %s", accountServicePrefixLog, err)
 		return ErrCannotUpdateBalance
 	}
-
-	if err := pushMessage(s.producer, dbmodel.OperationWithdraw, input); err != nil {
-		return err
-	}
 	return nil
 }
 
 func (s *accountService) Transfer(ctx context.Context, input TransferInput) error {
-	if err := s.account.Transfer(ctx, input.From, input.To, input.Amount); err != nil {
+	input.FromCurrency = currencyOrDefault(input.FromCurrency)
+	if input.ToCurrency == "" {
+		input.ToCurrency = input.FromCurrency
+	}
+	// обмен между своими валютами - нормальный перевод, а в той же валюте он ничего не меняет,
+	// но попал бы в журнал и в лимиты расходов
+	if input.From == input.To && input.FromCurrency == input.ToCurrency {
+		return ErrTransferToSelf
+	}
+	key := idempotencyKey(dbmodel.IdempotencyScopeTransfer, input.IdempotencyKey, input)
+	_, err := s.account.Transfer(ctx, input.From, input.To, input.Amount, input.FromCurrency, input.ToCurrency, key)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrIdempotentReplay) {
+			return nil
+		}
+		if errors.Is(err, pgerrs.ErrIdempotencyKeyReused) {
+			return ErrIdempotencyKeyReused
+		}
 		if errors.Is(err, pgerrs.ErrNotFound) {
 			return ErrAccountNotFound
 		}
+		if statusErr := accountStatusError(err); statusErr != nil {
+			return statusErr
+		}
 		if errors.Is(err, pgerrs.ErrNotEnoughBalance) {
 			return ErrNotEnoughBalance
 		}
+		if limitErr := limitExceededError(err); limitErr != nil {
+			return limitErr
+		}
+		if errors.Is(err, pgerrs.ErrRateNotFound) {
+			return ErrRateNotFound
+		}
 		log.Errorf("%s/Transfer error transfer: %s", accountServicePrefixLog, err)
 		return ErrCannotUpdateBalance
 	}
+	return nil
+}
+
+// SetStatus смена статуса аккаунта админом. Закрытый аккаунт больше не меняет статус
+func (s *accountService) SetStatus(ctx context.Context, input AccountStatusInput) error {
+	if err := s.account.SetStatus(ctx, input.UserId, input.Status, input.Reason); err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrAccountNotFound
+		}
+		if errors.Is(err, pgerrs.ErrAccountClosed) {
+			return ErrAccountClosed
+		}
+		if errors.Is(err, pgerrs.ErrAccountNotEmpty) {
+			return ErrAccountNotEmpty
+		}
+		log.Errorf("%s/SetStatus error set account status: %s", accountServicePrefixLog, err)
+		return err
+	}
+	return nil
+}
 
-	// очевидно, что для того кто отправил перевод уведомление не нужно
-	err := pushMessage(s.producer, dbmodel.OperationIncomingTransfer, message{
-		UserId: input.To,
-		Amount: input.Amount,
-	})
+func (s *accountService) GetStatus(ctx context.Context, userId int) (AccountStatusOutput, error) {
+	account, history, err := s.account.GetStatus(ctx, userId)
 	if err != nil {
-		return err
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return AccountStatusOutput{}, ErrAccountNotFound
+		}
+		log.Errorf("%s/GetStatus error get account status: %s", accountServicePrefixLog, err)
+		return AccountStatusOutput{}, err
+	}
+	result := AccountStatusOutput{
+		UserId:          account.UserId,
+		Status:          account.Status,
+		StatusChangedAt: account.StatusChangedAt,
+		History:         make([]AccountStatusChangeOutput, 0, len(history)),
+	}
+	for _, h := range history {
+		result.History = append(result.History, AccountStatusChangeOutput{
+			Status:    h.Status,
+			Reason:    h.Reason,
+			CreatedAt: h.CreatedAt,
+		})
+	}
+	return result, nil
+}
+
+// Ошибка статуса аккаунта, понятная клиенту. Для остальных ошибок возвращает nil
+func accountStatusError(err error) error {
+	switch {
+	case errors.Is(err, pgerrs.ErrAccountFrozen):
+		return ErrAccountFrozen
+	case errors.Is(err, pgerrs.ErrAccountClosed):
+		return ErrAccountClosed
 	}
 	return nil
 }
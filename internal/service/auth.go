@@ -2,6 +2,7 @@ package service
 
 import (
 	"crypto/rsa"
+	"crypto/subtle"
 	"errors"
 	"github.com/golang-jwt/jwt"
 	"os"
@@ -11,9 +12,10 @@ import (
 type authService struct {
 	privateKey *rsa.PrivateKey
 	publicKey  *rsa.PublicKey
+	adminKey   string
 }
 
-func newAuthService(privateKey, publicKey string) *authService {
+func newAuthService(privateKey, publicKey, adminKey string) *authService {
 	privateKeyData, err := os.ReadFile(privateKey)
 	if err != nil {
 		panic(err)
@@ -34,26 +36,61 @@ func newAuthService(privateKey, publicKey string) *authService {
 	return &authService{
 		privateKey: private,
 		publicKey:  public,
+		adminKey:   adminKey,
 	}
 }
 
-func (s *authService) ValidateToken(tokenString string) bool {
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+// Роли в токене. Клиентский токен выдается всем, админский - только по ключу администратора
+const (
+	RoleClient = "client"
+	RoleAdmin  = "admin"
+)
+
+type tokenClaims struct {
+	jwt.StandardClaims
+	Role string `json:"role"`
+}
+
+// ValidateToken проверяет подпись и срок токена и возвращает роль из него.
+// У токенов без роли (выданных до появления ролей) роль клиентская
+func (s *authService) ValidateToken(tokenString string) (string, bool) {
+	claims := &tokenClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, errors.New("incorrect sign method")
 		}
 		return s.publicKey, nil
 	})
-	if err != nil {
-		return false
+	if err != nil || !token.Valid {
+		return "", false
+	}
+	if claims.Role == "" {
+		return RoleClient, true
 	}
-	return token.Valid
+	return claims.Role, true
 }
 
+// CreateToken клиентский токен
 func (s *authService) CreateToken() (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &jwt.StandardClaims{
-		ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
-		IssuedAt:  time.Now().Unix(),
+	return s.createToken(RoleClient)
+}
+
+// CreateAdminToken админский токен. Выдается только при совпадении ключа администратора из конфига,
+// если ключ не задан, то админский токен получить нельзя
+func (s *authService) CreateAdminToken(adminKey string) (string, error) {
+	if s.adminKey == "" || subtle.ConstantTimeCompare([]byte(adminKey), []byte(s.adminKey)) != 1 {
+		return "", ErrInvalidAdminKey
+	}
+	return s.createToken(RoleAdmin)
+}
+
+func (s *authService) createToken(role string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &tokenClaims{
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+		Role: role,
 	})
 	signedToken, err := token.SignedString(s.privateKey)
 	if err != nil {
@@ -1,15 +1,93 @@
 package service
 
-import "errors"
+import (
+	"avito_intership/internal/model/dbmodel"
+	"avito_intership/internal/repo/pgerrs"
+	"avito_intership/pkg/money"
+	"errors"
+	"fmt"
+)
 
 var (
+	ErrInvalidAdminKey = errors.New("invalid admin key")
+
 	ErrAccountAlreadyExists = errors.New("account already exists")
 	ErrAccountCannotCreate  = errors.New("cannot create account")
 	ErrAccountNotFound      = errors.New("account not found")
+	ErrAccountFrozen        = errors.New("account is frozen")
+	ErrAccountClosed        = errors.New("account is closed")
+	ErrAccountNotEmpty      = errors.New("account has money or active reservations")
 
 	ErrNotEnoughBalance    = errors.New("not enough balance on account")
 	ErrCannotUpdateBalance = errors.New("cannot update account balance")
+	ErrTransferToSelf      = errors.New("transfer to the same account in the same currency")
+	ErrCreditLimitDebt     = errors.New("credit limit is less than current debt")
+	ErrBalanceAsOfFuture   = errors.New("balance as of time must not be in the future")
+	ErrStatementPeriod     = errors.New("statement period start must be before its end")
+	ErrInvalidCursor       = errors.New("invalid cursor or cursor issued for another sort")
+	ErrExportFormat        = errors.New("unknown export format")
+	ErrReportFormat        = errors.New("unknown report format")
+	ErrReportPeriod        = errors.New("report period start must be before its end")
 
 	ErrReservationCannotCreate = errors.New("cannot create reservation")
 	ErrReservationNotFound     = errors.New("reservation not found")
+	ErrReservationNotActive    = errors.New("reservation is already cancelled, recognized or expired")
+	ErrReservationExpiresAt    = errors.New("reservation expiration time must be in the future")
+	ErrRevenueExceedsAmount    = errors.New("revenue amount exceeds reserved amount")
+
+	ErrOrderNotFound  = errors.New("order has no reservations")
+	ErrOrderNotActive = errors.New("order has no active reservations")
+
+	ErrReservationNotRecognized = errors.New("reservation revenue is not recognized")
+	ErrRefundExceedsRevenue     = errors.New("refund amount exceeds recognized revenue")
+	ErrNothingToRefund          = errors.New("recognized revenue is already refunded")
+
+	ErrRateNotFound = errors.New("exchange rate not found")
+
+	ErrLimitNotFound = errors.New("spending limit not found")
+
+	ErrReportKind        = errors.New("unknown report kind")
+	ErrReportJobNotFound = errors.New("report job not found")
+	ErrReportJobNotDone  = errors.New("report job is not done")
+
+	ErrIdempotencyKeyReused = errors.New("idempotency key is already used with another request body")
 )
+
+// LimitExceededError операция превышает лимит расходов. Для лимитов по сумме заполнены поля *Amount,
+// для лимитов по количеству операций - *Count. Current - сколько уже потрачено в окне лимита до этой операции,
+// для лимита на одну операцию - сумма самой операции
+type LimitExceededError struct {
+	Limit         string        `json:"limit"`
+	Scope         string        `json:"scope"`
+	Currency      string        `json:"currency"`
+	MaxAmount     *money.Amount `json:"max_amount,omitempty" swaggertype:"number"`
+	CurrentAmount *money.Amount `json:"current_amount,omitempty" swaggertype:"number"`
+	MaxCount      *int          `json:"max_count,omitempty"`
+	CurrentCount  *int          `json:"current_count,omitempty"`
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s %s limit exceeded", e.Scope, e.Limit)
+}
+
+// Ошибка превышения лимита, понятная клиенту. Для остальных ошибок возвращает nil
+func limitExceededError(err error) error {
+	var limitErr *pgerrs.LimitExceededError
+	if !errors.As(err, &limitErr) {
+		return nil
+	}
+	result := &LimitExceededError{
+		Limit:    limitErr.Limit,
+		Scope:    limitErr.Scope,
+		Currency: limitErr.Currency,
+	}
+	switch limitErr.Limit {
+	case dbmodel.LimitDailyCount, dbmodel.LimitMonthlyCount:
+		maxCount, currentCount := int(limitErr.Max), int(limitErr.Current)
+		result.MaxCount, result.CurrentCount = &maxCount, &currentCount
+	default:
+		maxAmount, currentAmount := money.Amount(limitErr.Max), money.Amount(limitErr.Current)
+		result.MaxAmount, result.CurrentAmount = &maxAmount, &currentAmount
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"avito_intership/internal/repo"
+	"context"
+	log "github.com/sirupsen/logrus"
+)
+
+const ledgerPrefixLog = "/service/ledger"
+
+type ledgerService struct {
+	ledger repo.Ledger
+}
+
+func newLedgerService(ledger repo.Ledger) *ledgerService {
+	return &ledgerService{ledger: ledger}
+}
+
+// Check сверка журнала. Найденные расхождения дополнительно пишутся в лог, потому что это всегда баг
+func (s *ledgerService) Check(ctx context.Context) (LedgerCheckOutput, error) {
+	imbalances, err := s.ledger.Check(ctx)
+	if err != nil {
+		log.Errorf("%s/Check error check ledger: %s", ledgerPrefixLog, err)
+		return LedgerCheckOutput{}, err
+	}
+
+	result := LedgerCheckOutput{
+		Balanced:   len(imbalances) == 0,
+		Imbalances: make([]LedgerImbalance, 0, len(imbalances)),
+	}
+	for _, i := range imbalances {
+		log.Errorf("%s/Check ledger imbalance %s (%s, %s): expected %s, actual %s", ledgerPrefixLog, i.Kind, i.Subject, i.Currency, i.Expected, i.Actual)
+		result.Imbalances = append(result.Imbalances, LedgerImbalance{
+			Kind:     i.Kind,
+			Subject:  i.Subject,
+			Currency: i.Currency,
+			Expected: i.Expected,
+			Actual:   i.Actual,
+		})
+	}
+	return result, nil
+}
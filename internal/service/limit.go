@@ -0,0 +1,84 @@
+package service
+
+import (
+	"avito_intership/internal/model/dbmodel"
+	"avito_intership/internal/repo"
+	"avito_intership/internal/repo/pgerrs"
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const limitPrefixLog = "/service/limit"
+
+type limitService struct {
+	limit repo.Limit
+}
+
+func newLimitService(limit repo.Limit) *limitService {
+	return &limitService{limit: limit}
+}
+
+// SetLimit создание или замена лимитов расходов. Пустой UserId - глобальные лимиты для всех аккаунтов
+func (s *limitService) SetLimit(ctx context.Context, input LimitInput) (LimitOutput, error) {
+	limit, err := s.limit.SetLimit(ctx, dbmodel.SpendingLimit{
+		UserId:        input.UserId,
+		Currency:      currencyOrDefault(input.Currency),
+		PerOperation:  input.PerOperation,
+		DailyAmount:   input.DailyAmount,
+		MonthlyAmount: input.MonthlyAmount,
+		DailyCount:    input.DailyCount,
+		MonthlyCount:  input.MonthlyCount,
+	})
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return LimitOutput{}, ErrAccountNotFound
+		}
+		log.Errorf("%s/SetLimit error set spending limit: %s", limitPrefixLog, err)
+		return LimitOutput{}, err
+	}
+	return limitOutput(limit), nil
+}
+
+// GetLimits лимиты аккаунта вместе с глобальными. Если UserId пустой, то только глобальные
+func (s *limitService) GetLimits(ctx context.Context, userId *int) ([]LimitOutput, error) {
+	limits, err := s.limit.GetLimits(ctx, userId)
+	if err != nil {
+		log.Errorf("%s/GetLimits error get spending limits: %s", limitPrefixLog, err)
+		return nil, err
+	}
+	result := make([]LimitOutput, 0, len(limits))
+	for _, limit := range limits {
+		result = append(result, limitOutput(limit))
+	}
+	return result, nil
+}
+
+func (s *limitService) DeleteLimit(ctx context.Context, userId *int, currency string) error {
+	if err := s.limit.DeleteLimit(ctx, userId, currencyOrDefault(currency)); err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ErrLimitNotFound
+		}
+		log.Errorf("%s/DeleteLimit error delete spending limit: %s", limitPrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+func limitOutput(limit dbmodel.SpendingLimit) LimitOutput {
+	scope := dbmodel.LimitScopeGlobal
+	if limit.UserId != nil {
+		scope = dbmodel.LimitScopeAccount
+	}
+	return LimitOutput{
+		UserId:        limit.UserId,
+		Scope:         scope,
+		Currency:      limit.Currency,
+		PerOperation:  limit.PerOperation,
+		DailyAmount:   limit.DailyAmount,
+		MonthlyAmount: limit.MonthlyAmount,
+		DailyCount:    limit.DailyCount,
+		MonthlyCount:  limit.MonthlyCount,
+		UpdatedAt:     limit.UpdatedAt,
+	}
+}
@@ -1,14 +1,22 @@
 package service
 
 import (
+	"avito_intership/internal/model/dbmodel"
 	"avito_intership/internal/repo"
 	"avito_intership/internal/repo/pgerrs"
+	"avito_intership/pkg/xlsx"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"io"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const operationPrefixLog = "/service/operation"
@@ -17,6 +25,19 @@ const (
 	defaultLimit = 20
 )
 
+// Форматы выгрузки истории
+const (
+	ExportFormatCSV    = "csv"
+	ExportFormatNDJSON = "ndjson"
+)
+
+// Форматы отчетов. json отдается как есть, файлом собираются csv и xlsx
+const (
+	ReportFormatCSV  = "csv"
+	ReportFormatJSON = "json"
+	ReportFormatXLSX = "xlsx"
+)
+
 type operationService struct {
 	operation repo.Operation
 }
@@ -25,63 +46,462 @@ func newOperationService(operation repo.Operation) *operationService {
 	return &operationService{operation: operation}
 }
 
-func (s *operationService) GetHistory(ctx context.Context, input HistoryInput) ([]HistoryOutput, error) {
-	if input.Limit <= 0 || input.Limit > defaultLimit {
-		input.Limit = defaultLimit
+// Курсор страницы истории: сортировка, для которой он выдан, и значения ее полей у последней строки страницы.
+// Клиенту отдается как непрозрачная base64 строка
+type historyCursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+func (c historyCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeHistoryCursor(s string) (historyCursor, error) {
+	var c historyCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err = json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Порядок истории для сортировки из запроса и ее ключ для курсора. Если id нет в сортировке, то он добавляется
+// в конец в направлении последнего поля: порядок строк однозначный, и курсор не пропускает и не повторяет строки
+func historyOrder(sort []HistorySort) (string, []dbmodel.HistoryOrder) {
+	if len(sort) == 0 {
+		sort = []HistorySort{{Field: "created_at", Desc: true}}
+	}
+	order := make([]dbmodel.HistoryOrder, 0, len(sort)+1)
+	keys := make([]string, 0, len(sort))
+	for _, s := range sort {
+		order = append(order, dbmodel.HistoryOrder{Field: s.Field, Desc: s.Desc})
+		direction := "asc"
+		if s.Desc {
+			direction = "desc"
+		}
+		keys = append(keys, s.Field+":"+direction)
+		if s.Field == "id" { // id уникален, поля после него ничего не меняют
+			return strings.Join(keys, ","), order
+		}
 	}
-	switch input.Sort { // TODO более умную сортировку
+	order = append(order, dbmodel.HistoryOrder{Field: "id", Desc: order[len(order)-1].Desc})
+	return strings.Join(keys, ","), order
+}
+
+// Значение поля сортировки операции в том виде, в котором оно сравнивается в бд
+func historyOrderValue(o dbmodel.Operation, field string) string {
+	switch field {
+	case "created_at":
+		return o.CreatedAt.Format(time.RFC3339Nano)
 	case "amount":
-		input.Sort = "amount DESC"
+		return strconv.FormatInt(int64(o.Amount), 10)
 	case "type":
-		input.Sort = "type DESC"
+		return o.Type
 	default:
-		input.Sort = "created_at DESC"
+		return strconv.Itoa(o.Id)
+	}
+}
+
+func (s *operationService) GetHistory(ctx context.Context, input HistoryInput) (HistoryPageOutput, error) {
+	if input.Limit <= 0 || input.Limit > defaultLimit {
+		input.Limit = defaultLimit
+	}
+	sort, order := historyOrder(input.Sort)
+	query := historyQuery(input, order)
+	query.Offset = input.Offset
+	query.Limit = input.Limit + 1 // лишняя строка показывает, что есть следующая страница
+	if input.Cursor != "" {
+		cursor, err := decodeHistoryCursor(input.Cursor)
+		if err != nil {
+			return HistoryPageOutput{}, err
+		}
+		if cursor.Sort != sort || len(cursor.Values) != len(order) {
+			return HistoryPageOutput{}, ErrInvalidCursor
+		}
+		query.After = cursor.Values
+		query.Offset = 0
 	}
-	history, err := s.operation.GetHistory(ctx, input.UserId, input.Sort, input.Offset, input.Limit)
+
+	history, err := s.operation.GetHistory(ctx, query)
 	if err != nil {
 		if errors.Is(err, pgerrs.ErrNotFound) {
-			return nil, ErrAccountNotFound
+			return HistoryPageOutput{}, ErrAccountNotFound
+		}
+		if errors.Is(err, pgerrs.ErrInvalidCursor) {
+			return HistoryPageOutput{}, ErrInvalidCursor
 		}
 		log.Errorf("%s/GetHistory error get account operation history: %s", operationPrefixLog, err)
-		return nil, err
+		return HistoryPageOutput{}, err
+	}
+
+	result := HistoryPageOutput{Operations: make([]HistoryOutput, 0, len(history))}
+	if len(history) > input.Limit {
+		history = history[:input.Limit]
+		last := history[len(history)-1]
+		cursor := historyCursor{Sort: sort, Values: make([]string, 0, len(order))}
+		for _, o := range order {
+			cursor.Values = append(cursor.Values, historyOrderValue(last, o.Field))
+		}
+		result.NextCursor = cursor.encode()
 	}
-	var result []HistoryOutput
 	for _, o := range history {
-		result = append(result, HistoryOutput{
+		result.Operations = append(result.Operations, historyOutput(o))
+	}
+	return result, nil
+}
+
+// ExportHistory выгружает всю историю по фильтру в w в формате csv или ndjson (по объекту HistoryOutput на строку).
+// Строки пишутся по мере чтения из бд, поэтому при ошибке в w может остаться начало выгрузки
+func (s *operationService) ExportHistory(ctx context.Context, input HistoryInput, format string, w io.Writer) error {
+	_, order := historyOrder(input.Sort)
+	query := historyQuery(input, order)
+
+	var write func(dbmodel.Operation) error
+	var flush func() error
+	switch format {
+	case ExportFormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Comma = ';' // как и в выписке
+		err := cw.Write([]string{"operation_id", "created_at", "type", "product_id", "order_id", "amount", "currency", "rate", "overdraft"})
+		if err != nil {
+			return err
+		}
+		write = func(o dbmodel.Operation) error {
+			rate := ""
+			if o.Rate != nil {
+				rate = o.Rate.String()
+			}
+			return cw.Write([]string{
+				strconv.Itoa(o.Id),
+				o.CreatedAt.Format(time.RFC3339),
+				o.Type,
+				optionalId(o.ProductId),
+				optionalId(o.OrderId),
+				o.Amount.String(),
+				o.Currency,
+				rate,
+				strconv.FormatBool(o.Overdraft),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case ExportFormatNDJSON:
+		encoder := json.NewEncoder(w)
+		write = func(o dbmodel.Operation) error {
+			return encoder.Encode(historyOutput(o))
+		}
+		flush = func() error { return nil }
+	default:
+		return ErrExportFormat
+	}
+
+	if err := s.operation.StreamHistory(ctx, query, write); err != nil {
+		log.Errorf("%s/ExportHistory error export history: %s", operationPrefixLog, err)
+		return err
+	}
+	if err := flush(); err != nil {
+		log.Errorf("%s/ExportHistory error flush export: %s", operationPrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+// Фильтр истории из запроса, без страницы
+func historyQuery(input HistoryInput, order []dbmodel.HistoryOrder) dbmodel.HistoryQuery {
+	return dbmodel.HistoryQuery{
+		UserId:      input.UserId,
+		Types:       input.Types,
+		CreatedFrom: input.CreatedFrom,
+		CreatedTo:   input.CreatedTo,
+		AmountFrom:  input.AmountFrom,
+		AmountTo:    input.AmountTo,
+		ProductId:   input.ProductId,
+		OrderId:     input.OrderId,
+		Order:       order,
+	}
+}
+
+func historyOutput(o dbmodel.Operation) HistoryOutput {
+	return HistoryOutput{
+		OperationId: o.Id,
+		ProductId:   o.ProductId,
+		OrderId:     o.OrderId,
+		Amount:      o.Amount,
+		Currency:    o.Currency,
+		Rate:        o.Rate,
+		Type:        o.Type,
+		Overdraft:   o.Overdraft,
+		CreatedAt:   o.CreatedAt,
+	}
+}
+
+// GetReport отчет по выручке услуг за месяц, по возрастанию id услуги, внутри услуги - по валюте
+func (s *operationService) GetReport(ctx context.Context, year, month int) (ReportOutput, error) {
+	group, err := s.operation.GroupProductRevenue(ctx, year, month)
+	if err != nil {
+		log.Errorf("%s/GetReport error group product revenue: %s", operationPrefixLog, err)
+		return ReportOutput{}, err
+	}
+
+	result := ReportOutput{Year: year, Month: month, Products: make([]ReportLine, 0, len(group))}
+	for _, revenue := range group {
+		result.Products = append(result.Products, ReportLine{
+			ProductId:  revenue.ProductId,
+			Currency:   revenue.Currency,
+			Revenue:    revenue.Revenue,
+			Operations: revenue.Operations,
+			Refunds:    revenue.Refunds,
+			NetRevenue: revenue.NetRevenue(),
+		})
+	}
+	return result, nil
+}
+
+// CreateReport отчет за месяц файлом в формате csv или xlsx: строка заголовка и по строке на услугу и валюту
+func (s *operationService) CreateReport(ctx context.Context, year, month int, format string) ([]byte, error) {
+	report, err := s.GetReport(ctx, year, month)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := [][]xlsx.Cell{{
+		xlsx.String("product_id"),
+		xlsx.String("currency"),
+		xlsx.String("revenue"),
+		xlsx.String("operations"),
+		xlsx.String("refunds"),
+		xlsx.String("net_revenue"),
+	}}
+	for _, line := range report.Products {
+		rows = append(rows, []xlsx.Cell{
+			xlsx.Int(line.ProductId),
+			xlsx.String(line.Currency),
+			xlsx.Number(line.Revenue.String()),
+			xlsx.Int(line.Operations),
+			xlsx.Number(line.Refunds.String()),
+			xlsx.Number(line.NetRevenue.String()),
+		})
+	}
+	return renderTable(format, fmt.Sprintf("%d-%02d", year, month), rows)
+}
+
+// GetRevenueReport отчет по выручке за [From, To) с группировкой по периодам (по умолчанию по месяцам)
+// и разбивкой по услугам и пользователям
+func (s *operationService) GetRevenueReport(ctx context.Context, input RevenueReportInput) (RevenueReportOutput, error) {
+	if !input.From.Before(input.To) {
+		return RevenueReportOutput{}, ErrReportPeriod
+	}
+	if input.Period == "" {
+		input.Period = dbmodel.ReportPeriodMonth
+	}
+	groups, err := s.operation.GroupRevenue(ctx, dbmodel.RevenueQuery{
+		From:      input.From,
+		To:        input.To,
+		Period:    input.Period,
+		ByProduct: input.ByProduct,
+		ByUser:    input.ByUser,
+	})
+	if err != nil {
+		log.Errorf("%s/GetRevenueReport error group revenue: %s", operationPrefixLog, err)
+		return RevenueReportOutput{}, err
+	}
+
+	result := RevenueReportOutput{
+		From:   input.From,
+		To:     input.To,
+		Period: input.Period,
+		Lines:  make([]RevenueReportLine, 0, len(groups)),
+	}
+	for _, group := range groups {
+		result.Lines = append(result.Lines, RevenueReportLine{
+			PeriodStart: group.Period.Format(time.DateOnly),
+			ProductId:   group.ProductId,
+			UserId:      group.UserId,
+			Currency:    group.Currency,
+			Revenue:     group.Revenue,
+			Operations:  group.Operations,
+			Refunds:     group.Refunds,
+			NetRevenue:  group.NetRevenue(),
+		})
+	}
+	return result, nil
+}
+
+// CreateRevenueReport отчет по выручке файлом в формате csv или xlsx. Колонки услуги и пользователя есть,
+// только если была такая разбивка
+func (s *operationService) CreateRevenueReport(ctx context.Context, input RevenueReportInput, format string) ([]byte, error) {
+	report, err := s.GetRevenueReport(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []xlsx.Cell{xlsx.String("period_start")}
+	if input.ByProduct {
+		header = append(header, xlsx.String("product_id"))
+	}
+	if input.ByUser {
+		header = append(header, xlsx.String("user_id"))
+	}
+	header = append(header,
+		xlsx.String("currency"),
+		xlsx.String("revenue"),
+		xlsx.String("operations"),
+		xlsx.String("refunds"),
+		xlsx.String("net_revenue"),
+	)
+
+	rows := [][]xlsx.Cell{header}
+	for _, line := range report.Lines {
+		row := []xlsx.Cell{xlsx.String(line.PeriodStart)}
+		if input.ByProduct {
+			row = append(row, xlsx.Number(optionalId(line.ProductId)))
+		}
+		if input.ByUser {
+			row = append(row, xlsx.Number(optionalId(line.UserId)))
+		}
+		row = append(row,
+			xlsx.String(line.Currency),
+			xlsx.Number(line.Revenue.String()),
+			xlsx.Int(line.Operations),
+			xlsx.Number(line.Refunds.String()),
+			xlsx.Number(line.NetRevenue.String()),
+		)
+		rows = append(rows, row)
+	}
+	return renderTable(format, "revenue", rows)
+}
+
+// Таблица отчета файлом: csv через ";", как и остальные выгрузки, или xlsx с одним листом sheet
+func renderTable(format, sheet string, rows [][]xlsx.Cell) ([]byte, error) {
+	result := &bytes.Buffer{}
+	switch format {
+	case ReportFormatCSV:
+		w := csv.NewWriter(result)
+		w.Comma = ';'
+		for _, row := range rows {
+			line := make([]string, 0, len(row))
+			for _, cell := range row {
+				line = append(line, cell.Value)
+			}
+			// отчеты идут в налоговую, поэтому пропуск строки хуже, чем ошибка
+			if err := w.Write(line); err != nil {
+				log.Errorf("%s/renderTable error write line: %s", operationPrefixLog, err)
+				return nil, err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			log.Errorf("%s/renderTable error write buffer: %s", operationPrefixLog, err)
+			return nil, err
+		}
+	case ReportFormatXLSX:
+		if err := xlsx.Write(result, sheet, rows); err != nil {
+			log.Errorf("%s/renderTable error write xlsx: %s", operationPrefixLog, err)
+			return nil, err
+		}
+	default:
+		return nil, ErrReportFormat
+	}
+	return result.Bytes(), nil
+}
+
+// GetStatement выписка за период: входящий остаток, операции с балансом после каждой и исходящий остаток
+func (s *operationService) GetStatement(ctx context.Context, input StatementInput) (StatementOutput, error) {
+	if !input.From.Before(input.To) {
+		return StatementOutput{}, ErrStatementPeriod
+	}
+	input.Currency = currencyOrDefault(input.Currency)
+	statement, err := s.operation.GetStatement(ctx, input.UserId, input.Currency, input.From, input.To)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return StatementOutput{}, ErrAccountNotFound
+		}
+		log.Errorf("%s/GetStatement error get statement: %s", operationPrefixLog, err)
+		return StatementOutput{}, err
+	}
+
+	result := StatementOutput{
+		UserId:         input.UserId,
+		Currency:       input.Currency,
+		From:           input.From,
+		To:             input.To,
+		OpeningBalance: statement.OpeningBalance,
+		Operations:     make([]StatementLine, 0, len(statement.Operations)),
+	}
+	balance := statement.OpeningBalance
+	for _, o := range statement.Operations {
+		change := o.BalanceChange()
+		balance += change
+		result.Operations = append(result.Operations, StatementLine{
 			OperationId: o.Id,
+			Type:        o.Type,
 			ProductId:   o.ProductId,
 			OrderId:     o.OrderId,
 			Amount:      o.Amount,
-			Type:        o.Type,
+			Change:      change,
+			Balance:     balance,
+			Rate:        o.Rate,
+			Overdraft:   o.Overdraft,
 			CreatedAt:   o.CreatedAt,
 		})
 	}
+	result.ClosingBalance = balance
 	return result, nil
 }
 
-func (s *operationService) CreateReport(ctx context.Context, year, month int) ([]byte, error) {
-	const reportLine = "%d;%f"
-	group, err := s.operation.GroupProductRevenue(ctx, year, month)
+// CreateStatementCSV выписка в csv: строка входящего остатка, операции и строка исходящего остатка
+func (s *operationService) CreateStatementCSV(ctx context.Context, input StatementInput) ([]byte, error) {
+	statement, err := s.GetStatement(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
 	result := &bytes.Buffer{}
 	w := csv.NewWriter(result)
+	w.Comma = ';' // как и в месячном отчете
 
-	for productId, amount := range group {
-		// Не понятно, можно ли продолжать или стоит сразу ошибку и выход. Решил делать возврат сразу после ошибки,
-		// потому что тут собирается отчет для налоговой, следовательно, ошибки или пропуски тут недопустимы
-		if err = w.Write([]string{fmt.Sprintf(reportLine, productId, amount)}); err != nil {
-			log.Errorf("%s/CreateReport error write line: %s", operationPrefixLog, err)
-			return nil, err
+	lines := [][]string{
+		{"operation_id", "created_at", "type", "product_id", "order_id", "amount", "change", "balance", "currency", "rate", "overdraft"},
+		{"", statement.From.Format(time.RFC3339), "opening-balance", "", "", "", "", statement.OpeningBalance.String(), statement.Currency, "", ""},
+	}
+	for _, line := range statement.Operations {
+		rate := ""
+		if line.Rate != nil {
+			rate = line.Rate.String()
 		}
+		lines = append(lines, []string{
+			strconv.Itoa(line.OperationId),
+			line.CreatedAt.Format(time.RFC3339),
+			line.Type,
+			optionalId(line.ProductId),
+			optionalId(line.OrderId),
+			line.Amount.String(),
+			line.Change.String(),
+			line.Balance.String(),
+			statement.Currency,
+			rate,
+			strconv.FormatBool(line.Overdraft),
+		})
 	}
-	w.Flush()
-	if err = w.Error(); err != nil {
-		log.Errorf("%s/CreateReport error write buffer: %s", operationPrefixLog, err)
+	lines = append(lines, []string{"", statement.To.Format(time.RFC3339), "closing-balance", "", "", "", "", statement.ClosingBalance.String(), statement.Currency, "", ""})
+
+	if err = w.WriteAll(lines); err != nil {
+		log.Errorf("%s/CreateStatementCSV error write statement: %s", operationPrefixLog, err)
 		return nil, err
 	}
-
 	return result.Bytes(), nil
 }
+
+func optionalId(id *int) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.Itoa(*id)
+}
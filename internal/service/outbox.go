@@ -0,0 +1,66 @@
+package service
+
+import (
+	"avito_intership/internal/model/dbmodel"
+	"avito_intership/internal/repo"
+	"avito_intership/pkg/broker"
+	"context"
+	"github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	outboxPrefixLog = "/service/outbox"
+
+	defaultOutboxBatchSize = 100
+)
+
+type outboxService struct {
+	outbox   repo.Outbox
+	producer broker.Producer
+}
+
+func newOutboxService(outbox repo.Outbox, producer broker.Producer) *outboxService {
+	return &outboxService{
+		outbox:   outbox,
+		producer: producer,
+	}
+}
+
+// Relay отправка накопившихся в outbox событий в брокер. Возвращает количество отправленных
+func (s *outboxService) Relay(ctx context.Context) (int, error) {
+	sent, err := s.outbox.Relay(ctx, defaultOutboxBatchSize, s.publish)
+	if err != nil {
+		log.Errorf("%s/Relay error relay events: %s", outboxPrefixLog, err)
+		return 0, err
+	}
+	return sent, nil
+}
+
+// Cleanup удаление уже отправленных событий старше retention
+func (s *outboxService) Cleanup(ctx context.Context, retention time.Duration) (int64, error) {
+	deleted, err := s.outbox.DeleteSent(ctx, retention)
+	if err != nil {
+		log.Errorf("%s/Cleanup error delete sent events: %s", outboxPrefixLog, err)
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// Функция, которая пушит сообщения в брокер.
+// Представим, что у нас есть микросервис нотификаций,
+// который отправляет сообщение пользователю о новой операции на аккаунте.
+// Тк микросервис вымышленный, то выбрал условный формат сообщения userId + amount.
+// Ключ для consumer`а - тип операции
+func (s *outboxService) publish(ctx context.Context, m dbmodel.OutboxMessage) error {
+	err := s.producer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(m.Key),
+		Value: m.Payload,
+	})
+	if err != nil {
+		log.Errorf("%s/publish error push message %d to broker: %s", outboxPrefixLog, m.Id, err)
+		return err
+	}
+	return nil
+}
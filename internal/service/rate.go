@@ -0,0 +1,44 @@
+package service
+
+import (
+	"avito_intership/internal/repo"
+	"avito_intership/internal/repo/pgerrs"
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const ratePrefixLog = "/service/rate"
+
+type rateService struct {
+	rate repo.Rate
+}
+
+func newRateService(rate repo.Rate) *rateService {
+	return &rateService{rate: rate}
+}
+
+func (s *rateService) SetRate(ctx context.Context, input RateInput) error {
+	if err := s.rate.SetRate(ctx, input.Base, input.Quote, input.Rate); err != nil {
+		log.Errorf("%s/SetRate error set exchange rate: %s", ratePrefixLog, err)
+		return err
+	}
+	return nil
+}
+
+func (s *rateService) GetRate(ctx context.Context, base, quote string) (RateOutput, error) {
+	rate, err := s.rate.GetRate(ctx, base, quote)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrRateNotFound) {
+			return RateOutput{}, ErrRateNotFound
+		}
+		log.Errorf("%s/GetRate error get exchange rate: %s", ratePrefixLog, err)
+		return RateOutput{}, err
+	}
+	return RateOutput{
+		Base:      rate.Base,
+		Quote:     rate.Quote,
+		Rate:      rate.Rate,
+		UpdatedAt: rate.UpdatedAt,
+	}, nil
+}
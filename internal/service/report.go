@@ -0,0 +1,249 @@
+package service
+
+import (
+	"avito_intership/internal/model/dbmodel"
+	"avito_intership/internal/repo"
+	"avito_intership/internal/repo/pgerrs"
+	"avito_intership/pkg/filestore"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	reportPrefixLog = "/service/report"
+
+	// сколько раз задача берется заново, если реплика упала посреди генерации
+	maxReportJobAttempts = 3
+)
+
+// Параметры отчетов в задаче. Из них же считается хэш для поиска одинаковых запросов
+type (
+	monthlyReportParams struct {
+		Year  int `json:"year"`
+		Month int `json:"month"`
+	}
+	revenueReportParams struct {
+		From      time.Time `json:"from"`
+		To        time.Time `json:"to"`
+		Period    string    `json:"period"`
+		ByProduct bool      `json:"by_product"`
+		ByUser    bool      `json:"by_user"`
+	}
+)
+
+type reportService struct {
+	report     repo.Report
+	operation  Operation
+	store      filestore.Store
+	jobTimeout time.Duration
+}
+
+func newReportService(report repo.Report, operation Operation, store filestore.Store, jobTimeout time.Duration) *reportService {
+	return &reportService{
+		report:     report,
+		operation:  operation,
+		store:      store,
+		jobTimeout: jobTimeout,
+	}
+}
+
+// CreateJob ставит отчет в очередь на генерацию. Если такой же отчет уже в очереди или уже готов и не устареет,
+// то возвращается существующая задача и false
+func (s *reportService) CreateJob(ctx context.Context, input ReportJobInput) (ReportJobOutput, bool, error) {
+	if input.Format == "" {
+		input.Format = ReportFormatCSV
+	}
+	switch input.Format {
+	case ReportFormatCSV, ReportFormatJSON, ReportFormatXLSX:
+	default:
+		return ReportJobOutput{}, false, ErrReportFormat
+	}
+
+	var (
+		params    any
+		periodEnd time.Time
+	)
+	switch input.Kind {
+	case dbmodel.ReportKindMonthly:
+		if input.Month < 1 || input.Month > 12 {
+			return ReportJobOutput{}, false, ErrReportPeriod
+		}
+		params = monthlyReportParams{Year: input.Year, Month: input.Month}
+		periodEnd = time.Date(input.Year, time.Month(input.Month)+1, 1, 0, 0, 0, 0, time.UTC)
+	case dbmodel.ReportKindRevenue:
+		revenue := input.Revenue
+		if !revenue.From.Before(revenue.To) {
+			return ReportJobOutput{}, false, ErrReportPeriod
+		}
+		if revenue.Period == "" {
+			revenue.Period = dbmodel.ReportPeriodMonth
+		}
+		params = revenueReportParams{
+			From:      revenue.From,
+			To:        revenue.To,
+			Period:    revenue.Period,
+			ByProduct: revenue.ByProduct,
+			ByUser:    revenue.ByUser,
+		}
+		periodEnd = revenue.To
+	default:
+		return ReportJobOutput{}, false, ErrReportKind
+	}
+
+	data, _ := json.Marshal(params)
+	hash := sha256.Sum256([]byte(input.Kind + ":" + input.Format + ":" + string(data)))
+
+	job, created, err := s.report.CreateJob(ctx, dbmodel.ReportJob{
+		Kind:       input.Kind,
+		Format:     input.Format,
+		Params:     data,
+		ParamsHash: hex.EncodeToString(hash[:]),
+		PeriodEnd:  periodEnd,
+	})
+	if err != nil {
+		log.Errorf("%s/CreateJob error create report job: %s", reportPrefixLog, err)
+		return ReportJobOutput{}, false, err
+	}
+	return reportJobOutput(job), created, nil
+}
+
+func (s *reportService) GetJob(ctx context.Context, id int) (ReportJobOutput, error) {
+	job, err := s.report.GetJob(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ReportJobOutput{}, ErrReportJobNotFound
+		}
+		log.Errorf("%s/GetJob error get report job: %s", reportPrefixLog, err)
+		return ReportJobOutput{}, err
+	}
+	return reportJobOutput(job), nil
+}
+
+// OpenFile открывает готовый файл отчета. Закрыть файл должен вызывающий
+func (s *reportService) OpenFile(ctx context.Context, id int) (ReportFileOutput, error) {
+	job, err := s.report.GetJob(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ReportFileOutput{}, ErrReportJobNotFound
+		}
+		log.Errorf("%s/OpenFile error get report job: %s", reportPrefixLog, err)
+		return ReportFileOutput{}, err
+	}
+	if job.Status != dbmodel.ReportJobDone || job.FileName == nil {
+		return ReportFileOutput{}, ErrReportJobNotDone
+	}
+
+	file, err := s.store.Open(*job.FileName)
+	if err != nil {
+		log.Errorf("%s/OpenFile error open report file: %s", reportPrefixLog, err)
+		return ReportFileOutput{}, err
+	}
+	return ReportFileOutput{Name: *job.FileName, Format: job.Format, File: file}, nil
+}
+
+// ProcessJobs генерирует отчеты из очереди, пока она не опустеет. Возвращает количество обработанных задач.
+// Ошибка генерации отчета сохраняется в задаче, наружу возвращаются только ошибки работы с очередью
+func (s *reportService) ProcessJobs(ctx context.Context) (int, error) {
+	processed := 0
+	for ctx.Err() == nil {
+		job, err := s.report.ClaimJob(ctx, s.jobTimeout, maxReportJobAttempts)
+		if err != nil {
+			if errors.Is(err, pgerrs.ErrNotFound) {
+				return processed, nil
+			}
+			log.Errorf("%s/ProcessJobs error claim report job: %s", reportPrefixLog, err)
+			return processed, err
+		}
+		s.process(ctx, job)
+		processed++
+	}
+	return processed, nil
+}
+
+func (s *reportService) process(ctx context.Context, job dbmodel.ReportJob) {
+	jobCtx, cancel := context.WithTimeout(ctx, s.jobTimeout)
+	defer cancel()
+
+	data, err := s.generate(jobCtx, job)
+	if err == nil {
+		// у каждой попытки свой файл: устаревшая попытка не перезапишет файл той, что взяла задачу заново
+		name := fmt.Sprintf("report_%d_%d.%s", job.Id, job.Attempts, job.Format)
+		if err = s.store.Save(name, data); err == nil {
+			if err = s.report.FinishJob(ctx, job.Id, job.Attempts, name); err != nil {
+				log.Errorf("%s/process error finish report job %d attempt %d: %s", reportPrefixLog, job.Id, job.Attempts, err)
+			}
+			return
+		}
+	}
+	// приложение останавливается: задача останется running, и после jobTimeout ее возьмет другая реплика
+	if ctx.Err() != nil {
+		return
+	}
+
+	log.Errorf("%s/process error generate report job %d: %s", reportPrefixLog, job.Id, err)
+	// клиенту не отдаются внутренние ошибки, подробности только в логе
+	if err = s.report.FailJob(ctx, job.Id, job.Attempts, "report generation failed"); err != nil {
+		log.Errorf("%s/process error fail report job %d attempt %d: %s", reportPrefixLog, job.Id, job.Attempts, err)
+	}
+}
+
+// Отчет из задачи в формате задачи. json сериализуется так же, как в синхронном ответе
+func (s *reportService) generate(ctx context.Context, job dbmodel.ReportJob) ([]byte, error) {
+	switch job.Kind {
+	case dbmodel.ReportKindMonthly:
+		var params monthlyReportParams
+		if err := json.Unmarshal(job.Params, &params); err != nil {
+			return nil, err
+		}
+		if job.Format == ReportFormatJSON {
+			report, err := s.operation.GetReport(ctx, params.Year, params.Month)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(report)
+		}
+		return s.operation.CreateReport(ctx, params.Year, params.Month, job.Format)
+	case dbmodel.ReportKindRevenue:
+		var params revenueReportParams
+		if err := json.Unmarshal(job.Params, &params); err != nil {
+			return nil, err
+		}
+		input := RevenueReportInput{
+			From:      params.From,
+			To:        params.To,
+			Period:    params.Period,
+			ByProduct: params.ByProduct,
+			ByUser:    params.ByUser,
+		}
+		if job.Format == ReportFormatJSON {
+			report, err := s.operation.GetRevenueReport(ctx, input)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(report)
+		}
+		return s.operation.CreateRevenueReport(ctx, input, job.Format)
+	default:
+		return nil, fmt.Errorf("unknown report kind %q", job.Kind)
+	}
+}
+
+func reportJobOutput(job dbmodel.ReportJob) ReportJobOutput {
+	return ReportJobOutput{
+		JobId:      job.Id,
+		Kind:       job.Kind,
+		Format:     job.Format,
+		Params:     job.Params,
+		Status:     job.Status,
+		Error:      job.Error,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+	}
+}
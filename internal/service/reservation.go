@@ -4,92 +4,269 @@ import (
 	"avito_intership/internal/model/dbmodel"
 	"avito_intership/internal/repo"
 	"avito_intership/internal/repo/pgerrs"
-	"avito_intership/pkg/broker"
 	"context"
 	"errors"
 	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 const (
 	reservationPrefixLog = "/service/reservation"
+
+	// сколько истекших резервирований отменяется за один проход воркера
+	expireBatchSize = 100
 )
 
 type reservationService struct {
 	reservation repo.Reservation
-	producer    broker.Producer
+	ttl         time.Duration
 }
 
-func newReservationService(reservation repo.Reservation, producer broker.Producer) *reservationService {
+func newReservationService(reservation repo.Reservation, ttl time.Duration) *reservationService {
 	return &reservationService{
 		reservation: reservation,
-		producer:    producer,
+		ttl:         ttl,
 	}
 }
 
 func (s *reservationService) CreateReservation(ctx context.Context, input ReservationInput) (int, error) {
+	input.Currency = currencyOrDefault(input.Currency)
+	// ключ считается до подстановки срока по умолчанию, иначе повтор запроса получил бы другой хэш
+	key := idempotencyKey(dbmodel.IdempotencyScopeReservation, input.IdempotencyKey, input)
+	if input.ExpiresAt == nil && s.ttl > 0 {
+		expiresAt := time.Now().Add(s.ttl)
+		input.ExpiresAt = &expiresAt
+	}
 	reservationId, err := s.reservation.CreateReservation(ctx, dbmodel.Reservation{
 		UserId:    input.UserId,
 		ProductId: input.ProductId,
 		OrderId:   input.OrderId,
 		Amount:    input.Amount,
-	})
+		Currency:  input.Currency,
+		ExpiresAt: input.ExpiresAt,
+	}, key)
 	if err != nil {
+		if errors.Is(err, pgerrs.ErrIdempotentReplay) { // повтор запроса: возвращаем id уже созданного резервирования
+			return reservationId, nil
+		}
+		if errors.Is(err, pgerrs.ErrIdempotencyKeyReused) {
+			return 0, ErrIdempotencyKeyReused
+		}
+		if errors.Is(err, pgerrs.ErrReservationExpiresAt) {
+			return 0, ErrReservationExpiresAt
+		}
 		if errors.Is(err, pgerrs.ErrNotFound) {
 			return 0, ErrAccountNotFound
 		}
+		if statusErr := accountStatusError(err); statusErr != nil {
+			return 0, statusErr
+		}
 		if errors.Is(err, pgerrs.ErrNotEnoughBalance) {
 			return 0, ErrNotEnoughBalance
 		}
+		if limitErr := limitExceededError(err); limitErr != nil {
+			return 0, limitErr
+		}
 		log.Errorf("%s/CreateReservation error create reservation: %s", reservationPrefixLog, err)
 		return 0, ErrReservationCannotCreate
 	}
 
-	err = pushMessage(s.producer, dbmodel.OperationReservation, message{
-		UserId: input.UserId,
-		Amount: input.Amount,
-	})
-	if err != nil {
-		return 0, ErrReservationCannotCreate
-	}
 	return reservationId, nil
 }
 
 func (s *reservationService) CancelReservation(ctx context.Context, reservationId int) error {
-	userId, amount, err := s.reservation.DeleteReservation(ctx, reservationId)
-	if err != nil {
+	if _, err := s.reservation.CancelReservation(ctx, reservationId); err != nil {
 		if errors.Is(err, pgerrs.ErrNotFound) {
 			return ErrReservationNotFound
 		}
-		log.Errorf("%s/CancelReservation error delete reservation: %s", reservationPrefixLog, err)
+		if errors.Is(err, pgerrs.ErrReservationNotActive) {
+			return ErrReservationNotActive
+		}
+		log.Errorf("%s/CancelReservation error cancel reservation: %s", reservationPrefixLog, err)
 		return err
 	}
 
-	err = pushMessage(s.producer, dbmodel.OperationDereservation, message{
-		UserId: userId,
-		Amount: amount,
-	})
-	if err != nil {
-		return err
-	}
 	return nil
 }
 
-func (s *reservationService) RevenueReservation(ctx context.Context, reservationId int) error {
-	userId, amount, err := s.reservation.RevenueReservation(ctx, reservationId)
-	if err != nil {
+func (s *reservationService) RevenueReservation(ctx context.Context, input RevenueInput) error {
+	if _, err := s.reservation.RevenueReservation(ctx, input.ReservationId, input.Amount); err != nil {
 		if errors.Is(err, pgerrs.ErrNotFound) {
 			return ErrReservationNotFound
 		}
+		if errors.Is(err, pgerrs.ErrReservationNotActive) {
+			return ErrReservationNotActive
+		}
+		if errors.Is(err, pgerrs.ErrRevenueExceedsReservation) {
+			return ErrRevenueExceedsAmount
+		}
 		log.Errorf("%s/RevenueReservation error refund recognition: %s", reservationPrefixLog, err)
 		return err
 	}
 
-	err = pushMessage(s.producer, dbmodel.OperationRevenue, message{
-		UserId: userId,
-		Amount: amount,
+	return nil
+}
+
+func (s *reservationService) GetReservation(ctx context.Context, reservationId int) (ReservationOutput, error) {
+	reservation, err := s.reservation.GetReservation(ctx, reservationId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ReservationOutput{}, ErrReservationNotFound
+		}
+		log.Errorf("%s/GetReservation error get reservation: %s", reservationPrefixLog, err)
+		return ReservationOutput{}, err
+	}
+	return reservationOutput(reservation), nil
+}
+
+func (s *reservationService) GetReservations(ctx context.Context, input ReservationsInput) ([]ReservationOutput, error) {
+	if input.Limit <= 0 || input.Limit > defaultLimit {
+		input.Limit = defaultLimit
+	}
+	reservations, err := s.reservation.GetReservations(ctx, dbmodel.ReservationFilter{
+		UserId:    input.UserId,
+		OrderId:   input.OrderId,
+		ProductId: input.ProductId,
+		Status:    input.Status,
+		Offset:    input.Offset,
+		Limit:     input.Limit,
 	})
 	if err != nil {
-		return err
+		log.Errorf("%s/GetReservations error get reservations: %s", reservationPrefixLog, err)
+		return nil, err
+	}
+	result := make([]ReservationOutput, 0, len(reservations))
+	for _, reservation := range reservations {
+		result = append(result, reservationOutput(reservation))
+	}
+	return result, nil
+}
+
+// ExpireReservations отмена резервирований с истекшим сроком, вызывается фоновым воркером
+func (s *reservationService) ExpireReservations(ctx context.Context) (int, error) {
+	expired, err := s.reservation.ExpireReservations(ctx, expireBatchSize)
+	if err != nil {
+		log.Errorf("%s/ExpireReservations error expire reservations: %s", reservationPrefixLog, err)
+		return expired, err
+	}
+	return expired, nil
+}
+
+// CancelOrder отмена всех активных резервирований заказа одной транзакцией
+func (s *reservationService) CancelOrder(ctx context.Context, orderId int) (OrderSettlementOutput, error) {
+	settlements, err := s.reservation.CancelOrder(ctx, orderId)
+	if err != nil {
+		return OrderSettlementOutput{}, s.orderError("CancelOrder", err)
+	}
+	return orderSettlementOutput(orderId, settlements), nil
+}
+
+// RevenueOrder признание выручкой всех активных резервирований заказа одной транзакцией
+func (s *reservationService) RevenueOrder(ctx context.Context, orderId int) (OrderSettlementOutput, error) {
+	settlements, err := s.reservation.RevenueOrder(ctx, orderId)
+	if err != nil {
+		return OrderSettlementOutput{}, s.orderError("RevenueOrder", err)
+	}
+	return orderSettlementOutput(orderId, settlements), nil
+}
+
+// RefundReservation возврат пользователю признанной выручки по резервированию
+func (s *reservationService) RefundReservation(ctx context.Context, input RefundInput) (ReservationOutput, error) {
+	key := idempotencyKey(dbmodel.IdempotencyScopeRefund, input.IdempotencyKey, input)
+	reservation, err := s.reservation.RefundReservation(ctx, input.ReservationId, input.Amount, key)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrIdempotentReplay) {
+			return reservationOutput(reservation), nil
+		}
+		if errors.Is(err, pgerrs.ErrIdempotencyKeyReused) {
+			return ReservationOutput{}, ErrIdempotencyKeyReused
+		}
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return ReservationOutput{}, ErrReservationNotFound
+		}
+		if refundErr := refundError(err); refundErr != nil {
+			return ReservationOutput{}, refundErr
+		}
+		log.Errorf("%s/RefundReservation error refund reservation: %s", reservationPrefixLog, err)
+		return ReservationOutput{}, err
+	}
+	return reservationOutput(reservation), nil
+}
+
+// RefundOrder возврат всей признанной выручки по заказу одной транзакцией
+func (s *reservationService) RefundOrder(ctx context.Context, orderId int) (OrderSettlementOutput, error) {
+	settlements, err := s.reservation.RefundOrder(ctx, orderId)
+	if err != nil {
+		if errors.Is(err, pgerrs.ErrNotFound) {
+			return OrderSettlementOutput{}, ErrOrderNotFound
+		}
+		if refundErr := refundError(err); refundErr != nil {
+			return OrderSettlementOutput{}, refundErr
+		}
+		log.Errorf("%s/RefundOrder error refund order: %s", reservationPrefixLog, err)
+		return OrderSettlementOutput{}, err
+	}
+	return orderSettlementOutput(orderId, settlements), nil
+}
+
+// Ошибка возврата, понятная клиенту. Для остальных ошибок возвращает nil
+func refundError(err error) error {
+	switch {
+	case errors.Is(err, pgerrs.ErrReservationNotRecognized):
+		return ErrReservationNotRecognized
+	case errors.Is(err, pgerrs.ErrRefundExceedsRevenue):
+		return ErrRefundExceedsRevenue
+	case errors.Is(err, pgerrs.ErrNothingToRefund):
+		return ErrNothingToRefund
+	case errors.Is(err, pgerrs.ErrAccountClosed):
+		return ErrAccountClosed
 	}
 	return nil
 }
+
+func (s *reservationService) orderError(method string, err error) error {
+	if errors.Is(err, pgerrs.ErrNotFound) {
+		return ErrOrderNotFound
+	}
+	if errors.Is(err, pgerrs.ErrReservationNotActive) {
+		return ErrOrderNotActive
+	}
+	log.Errorf("%s/%s error settle order: %s", reservationPrefixLog, method, err)
+	return err
+}
+
+func orderSettlementOutput(orderId int, settlements []dbmodel.OrderSettlement) OrderSettlementOutput {
+	result := OrderSettlementOutput{
+		OrderId:      orderId,
+		Reservations: make([]OrderReservationResult, 0, len(settlements)),
+	}
+	for _, settlement := range settlements {
+		result.Reservations = append(result.Reservations, OrderReservationResult{
+			ReservationId: settlement.Reservation.Id,
+			Amount:        settlement.Reservation.Amount,
+			Currency:      settlement.Reservation.Currency,
+			Status:        settlement.Reservation.Status,
+			Settled:       settlement.Settled,
+		})
+	}
+	return result
+}
+
+func reservationOutput(r dbmodel.Reservation) ReservationOutput {
+	return ReservationOutput{
+		ReservationId:    r.Id,
+		UserId:           r.UserId,
+		ProductId:        r.ProductId,
+		OrderId:          r.OrderId,
+		Amount:           r.Amount,
+		Currency:         r.Currency,
+		Status:           r.Status,
+		RecognizedAmount: r.RecognizedAmount,
+		RefundedAmount:   r.RefundedAmount,
+		CreatedAt:        r.CreatedAt,
+		ExpiresAt:        r.ExpiresAt,
+		CancelledAt:      r.CancelledAt,
+		RecognizedAt:     r.RecognizedAt,
+		ExpiredAt:        r.ExpiredAt,
+	}
+}
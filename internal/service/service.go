@@ -1,80 +1,393 @@
 package service
 
 import (
+	"avito_intership/internal/model/dbmodel"
 	"avito_intership/internal/repo"
 	"avito_intership/pkg/broker"
+	"avito_intership/pkg/filestore"
+	"avito_intership/pkg/money"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"github.com/segmentio/kafka-go"
-	log "github.com/sirupsen/logrus"
+	"io"
 	"time"
 )
 
 type (
 	DepositInput struct {
-		UserId int
-		Amount float64
+		UserId         int
+		Currency       string
+		Amount         money.Amount
+		IdempotencyKey string `json:"-"`
 	}
 	WithdrawInput struct {
-		UserId int
-		Amount float64
+		UserId         int
+		Currency       string
+		Amount         money.Amount
+		IdempotencyKey string `json:"-"`
 	}
 	TransferInput struct {
-		From   int
-		To     int
-		Amount float64
+		From           int
+		To             int
+		Amount         money.Amount
+		FromCurrency   string
+		ToCurrency     string // если не указана, то совпадает с FromCurrency
+		IdempotencyKey string `json:"-"`
+	}
+	BalanceOutput struct {
+		Currency    string       `json:"currency"`
+		Balance     money.Amount `json:"balance" swaggertype:"number"`  // свободные деньги, резерв уже вычтен
+		Reserved    money.Amount `json:"reserved" swaggertype:"number"` // удержано активными резервированиями
+		Total       money.Amount `json:"total" swaggertype:"number"`    // balance + reserved
+		CreditLimit money.Amount `json:"credit_limit" swaggertype:"number"`
+		Available   money.Amount `json:"available" swaggertype:"number"` // можно потратить: balance + credit_limit
+	}
+	BalanceAsOfOutput struct {
+		Currency string       `json:"currency"`
+		Balance  money.Amount `json:"balance" swaggertype:"number"`
+		AsOf     time.Time    `json:"as_of"`
+	}
+	HoldOutput struct {
+		OrderId      int          `json:"order_id"`
+		Currency     string       `json:"currency"`
+		Amount       money.Amount `json:"amount" swaggertype:"number"`
+		Reservations int          `json:"reservations"`
+		ExpiresAt    *time.Time   `json:"expires_at,omitempty"`
+	}
+	CreditLimitInput struct {
+		UserId      int
+		Currency    string
+		CreditLimit money.Amount
+	}
+	AccountStatusInput struct {
+		UserId int
+		Status string
+		Reason string
+	}
+	AccountStatusChangeOutput struct {
+		Status    string    `json:"status"`
+		Reason    string    `json:"reason"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	AccountStatusOutput struct {
+		UserId          int                         `json:"user_id"`
+		Status          string                      `json:"status"`
+		StatusChangedAt time.Time                   `json:"status_changed_at"`
+		History         []AccountStatusChangeOutput `json:"history"`
+	}
+)
+
+type (
+	RateInput struct {
+		Base  string
+		Quote string
+		Rate  money.Rate
+	}
+	RateOutput struct {
+		Base      string     `json:"base"`
+		Quote     string     `json:"quote"`
+		Rate      money.Rate `json:"rate" swaggertype:"number"`
+		UpdatedAt time.Time  `json:"updated_at"`
 	}
 )
 
 type (
 	ReservationInput struct {
+		UserId         int
+		ProductId      int
+		OrderId        int
+		Amount         money.Amount
+		Currency       string
+		ExpiresAt      *time.Time // если не указано, то срок берется из настроек сервиса
+		IdempotencyKey string     `json:"-"`
+	}
+	RevenueInput struct {
+		ReservationId int
+		Amount        money.Amount // 0 - признать всю сумму резервирования
+	}
+	RefundInput struct {
+		ReservationId  int
+		Amount         money.Amount // 0 - вернуть всю еще не возвращенную выручку
+		IdempotencyKey string       `json:"-"`
+	}
+	OrderReservationResult struct {
+		ReservationId int          `json:"reservation_id"`
+		Amount        money.Amount `json:"amount" swaggertype:"number"`
+		Currency      string       `json:"currency"`
+		Status        string       `json:"status"`
+		Settled       bool         `json:"settled"` // false - резервирование было закрыто раньше и не изменилось
+	}
+	OrderSettlementOutput struct {
+		OrderId      int                      `json:"order_id"`
+		Reservations []OrderReservationResult `json:"reservations"`
+	}
+	ReservationsInput struct {
 		UserId    int
-		ProductId int
 		OrderId   int
-		Amount    float64
+		ProductId int
+		Status    string
+		Offset    int
+		Limit     int
+	}
+	ReservationOutput struct {
+		ReservationId    int           `json:"reservation_id"`
+		UserId           int           `json:"user_id"`
+		ProductId        int           `json:"product_id"`
+		OrderId          int           `json:"order_id"`
+		Amount           money.Amount  `json:"amount" swaggertype:"number"`
+		Currency         string        `json:"currency"`
+		Status           string        `json:"status"`
+		RecognizedAmount *money.Amount `json:"recognized_amount,omitempty" swaggertype:"number"` // только для recognized
+		RefundedAmount   money.Amount  `json:"refunded_amount" swaggertype:"number"`
+		CreatedAt        time.Time     `json:"created_at"`
+		ExpiresAt        *time.Time    `json:"expires_at,omitempty"`
+		CancelledAt      *time.Time    `json:"cancelled_at,omitempty"`
+		RecognizedAt     *time.Time    `json:"recognized_at,omitempty"`
+		ExpiredAt        *time.Time    `json:"expired_at,omitempty"`
 	}
 )
 
 type (
+	// HistorySort поле сортировки истории: created_at, amount, type или id
+	HistorySort struct {
+		Field string
+		Desc  bool
+	}
+	// HistoryInput фильтр и страница истории. Нулевые значения фильтров не учитываются,
+	// пустая сортировка - сначала новые
 	HistoryInput struct {
-		UserId int
-		Sort   string
-		Offset int
-		Limit  int
+		UserId      int
+		Types       []string
+		CreatedFrom time.Time // включительно
+		CreatedTo   time.Time // не включительно
+		AmountFrom  money.Amount
+		AmountTo    money.Amount
+		ProductId   int
+		OrderId     int
+		Sort        []HistorySort
+		Cursor      string // next_cursor прошлой страницы, при нем Offset не используется
+		Offset      int
+		Limit       int
+	}
+	HistoryPageOutput struct {
+		Operations []HistoryOutput `json:"operations"`
+		NextCursor string          `json:"next_cursor,omitempty"` // пустой на последней странице
 	}
 	HistoryOutput struct {
-		OperationId int       `json:"operation_id"`
-		ProductId   *int      `json:"product_id"`
-		OrderId     *int      `json:"order_id"`
-		Amount      float64   `json:"amount"`
-		Type        string    `json:"type"`
-		CreatedAt   time.Time `json:"created_at"`
+		OperationId int          `json:"operation_id"`
+		ProductId   *int         `json:"product_id"`
+		OrderId     *int         `json:"order_id"`
+		Amount      money.Amount `json:"amount" swaggertype:"number"`
+		Currency    string       `json:"currency"`
+		Rate        *money.Rate  `json:"rate,omitempty" swaggertype:"number"`
+		Type        string       `json:"type"`
+		Overdraft   bool         `json:"overdraft"`
+		CreatedAt   time.Time    `json:"created_at"`
+	}
+	ReportLine struct {
+		ProductId  int          `json:"product_id"`
+		Currency   string       `json:"currency"`
+		Revenue    money.Amount `json:"revenue" swaggertype:"number"`
+		Operations int          `json:"operations"` // сколько раз признавалась выручка
+		Refunds    money.Amount `json:"refunds" swaggertype:"number"`
+		NetRevenue money.Amount `json:"net_revenue" swaggertype:"number"`
+	}
+	ReportOutput struct {
+		Year     int          `json:"year"`
+		Month    int          `json:"month"`
+		Products []ReportLine `json:"products"`
+	}
+	RevenueReportInput struct {
+		From      time.Time
+		To        time.Time // не включительно
+		Period    string    // day, week, month или quarter, по умолчанию month
+		ByProduct bool
+		ByUser    bool
+	}
+	RevenueReportLine struct {
+		PeriodStart string       `json:"period_start"` // дата начала периода, у первого может быть раньше начала отчета
+		ProductId   *int         `json:"product_id,omitempty"`
+		UserId      *int         `json:"user_id,omitempty"`
+		Currency    string       `json:"currency"`
+		Revenue     money.Amount `json:"revenue" swaggertype:"number"`
+		Operations  int          `json:"operations"`
+		Refunds     money.Amount `json:"refunds" swaggertype:"number"`
+		NetRevenue  money.Amount `json:"net_revenue" swaggertype:"number"`
+	}
+	RevenueReportOutput struct {
+		From   time.Time           `json:"from"`
+		To     time.Time           `json:"to"`
+		Period string              `json:"period"`
+		Lines  []RevenueReportLine `json:"lines"`
+	}
+	StatementInput struct {
+		UserId   int
+		Currency string
+		From     time.Time
+		To       time.Time // не включительно
+	}
+	StatementLine struct {
+		OperationId int          `json:"operation_id"`
+		Type        string       `json:"type"`
+		ProductId   *int         `json:"product_id,omitempty"`
+		OrderId     *int         `json:"order_id,omitempty"`
+		Amount      money.Amount `json:"amount" swaggertype:"number"`
+		Change      money.Amount `json:"change" swaggertype:"number"`  // изменение свободного баланса, со знаком
+		Balance     money.Amount `json:"balance" swaggertype:"number"` // баланс после операции
+		Rate        *money.Rate  `json:"rate,omitempty" swaggertype:"number"`
+		Overdraft   bool         `json:"overdraft"`
+		CreatedAt   time.Time    `json:"created_at"`
+	}
+	StatementOutput struct {
+		UserId         int             `json:"user_id"`
+		Currency       string          `json:"currency"`
+		From           time.Time       `json:"from"`
+		To             time.Time       `json:"to"`
+		OpeningBalance money.Amount    `json:"opening_balance" swaggertype:"number"`
+		ClosingBalance money.Amount    `json:"closing_balance" swaggertype:"number"`
+		Operations     []StatementLine `json:"operations"`
+	}
+)
+
+type (
+	LimitInput struct {
+		UserId        *int // пустой - глобальный лимит
+		Currency      string
+		PerOperation  *money.Amount
+		DailyAmount   *money.Amount
+		MonthlyAmount *money.Amount
+		DailyCount    *int
+		MonthlyCount  *int
+	}
+	LimitOutput struct {
+		UserId        *int          `json:"user_id,omitempty"`
+		Scope         string        `json:"scope"`
+		Currency      string        `json:"currency"`
+		PerOperation  *money.Amount `json:"per_operation,omitempty" swaggertype:"number"`
+		DailyAmount   *money.Amount `json:"daily_amount,omitempty" swaggertype:"number"`
+		MonthlyAmount *money.Amount `json:"monthly_amount,omitempty" swaggertype:"number"`
+		DailyCount    *int          `json:"daily_count,omitempty"`
+		MonthlyCount  *int          `json:"monthly_count,omitempty"`
+		UpdatedAt     time.Time     `json:"updated_at"`
+	}
+)
+
+type (
+	LedgerImbalance struct {
+		Kind     string       `json:"kind"`
+		Subject  string       `json:"subject"`
+		Currency string       `json:"currency"`
+		Expected money.Amount `json:"expected" swaggertype:"number"`
+		Actual   money.Amount `json:"actual" swaggertype:"number"`
+	}
+	LedgerCheckOutput struct {
+		Balanced   bool              `json:"balanced"`
+		Imbalances []LedgerImbalance `json:"imbalances"`
 	}
 )
 
 type Auth interface {
-	ValidateToken(token string) bool
+	ValidateToken(token string) (string, bool)
 	CreateToken() (string, error)
+	CreateAdminToken(adminKey string) (string, error)
 }
 
 type Account interface {
 	CreateAccount(ctx context.Context, userId int) error
-	GetBalance(ctx context.Context, userId int) (float64, error)
+	GetBalance(ctx context.Context, userId int, currency string) (BalanceOutput, error)
+	GetBalances(ctx context.Context, userId int) ([]BalanceOutput, error)
+	GetHolds(ctx context.Context, userId int, currency string) ([]HoldOutput, error)
+	GetBalanceAsOf(ctx context.Context, userId int, currency string, asOf time.Time) (BalanceAsOfOutput, error)
+	TakeBalanceSnapshots(ctx context.Context) (int, error)
 
 	Deposit(ctx context.Context, input DepositInput) error
 	Withdraw(ctx context.Context, input WithdrawInput) error
 	Transfer(ctx context.Context, input TransferInput) error
+
+	SetCreditLimit(ctx context.Context, input CreditLimitInput) (BalanceOutput, error)
+	SetStatus(ctx context.Context, input AccountStatusInput) error
+	GetStatus(ctx context.Context, userId int) (AccountStatusOutput, error)
 }
 
 type Reservation interface {
 	CreateReservation(ctx context.Context, input ReservationInput) (int, error)
 	CancelReservation(ctx context.Context, reservationId int) error
-	RevenueReservation(ctx context.Context, reservationId int) error
+	RevenueReservation(ctx context.Context, input RevenueInput) error
+	GetReservation(ctx context.Context, reservationId int) (ReservationOutput, error)
+	GetReservations(ctx context.Context, input ReservationsInput) ([]ReservationOutput, error)
+	ExpireReservations(ctx context.Context) (int, error)
+
+	CancelOrder(ctx context.Context, orderId int) (OrderSettlementOutput, error)
+	RevenueOrder(ctx context.Context, orderId int) (OrderSettlementOutput, error)
+
+	RefundReservation(ctx context.Context, input RefundInput) (ReservationOutput, error)
+	RefundOrder(ctx context.Context, orderId int) (OrderSettlementOutput, error)
 }
 
 type Operation interface {
-	GetHistory(ctx context.Context, input HistoryInput) ([]HistoryOutput, error)
-	CreateReport(ctx context.Context, year, month int) ([]byte, error)
+	GetHistory(ctx context.Context, input HistoryInput) (HistoryPageOutput, error)
+	ExportHistory(ctx context.Context, input HistoryInput, format string, w io.Writer) error
+	GetReport(ctx context.Context, year, month int) (ReportOutput, error)
+	CreateReport(ctx context.Context, year, month int, format string) ([]byte, error)
+	GetRevenueReport(ctx context.Context, input RevenueReportInput) (RevenueReportOutput, error)
+	CreateRevenueReport(ctx context.Context, input RevenueReportInput, format string) ([]byte, error)
+	GetStatement(ctx context.Context, input StatementInput) (StatementOutput, error)
+	CreateStatementCSV(ctx context.Context, input StatementInput) ([]byte, error)
+}
+
+type Rate interface {
+	SetRate(ctx context.Context, input RateInput) error
+	GetRate(ctx context.Context, base, quote string) (RateOutput, error)
+}
+
+type Ledger interface {
+	Check(ctx context.Context) (LedgerCheckOutput, error)
+}
+
+type Limit interface {
+	SetLimit(ctx context.Context, input LimitInput) (LimitOutput, error)
+	GetLimits(ctx context.Context, userId *int) ([]LimitOutput, error)
+	DeleteLimit(ctx context.Context, userId *int, currency string) error
+}
+
+type Outbox interface {
+	Relay(ctx context.Context) (int, error)
+	Cleanup(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+type (
+	// ReportJobInput отчет для фоновой генерации: Year и Month для monthly, Revenue для revenue
+	ReportJobInput struct {
+		Kind    string
+		Format  string // csv, json или xlsx, по умолчанию csv
+		Year    int
+		Month   int
+		Revenue RevenueReportInput
+	}
+	ReportJobOutput struct {
+		JobId      int             `json:"job_id"`
+		Kind       string          `json:"kind"`
+		Format     string          `json:"format"`
+		Params     json.RawMessage `json:"params" swaggertype:"object"`
+		Status     string          `json:"status"`
+		Error      *string         `json:"error,omitempty"`
+		CreatedAt  time.Time       `json:"created_at"`
+		StartedAt  *time.Time      `json:"started_at,omitempty"`
+		FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	}
+	ReportFileOutput struct {
+		Name   string
+		Format string
+		File   io.ReadCloser
+	}
+)
+
+type Report interface {
+	CreateJob(ctx context.Context, input ReportJobInput) (ReportJobOutput, bool, error)
+	GetJob(ctx context.Context, id int) (ReportJobOutput, error)
+	OpenFile(ctx context.Context, id int) (ReportFileOutput, error)
+	ProcessJobs(ctx context.Context) (int, error)
 }
 
 type (
@@ -83,47 +396,60 @@ type (
 		Account     Account
 		Reservation Reservation
 		Operation   Operation
+		Rate        Rate
+		Ledger      Ledger
+		Limit       Limit
+		Outbox      Outbox
+		Report      Report
 	}
 	ServicesDependencies struct {
-		Repos      *repo.Repositories
-		Producer   broker.Producer
-		PrivateKey string
-		PublicKey  string
+		Repos          *repo.Repositories
+		Producer       broker.Producer
+		PrivateKey     string
+		PublicKey      string
+		AdminKey       string
+		ReservationTTL time.Duration // срок резервирования по умолчанию, 0 - без срока
+		SnapshotDelay  time.Duration // через сколько после конца дня снимаются балансы
+
+		ReportStore      filestore.Store // где лежат сгенерированные в фоне отчеты
+		ReportJobTimeout time.Duration   // сколько может генерироваться один отчет
 	}
 )
 
 func NewServices(d *ServicesDependencies) *Services {
+	operation := newOperationService(d.Repos.Operation)
 	return &Services{
-		Auth:        newAuthService(d.PrivateKey, d.PublicKey),
-		Account:     newAccountService(d.Repos.Account, d.Producer),
-		Reservation: newReservationService(d.Repos.Reservation, d.Producer),
-		Operation:   newOperationService(d.Repos.Operation),
+		Auth:        newAuthService(d.PrivateKey, d.PublicKey, d.AdminKey),
+		Account:     newAccountService(d.Repos.Account, d.SnapshotDelay),
+		Reservation: newReservationService(d.Repos.Reservation, d.ReservationTTL),
+		Operation:   operation,
+		Rate:        newRateService(d.Repos.Rate),
+		Ledger:      newLedgerService(d.Repos.Ledger),
+		Limit:       newLimitService(d.Repos.Limit),
+		Outbox:      newOutboxService(d.Repos.Outbox, d.Producer),
+		Report:      newReportService(d.Repos.Report, operation, d.ReportStore, d.ReportJobTimeout),
 	}
 }
 
-type message struct {
-	UserId int
-	Amount float64
+// Ключ идемпотентности для repo. Хэш считается по входным данным операции (без самого ключа),
+// поэтому повтор с тем же телом совпадет с сохраненным, а другой запрос с тем же ключом - нет
+func idempotencyKey(scope, key string, input any) *dbmodel.IdempotencyKey {
+	if key == "" {
+		return nil
+	}
+	body, _ := json.Marshal(input)
+	hash := sha256.Sum256(body)
+	return &dbmodel.IdempotencyKey{
+		Scope:       scope,
+		Key:         key,
+		RequestHash: hex.EncodeToString(hash[:]),
+	}
 }
 
-// Функция, которая пушит сообщения в брокер.
-// Представим, что у нас есть микросервис нотификаций,
-// который отправляет сообщение пользователю о новой операции на аккаунте.
-// Тк микросервис вымышленный, то выбрал условный формат сообщения userId + amount.
-// Ключ для consumer`а - тип операции
-func pushMessage(producer broker.Producer, key string, input any) error {
-	body, err := json.Marshal(input)
-	if err != nil {
-		log.Errorf("/service/service/pushMessage error marshal input: %s", err)
-		return err
-	}
-	_, err = producer.WriteMessages(kafka.Message{
-		Key:   []byte(key),
-		Value: body,
-	})
-	if err != nil {
-		log.Errorf("/service/service/pushMessage error push message to broker: %s", err)
-		return err
-	}
-	return nil
+// Если клиент не указал валюту, то операция в валюте по умолчанию
+func currencyOrDefault(currency string) string {
+	if currency == "" {
+		return money.DefaultCurrency
+	}
+	return currency
 }
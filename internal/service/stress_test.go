@@ -0,0 +1,141 @@
+//go:build integration
+
+// Нагрузочная проверка списаний: тысячи параллельных списаний, переводов и резервирований
+// по двум аккаунтам против живых postgres и redis. Тест падает, если баланс ушел в минус,
+// деньги появились или пропали, или сверка журнала (Ledger.Check) нашла расхождения. Запуск: make stress
+package service
+
+import (
+	"avito_intership/internal/model/dbmodel"
+	"avito_intership/internal/repo"
+	"avito_intership/internal/repo/pgerrs"
+	"avito_intership/pkg/money"
+	"avito_intership/pkg/postgres"
+	"avito_intership/pkg/redis"
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	stressOps     = flag.Int("ops", 5000, "number of parallel debit operations")
+	stressWorkers = flag.Int("workers", 64, "number of concurrent workers")
+	stressInitial = flag.Int64("initial", 100_000, "initial balance of each account, kopecks")
+	stressAmount  = flag.Int64("amount", 700, "amount of each operation, kopecks")
+)
+
+func TestConcurrentDebits(t *testing.T) {
+	pgUrl := os.Getenv("PG_URL")
+	if pgUrl == "" {
+		t.Skip("PG_URL is not set")
+	}
+
+	pg, err := postgres.NewPG(pgUrl, postgres.MaxPoolSize(*stressWorkers))
+	if err != nil {
+		t.Fatalf("init postgres: %s", err)
+	}
+	defer pg.Close()
+
+	rdb := redis.NewRedis(os.Getenv("REDIS_URL"), redis.SetPassword(os.Getenv("REDIS_PASSWORD")))
+	defer rdb.Close()
+
+	repos := repo.NewRepositories(pg, rdb)
+	ctx := context.Background()
+
+	// отдельные аккаунты на каждый запуск, чтобы не зависеть от данных в базе
+	base := int(time.Now().UnixNano() % 1_000_000_000)
+	users := []int{base, base + 1}
+	for _, userId := range users {
+		if err = repos.CreateAccount(ctx, userId); err != nil {
+			t.Fatalf("create account %d: %s", userId, err)
+		}
+		if err = repos.Deposit(ctx, userId, money.DefaultCurrency, money.Amount(*stressInitial), nil); err != nil {
+			t.Fatalf("deposit %d: %s", userId, err)
+		}
+	}
+
+	var (
+		debited  atomic.Int64 // сколько денег ушло из системы (списания и резервирования)
+		rejected atomic.Int64
+		jobs     = make(chan int)
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < *stressWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				from, to := users[i%2], users[(i+1)%2]
+				sum := money.Amount(*stressAmount)
+
+				var err error
+				switch i % 3 {
+				case 0:
+					err = repos.Withdraw(ctx, from, money.DefaultCurrency, sum, nil)
+				case 1:
+					_, err = repos.Transfer(ctx, from, to, sum, money.DefaultCurrency, money.DefaultCurrency, nil)
+				case 2:
+					_, err = repos.CreateReservation(ctx, dbmodel.Reservation{
+						UserId:    from,
+						ProductId: i,
+						OrderId:   i,
+						Amount:    sum,
+						Currency:  money.DefaultCurrency,
+					}, nil)
+				}
+
+				switch {
+				case err == nil:
+					if i%3 != 1 { // перевод не меняет сумму денег на двух аккаунтах
+						debited.Add(int64(sum))
+					}
+				case errors.Is(err, pgerrs.ErrNotEnoughBalance):
+					rejected.Add(1)
+				default:
+					t.Errorf("operation %d: %s", i, err)
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	for i := 0; i < *stressOps; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	t.Logf("%d operations in %s: %d rejected for balance", *stressOps, time.Since(start), rejected.Load())
+
+	var total money.Amount
+	for _, userId := range users {
+		// баланс читается из бд в обход кэша, проверяется именно источник истины
+		balances, err := repos.GetBalances(ctx, userId)
+		if err != nil {
+			t.Fatalf("get balance %d: %s", userId, err)
+		}
+		for _, b := range balances {
+			if b.Balance < 0 {
+				t.Errorf("user %d balance went negative: %s", userId, b.Balance)
+			}
+			total += b.Balance
+		}
+	}
+	if expected := money.Amount(*stressInitial*int64(len(users)) - debited.Load()); total != expected {
+		t.Errorf("total balance %s, expected %s", total, expected)
+	}
+
+	// та же сверка, что отдает /ledger/check: проводки каждой записи и всего журнала в ноль,
+	// балансы и активные резервирования совпадают с проводками
+	check, err := newLedgerService(repos.Ledger).Check(ctx)
+	if err != nil {
+		t.Fatalf("ledger check: %s", err)
+	}
+	if !check.Balanced {
+		t.Errorf("ledger is not balanced: %+v", check.Imbalances)
+	}
+}
@@ -0,0 +1,13 @@
+drop index if exists reservation_expires_at_idx;
+
+-- деньги по истекшим резервированиям уже вернулись, для старой схемы это отмена
+update reservation
+set status       = 'cancelled',
+    cancelled_at = expired_at
+where status = 'expired';
+
+alter table reservation
+    drop constraint if exists reservation_status_check,
+    add constraint reservation_status_check check ( status in ('active', 'cancelled', 'recognized') ),
+    drop column if exists expired_at,
+    drop column if exists expires_at;
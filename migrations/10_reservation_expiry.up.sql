@@ -0,0 +1,10 @@
+-- резервирование может истекать: по сроку деньги возвращаются пользователю фоновым воркером
+alter table reservation
+    add column if not exists expires_at timestamp default null,
+    add column if not exists expired_at timestamp default null;
+
+alter table reservation
+    drop constraint if exists reservation_status_check,
+    add constraint reservation_status_check check ( status in ('active', 'cancelled', 'recognized', 'expired') );
+
+create index if not exists reservation_expires_at_idx on reservation (expires_at) where status = 'active';
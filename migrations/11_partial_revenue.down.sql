@@ -0,0 +1,2 @@
+alter table reservation
+    drop column if exists recognized_amount;
@@ -0,0 +1,7 @@
+-- выручкой может быть признана только часть резервирования, остаток возвращается пользователю
+alter table reservation
+    add column if not exists recognized_amount bigint default null;
+
+update reservation
+set recognized_amount = amount
+where status = 'recognized';
@@ -0,0 +1,3 @@
+alter table reservation
+    drop constraint if exists reservation_refunded_amount_check,
+    drop column if exists refunded_amount;
@@ -0,0 +1,6 @@
+-- возвраты после признания выручки: сколько уже вернули по резервированию, больше признанного вернуть нельзя
+alter table reservation
+    add column if not exists refunded_amount bigint not null default 0;
+
+alter table reservation
+    add constraint reservation_refunded_amount_check check ( refunded_amount >= 0 and refunded_amount <= coalesce(recognized_amount, 0) );
@@ -0,0 +1,6 @@
+drop table if exists account_status_history;
+
+alter table account
+    drop constraint if exists account_status_check,
+    drop column if exists status_changed_at,
+    drop column if exists status;
@@ -0,0 +1,19 @@
+-- заблокированный аккаунт может только получать деньги, закрытый не участвует ни в каких операциях
+alter table account
+    add column if not exists status            varchar   not null default 'active',
+    add column if not exists status_changed_at timestamp not null default now();
+
+alter table account
+    add constraint account_status_check check ( status in ('active', 'frozen', 'closed') );
+
+-- каждая смена статуса делается админом и должна иметь причину
+create table if not exists account_status_history
+(
+    id         serial primary key,
+    user_id    int       not null references account (user_id),
+    status     varchar   not null,
+    reason     varchar   not null,
+    created_at timestamp not null default now()
+);
+
+create index if not exists account_status_history_user_id_idx on account_status_history (user_id);
@@ -0,0 +1,13 @@
+alter table operation
+    drop column if exists overdraft;
+
+-- откат не пройдет, пока есть балансы в минусе
+alter table account_balance
+    drop constraint if exists account_balance_within_credit_limit;
+
+alter table account_balance
+    add constraint account_balance_non_negative check ( balance >= 0 );
+
+alter table account_balance
+    drop constraint if exists account_balance_credit_limit_check,
+    drop column if exists credit_limit;
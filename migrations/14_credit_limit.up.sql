@@ -0,0 +1,17 @@
+-- кредитный лимит: насколько баланс в этой валюте может уйти в минус. По умолчанию 0 - минус запрещен
+alter table account_balance
+    add column if not exists credit_limit bigint not null default 0;
+
+alter table account_balance
+    add constraint account_balance_credit_limit_check check ( credit_limit >= 0 );
+
+-- последняя линия защиты теперь учитывает лимит
+alter table account_balance
+    drop constraint if exists account_balance_non_negative;
+
+alter table account_balance
+    add constraint account_balance_within_credit_limit check ( balance + credit_limit >= 0 );
+
+-- списания, после которых баланс ушел в минус, помечаются в истории
+alter table operation
+    add column if not exists overdraft boolean not null default false;
@@ -0,0 +1,3 @@
+drop index if exists operation_user_id_created_at_idx;
+
+drop table if exists spending_limit;
@@ -0,0 +1,20 @@
+-- лимиты расходов по валюте: для конкретного аккаунта или глобальные (user_id is null).
+-- null в поле лимита - ограничения нет. Действуют и глобальный, и лимит аккаунта одновременно
+create table if not exists spending_limit
+(
+    id             serial primary key,
+    user_id        int                 default null references account (user_id),
+    currency       varchar(3) not null,
+    per_operation  bigint              default null check ( per_operation > 0 ),
+    daily_amount   bigint              default null check ( daily_amount > 0 ),
+    monthly_amount bigint              default null check ( monthly_amount > 0 ),
+    daily_count    int                 default null check ( daily_count > 0 ),
+    monthly_count  int                 default null check ( monthly_count > 0 ),
+    updated_at     timestamp  not null default now()
+);
+
+create unique index if not exists spending_limit_global_idx on spending_limit (currency) where user_id is null;
+create unique index if not exists spending_limit_account_idx on spending_limit (user_id, currency) where user_id is not null;
+
+-- лимиты считаются по списаниям аккаунта за текущий день и месяц
+create index if not exists operation_user_id_created_at_idx on operation (user_id, created_at);
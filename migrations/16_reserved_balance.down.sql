@@ -0,0 +1,3 @@
+alter table account_balance
+    drop constraint if exists account_balance_reserved_check,
+    drop column if exists reserved;
@@ -0,0 +1,16 @@
+-- сколько денег аккаунта сейчас удержано активными резервированиями. Меняется в тех же транзакциях, что и баланс,
+-- поэтому баланс, резерв и их сумма всегда согласованы
+alter table account_balance
+    add column if not exists reserved bigint not null default 0;
+
+alter table account_balance
+    add constraint account_balance_reserved_check check ( reserved >= 0 );
+
+update account_balance b
+set reserved = r.amount
+from (select user_id, currency, sum(amount) as amount
+      from reservation
+      where status = 'active'
+      group by user_id, currency) r
+where r.user_id = b.user_id
+  and r.currency = b.currency;
@@ -0,0 +1,2 @@
+drop table if exists balance_snapshot_run;
+drop table if exists balance_snapshot;
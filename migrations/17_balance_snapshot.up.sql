@@ -0,0 +1,18 @@
+-- снимки балансов на границе дня: balance - сумма проводок аккаунта, созданных до taken_at.
+-- Баланс на момент времени = последний снимок до него + проводки после снимка
+create table if not exists balance_snapshot
+(
+    user_id  int        not null references account (user_id),
+    currency varchar(3) not null,
+    taken_at timestamp  not null,
+    balance  bigint     not null,
+    primary key (user_id, currency, taken_at)
+);
+
+-- какие границы дня уже сняты. Снимок пишется только для аккаунтов с проводками за день,
+-- поэтому по самим снимкам нельзя понять, что день обработан
+create table if not exists balance_snapshot_run
+(
+    taken_at   timestamp primary key,
+    created_at timestamp not null default now()
+);
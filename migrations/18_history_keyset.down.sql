@@ -0,0 +1,4 @@
+drop index if exists operation_user_id_type_id_idx;
+drop index if exists operation_user_id_amount_id_idx;
+drop index if exists operation_user_id_created_at_id_idx;
+create index if not exists operation_user_id_created_at_idx on operation (user_id, created_at);
@@ -0,0 +1,5 @@
+-- keyset пагинация истории: индексы в порядке сортировки с id для однозначности
+drop index if exists operation_user_id_created_at_idx;
+create index if not exists operation_user_id_created_at_id_idx on operation (user_id, created_at, id);
+create index if not exists operation_user_id_amount_id_idx on operation (user_id, amount, id);
+create index if not exists operation_user_id_type_id_idx on operation (user_id, type, id);
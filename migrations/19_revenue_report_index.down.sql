@@ -0,0 +1 @@
+drop index if exists operation_type_created_at_idx;
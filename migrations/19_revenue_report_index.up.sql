@@ -0,0 +1,4 @@
+-- отчеты по выручке фильтруют по типу операции и диапазону created_at,
+-- остальные нужные отчету поля лежат в индексе, чтобы не ходить в таблицу
+create index if not exists operation_type_created_at_idx on operation (type, created_at)
+    include (product_id, user_id, currency, amount);
@@ -0,0 +1 @@
+drop table if exists report_job;
@@ -0,0 +1,22 @@
+-- фоновая генерация отчетов. Готовый файл лежит в файловом хранилище под именем file_name
+create table if not exists report_job
+(
+    id          serial primary key,
+    kind        varchar(16) not null,                   -- monthly или revenue
+    format      varchar(8)  not null,                   -- csv, json или xlsx
+    params      jsonb       not null,
+    params_hash varchar(64) not null,                   -- хэш kind, format и params, по нему ищутся одинаковые запросы
+    period_end  timestamp   not null,                   -- пока конец периода отчета не прошел, готовый отчет может устареть
+    status      varchar(16) not null default 'pending', -- pending, running, done или failed
+    attempts    int         not null default 0,
+    error       text                 default null,
+    file_name   text                 default null,
+    created_at  timestamp   not null default now(),
+    started_at  timestamp            default null,
+    finished_at timestamp            default null
+);
+
+-- один и тот же отчет не генерируется параллельно: повторный запрос получает уже созданную задачу
+create unique index if not exists report_job_active_hash_idx on report_job (params_hash) where status in ('pending', 'running');
+create index if not exists report_job_hash_idx on report_job (params_hash, id);
+create index if not exists report_job_queue_idx on report_job (id) where status in ('pending', 'running');
@@ -0,0 +1 @@
+drop index if exists outbox_user_pending_idx;
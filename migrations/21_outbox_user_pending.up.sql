@@ -0,0 +1,3 @@
+-- relay пропускает события пользователя, у которого есть более раннее неотправленное событие,
+-- ждущее повтора. Проверка идет по этому индексу, а не по всей outbox
+create index if not exists outbox_user_pending_idx on outbox (user_id, id) where sent_at is null;
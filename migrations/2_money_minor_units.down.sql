@@ -0,0 +1,8 @@
+alter table account
+    alter column balance type float using balance::float / 100;
+
+alter table reservation
+    alter column amount type float using amount::float / 100;
+
+alter table operation
+    alter column amount type float using amount::float / 100;
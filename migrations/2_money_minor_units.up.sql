@@ -0,0 +1,9 @@
+-- суммы храним в копейках (bigint), чтобы не терять точность на float
+alter table account
+    alter column balance type bigint using round(balance::numeric * 100)::bigint;
+
+alter table reservation
+    alter column amount type bigint using round(amount::numeric * 100)::bigint;
+
+alter table operation
+    alter column amount type bigint using round(amount::numeric * 100)::bigint;
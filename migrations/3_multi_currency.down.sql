@@ -0,0 +1,21 @@
+drop table if exists exchange_rate;
+
+alter table operation
+    drop column if exists rate;
+
+alter table operation
+    drop column if exists currency;
+
+alter table reservation
+    drop column if exists currency;
+
+alter table account
+    add column balance bigint not null default 0;
+
+update account a
+set balance = b.balance
+from account_balance b
+where b.user_id = a.user_id
+  and b.currency = 'RUB';
+
+drop table if exists account_balance;
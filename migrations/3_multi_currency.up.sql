@@ -0,0 +1,36 @@
+-- у пользователя теперь может быть несколько балансов, по одному на каждую валюту
+create table if not exists account_balance
+(
+    user_id    int        not null references account (user_id),
+    currency   varchar(3) not null,
+    balance    bigint     not null default 0,
+    created_at timestamp  not null default now(),
+    primary key (user_id, currency)
+);
+
+insert into account_balance (user_id, currency, balance, created_at)
+select user_id, 'RUB', balance, created_at
+from account;
+
+alter table account
+    drop column balance;
+
+alter table reservation
+    add column currency varchar(3) not null default 'RUB';
+
+alter table operation
+    add column currency varchar(3) not null default 'RUB';
+
+-- курс, по которому был сделан перевод между разными валютами
+alter table operation
+    add column rate numeric(20, 8) default null;
+
+-- сколько единиц quote дают за одну единицу base
+create table if not exists exchange_rate
+(
+    base       varchar(3)     not null,
+    quote      varchar(3)     not null,
+    rate       numeric(20, 8) not null check ( rate > 0 ),
+    updated_at timestamp      not null default now(),
+    primary key (base, quote)
+);
@@ -0,0 +1,8 @@
+alter table operation
+    drop column if exists entry_id;
+
+drop trigger if exists posting_entry_balanced on posting;
+drop function if exists check_journal_entry_balanced();
+
+drop table if exists posting;
+drop table if exists journal_entry;
@@ -0,0 +1,78 @@
+-- журнал двойной записи: каждая бизнес операция - одна запись журнала с набором проводок,
+-- сумма которых по каждой валюте равна нулю
+create table if not exists journal_entry
+(
+    id         serial primary key,
+    type       varchar   not null,
+    created_at timestamp not null default now()
+);
+
+create table if not exists posting
+(
+    id             serial primary key,
+    entry_id       int        not null references journal_entry (id),
+    user_id        int                 default null references account (user_id),
+    system_account varchar             default null,
+    currency       varchar(3) not null,
+    amount         bigint     not null check ( amount <> 0 ),
+    created_at     timestamp  not null default now(),
+    check ( (user_id is null) <> (system_account is null) ) -- счет либо пользовательский, либо системный
+);
+
+create index if not exists posting_entry_id_idx on posting (entry_id);
+create index if not exists posting_user_id_idx on posting (user_id, currency, created_at);
+
+alter table operation
+    add column entry_id int default null references journal_entry (id);
+
+-- проверка баланса записи журнала на коммите, чтобы несбалансированная запись не могла попасть в бд
+create or replace function check_journal_entry_balanced() returns trigger as
+$$
+begin
+    if exists(select 1
+              from posting
+              where entry_id = new.entry_id
+              group by currency
+              having sum(amount) <> 0) then
+        raise exception 'journal entry % is not balanced', new.entry_id;
+    end if;
+    return null;
+end;
+$$ language plpgsql;
+
+create constraint trigger posting_entry_balanced
+    after insert
+    on posting
+    deferrable initially deferred
+    for each row
+execute function check_journal_entry_balanced();
+
+-- входящие остатки: текущие балансы и активные резервирования переносятся в журнал одной записью
+do
+$$
+    declare
+        opening_id int;
+    begin
+        if exists(select 1 from account_balance where balance <> 0) or exists(select 1 from reservation) then
+            insert into journal_entry (type) values ('opening-balance') returning id into opening_id;
+
+            insert into posting (entry_id, user_id, currency, amount)
+            select opening_id, user_id, currency, balance
+            from account_balance
+            where balance <> 0;
+
+            insert into posting (entry_id, system_account, currency, amount)
+            select opening_id, 'holds', currency, sum(amount)
+            from reservation
+            group by currency
+            having sum(amount) <> 0;
+
+            insert into posting (entry_id, system_account, currency, amount)
+            select opening_id, 'opening-balance', currency, -sum(amount)
+            from posting
+            where entry_id = opening_id
+            group by currency
+            having sum(amount) <> 0;
+        end if;
+    end
+$$;
@@ -0,0 +1,11 @@
+-- ключи идемпотентности денежных операций. Ключ и ответ сохраняются в той же транзакции, что и сама операция,
+-- поэтому повтор запроса либо вернет сохраненный ответ, либо (если первая попытка откатилась) выполнится заново
+create table if not exists idempotency_key
+(
+    scope        varchar      not null, -- тип операции, ключи разных операций не пересекаются
+    key          varchar(255) not null,
+    request_hash varchar(64)  not null,
+    response     jsonb                 default null,
+    created_at   timestamp    not null default now(),
+    primary key (scope, key)
+);
@@ -0,0 +1,16 @@
+-- события для брокера пишутся в той же транзакции, что и изменение баланса, а отправляются фоновым relay
+create table if not exists outbox
+(
+    id              bigserial primary key,
+    user_id         int       not null,
+    key             varchar   not null, -- тип операции, ключ сообщения в kafka
+    payload         jsonb     not null,
+    attempts        int       not null default 0,
+    last_error      varchar            default null,
+    next_attempt_at timestamp not null default now(),
+    created_at      timestamp not null default now(),
+    sent_at         timestamp          default null
+);
+
+create index if not exists outbox_pending_idx on outbox (id) where sent_at is null;
+create index if not exists outbox_sent_at_idx on outbox (sent_at) where sent_at is not null;
@@ -0,0 +1,2 @@
+alter table account_balance
+    drop constraint if exists account_balance_non_negative;
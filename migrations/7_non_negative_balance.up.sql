@@ -0,0 +1,3 @@
+-- последняя линия защиты от ухода в минус: даже если код где-то спишет лишнее, транзакция упадет
+alter table account_balance
+    add constraint account_balance_non_negative check ( balance >= 0 );
@@ -0,0 +1,2 @@
+alter table account_balance
+    drop column if exists version;
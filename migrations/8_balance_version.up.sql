@@ -0,0 +1,3 @@
+-- версия баланса увеличивается при каждом изменении, по ней кэш не дает старому значению перезаписать новое
+alter table account_balance
+    add column if not exists version bigint not null default 0;
@@ -0,0 +1,14 @@
+drop index if exists reservation_product_id_idx;
+drop index if exists reservation_order_id_idx;
+drop index if exists reservation_user_id_idx;
+
+-- до статусов закрытых резервирований в таблице не было
+delete
+from reservation
+where status <> 'active';
+
+alter table reservation
+    drop constraint if exists reservation_status_check,
+    drop column if exists recognized_at,
+    drop column if exists cancelled_at,
+    drop column if exists status;
@@ -0,0 +1,12 @@
+-- резервирования больше не удаляются, а переходят в конечный статус, чтобы по ним оставалась история
+alter table reservation
+    add column if not exists status        varchar   not null default 'active',
+    add column if not exists cancelled_at  timestamp          default null,
+    add column if not exists recognized_at timestamp          default null;
+
+alter table reservation
+    add constraint reservation_status_check check ( status in ('active', 'cancelled', 'recognized') );
+
+create index if not exists reservation_user_id_idx on reservation (user_id);
+create index if not exists reservation_order_id_idx on reservation (order_id);
+create index if not exists reservation_product_id_idx on reservation (product_id);
@@ -7,30 +7,49 @@ import (
 )
 
 const (
-	defaultWriteTopic  = "account-balance"
-	defaultConnTimeout = time.Second * 10
+	defaultWriteTopic = "account-balance"
+
+	// relay отправляет события под блокировкой outbox, поэтому зависший брокер не должен держать ее долго
+	defaultWriteTimeout = time.Second * 5
 )
 
 type Producer interface {
-	WriteMessages(msgs ...kafka.Message) (int, error)
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
 	Close()
 }
 
+// producer поверх kafka.Writer: writer сам находит лидера партиции и переподключается,
+// если брокер перезапустился или соединение оборвалось по таймауту
 type producer struct {
-	*kafka.Conn
+	writer *kafka.Writer
 }
 
 func NewProducer(url string) (Producer, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultConnTimeout)
+	return &producer{
+		writer: &kafka.Writer{
+			Addr:  kafka.TCP(url),
+			Topic: defaultWriteTopic,
+			// все сообщения в одну партицию, как и раньше: порядок событий пользователя сохраняется
+			Balancer: kafka.BalancerFunc(func(msg kafka.Message, partitions ...int) int {
+				return partitions[0]
+			}),
+			// relay отправляет по одному сообщению и ждет ответа, копить пачку незачем
+			BatchSize:              1,
+			RequiredAcks:           kafka.RequireOne,
+			MaxAttempts:            1, // повтор делает relay с задержкой
+			AllowAutoTopicCreation: true,
+		},
+	}, nil
+}
+
+// WriteMessages отправка с дедлайном defaultWriteTimeout, по истечении которого возвращается ошибка
+func (p *producer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultWriteTimeout)
 	defer cancel()
 
-	conn, err := kafka.DialLeader(ctx, "tcp", url, defaultWriteTopic, 0)
-	if err != nil {
-		return nil, err
-	}
-	return &producer{conn}, nil
+	return p.writer.WriteMessages(ctx, msgs...)
 }
 
 func (p *producer) Close() {
-	_ = p.Conn.Close()
+	_ = p.writer.Close()
 }
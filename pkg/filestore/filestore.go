@@ -0,0 +1,53 @@
+package filestore
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Store хранилище файлов по имени. Имена плоские, без каталогов
+type Store interface {
+	Save(name string, data []byte) error
+	Open(name string) (io.ReadCloser, error)
+}
+
+type local struct {
+	dir string
+}
+
+// NewLocal хранилище в каталоге dir на локальном диске, каталог создается, если его нет
+func NewLocal(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &local{dir: dir}, nil
+}
+
+// Save пишет файл во временный и переименовывает, так что читатель никогда не увидит файл наполовину
+func (l *local) Save(name string, data []byte) error {
+	tmp, err := os.CreateTemp(l.dir, "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path(name))
+}
+
+// Open открывает файл на чтение. Если файла нет, то ошибка os.ErrNotExist
+func (l *local) Open(name string) (io.ReadCloser, error) {
+	return os.Open(l.path(name))
+}
+
+// filepath.Base не дает имени выйти за пределы каталога хранилища
+func (l *local) path(name string) string {
+	return filepath.Join(l.dir, filepath.Base(name))
+}
@@ -0,0 +1,130 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Scale количество минимальных единиц (копеек) в одной единице валюты
+const (
+	Scale     = 100
+	precision = 2
+)
+
+var (
+	ErrInvalidAmount = errors.New("invalid money amount")
+	ErrOverflow      = errors.New("money amount overflow")
+)
+
+// Amount денежная сумма в минимальных единицах валюты (копейках).
+// Хранится целым числом, поэтому сложение и вычитание всегда точные, в отличие от float64.
+// В json сериализуется как десятичное число с двумя знаками после запятой (например 10.05),
+// в бд хранится как bigint
+type Amount int64
+
+// Parse разбирает десятичную запись суммы ("10", "10.5", "-0.05") без промежуточного float64.
+// Больше двух знаков после запятой считается ошибкой, а не округляется
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, ErrInvalidAmount
+	}
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	intPart, fracPart, hasDot := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" || hasDot && fracPart == "" || len(fracPart) > precision {
+		return 0, ErrInvalidAmount
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, ErrInvalidAmount
+	}
+	fracPart += strings.Repeat("0", precision-len(fracPart))
+
+	units, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil || units > math.MaxInt64/Scale {
+		return 0, ErrOverflow
+	}
+	minor, _ := strconv.ParseInt(fracPart, 10, 64)
+
+	result := units*Scale + minor
+	if result < 0 {
+		return 0, ErrOverflow
+	}
+	if negative {
+		result = -result
+	}
+	return Amount(result), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String возвращает сумму в десятичном виде, всегда с двумя знаками после запятой
+func (a Amount) String() string {
+	sign := ""
+	v := uint64(a)
+	if a < 0 {
+		sign = "-"
+		v = uint64(-a)
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/Scale, v%Scale)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON принимает как число (10.05), так и строку ("10.05")
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	s = strings.Trim(s, `"`)
+	if strings.ContainsAny(s, "eE") {
+		return ErrInvalidAmount
+	}
+	amount, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// Value сумма хранится в бд в копейках (bigint)
+func (a Amount) Value() (driver.Value, error) {
+	return int64(a), nil
+}
+
+func (a *Amount) Scan(src any) error {
+	switch v := src.(type) {
+	case int64:
+		*a = Amount(v)
+	case nil:
+		*a = 0
+	default:
+		return fmt.Errorf("money: cannot scan %T into Amount", src)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+		err  error
+	}{
+		{in: "10", want: 1000},
+		{in: "10.5", want: 1050},
+		{in: "10.05", want: 1005},
+		{in: "0.01", want: 1},
+		{in: ".5", want: 50},
+		{in: "007.10", want: 710},
+		{in: " 10.5\t", want: 1050},
+
+		// знак
+		{in: "+1", want: 100},
+		{in: "-1", want: -100},
+		{in: "-0.05", want: -5},
+		{in: "-.5", want: -50},
+		{in: "-0", want: 0},
+		{in: "-", err: ErrInvalidAmount},
+		{in: "+", err: ErrInvalidAmount},
+		{in: "--1", err: ErrInvalidAmount},
+		{in: "+-1", err: ErrInvalidAmount},
+		{in: "1-", err: ErrInvalidAmount},
+
+		// больше двух знаков не округляется
+		{in: "1.234", err: ErrInvalidAmount},
+		{in: "1.000", err: ErrInvalidAmount},
+		{in: "0.001", err: ErrInvalidAmount},
+
+		// пустые и кривые записи
+		{in: "", err: ErrInvalidAmount},
+		{in: "   ", err: ErrInvalidAmount},
+		{in: ".", err: ErrInvalidAmount},
+		{in: "1.", err: ErrInvalidAmount},
+		{in: "1,5", err: ErrInvalidAmount},
+		{in: "1e2", err: ErrInvalidAmount},
+		{in: "1 000", err: ErrInvalidAmount},
+		{in: "0x10", err: ErrInvalidAmount},
+		{in: "1.-5", err: ErrInvalidAmount},
+
+		// границы int64
+		{in: "92233720368547758.07", want: math.MaxInt64},
+		{in: "-92233720368547758.07", want: -math.MaxInt64},
+		{in: "92233720368547758.08", err: ErrOverflow},
+		{in: "-92233720368547758.08", err: ErrOverflow},
+		{in: "92233720368547759", err: ErrOverflow},
+		{in: "99999999999999999999", err: ErrOverflow},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Parse(%q) error = %v, want %v", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) unexpected error: %s", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{in: 0, want: "0.00"},
+		{in: 1, want: "0.01"},
+		{in: 1005, want: "10.05"},
+		{in: 1050, want: "10.50"},
+		{in: -1, want: "-0.01"},
+		{in: -5, want: "-0.05"},
+		{in: -50, want: "-0.50"},
+		{in: -99, want: "-0.99"},
+		{in: -100, want: "-1.00"},
+		{in: math.MaxInt64, want: "92233720368547758.07"},
+		{in: math.MinInt64, want: "-92233720368547758.08"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	type body struct {
+		Amount Amount `json:"amount"`
+	}
+
+	for _, amount := range []Amount{0, 1, -5, -50, 1005, -100_000, math.MaxInt64} {
+		data, err := json.Marshal(body{Amount: amount})
+		if err != nil {
+			t.Fatalf("marshal %d: %s", int64(amount), err)
+		}
+		var got body
+		if err = json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("unmarshal %s: %s", data, err)
+		}
+		if got.Amount != amount {
+			t.Errorf("round-trip %d via %s = %d", int64(amount), data, int64(got.Amount))
+		}
+	}
+
+	tests := []struct {
+		in   string
+		want Amount
+		err  bool
+	}{
+		{in: `{"amount":10.05}`, want: 1005},
+		{in: `{"amount":"10.05"}`, want: 1005},
+		{in: `{"amount":-0.5}`, want: -50},
+		{in: `{"amount":null}`, want: 0},
+		{in: `{"amount":1e2}`, err: true},
+		{in: `{"amount":10.005}`, err: true},
+		{in: `{"amount":""}`, err: true},
+	}
+	for _, tt := range tests {
+		var got body
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if tt.err {
+			if err == nil {
+				t.Errorf("unmarshal %s: expected error, got %d", tt.in, int64(got.Amount))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unmarshal %s: %s", tt.in, err)
+			continue
+		}
+		if got.Amount != tt.want {
+			t.Errorf("unmarshal %s = %d, want %d", tt.in, int64(got.Amount), int64(tt.want))
+		}
+	}
+}
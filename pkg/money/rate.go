@@ -0,0 +1,111 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency валюта, в которой считаются суммы, если клиент не указал другую
+const DefaultCurrency = "RUB"
+
+// RateScale точность курса - 8 знаков после запятой
+const (
+	RateScale     = 100_000_000
+	ratePrecision = 8
+)
+
+var ErrInvalidRate = errors.New("invalid exchange rate")
+
+// Rate курс обмена валют с фиксированной точностью (8 знаков после запятой).
+// Показывает, сколько единиц котируемой валюты дают за одну единицу базовой.
+// В бд хранится как numeric, в json - как десятичное число
+type Rate int64
+
+func ParseRate(s string) (Rate, error) {
+	s = strings.TrimSpace(s)
+	intPart, fracPart, hasDot := strings.Cut(s, ".")
+	if intPart == "" || hasDot && fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, ErrInvalidRate
+	}
+	// лишние нули в конце допустимы (numeric из бд может прийти с хвостом)
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > ratePrecision {
+		return 0, ErrInvalidRate
+	}
+	fracPart += strings.Repeat("0", ratePrecision-len(fracPart))
+
+	units, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil || units > math.MaxInt64/RateScale {
+		return 0, ErrInvalidRate
+	}
+	frac, _ := strconv.ParseInt(fracPart, 10, 64)
+	return Rate(units*RateScale + frac), nil
+}
+
+func (r Rate) String() string {
+	s := fmt.Sprintf("%d.%08d", r/RateScale, r%RateScale)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// Convert переводит сумму по курсу. Округление до копейки - математическое (половина от нуля)
+func (r Rate) Convert(a Amount) Amount {
+	product := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(int64(r)))
+	quo, rem := new(big.Int).QuoRem(product, big.NewInt(RateScale), new(big.Int))
+	if new(big.Int).Abs(rem).Cmp(big.NewInt(RateScale/2)) >= 0 {
+		if product.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	return Amount(quo.Int64())
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	rate, err := ParseRate(strings.Trim(s, `"`))
+	if err != nil {
+		return err
+	}
+	*r = rate
+	return nil
+}
+
+// Value курс передается в бд строкой, чтобы numeric не терял точность
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+func (r *Rate) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+		*r = 0
+		return nil
+	default:
+		return fmt.Errorf("money: cannot scan %T into Rate", src)
+	}
+	rate, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = rate
+	return nil
+}
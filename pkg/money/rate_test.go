@@ -0,0 +1,39 @@
+package money
+
+import "testing"
+
+func TestRateConvert(t *testing.T) {
+	tests := []struct {
+		name   string
+		rate   string
+		amount Amount
+		want   Amount
+	}{
+		{name: "identity", rate: "1", amount: 1005, want: 1005},
+		{name: "whole rate", rate: "90", amount: 150, want: 13500},
+		{name: "fraction rate", rate: "1.5", amount: 100, want: 150},
+		{name: "rub to usd", rate: "0.01092345", amount: 1_000_000, want: 10923},
+		{name: "zero amount", rate: "0.01092345", amount: 0, want: 0},
+
+		// половина копейки округляется от нуля
+		{name: "half up", rate: "0.5", amount: 1, want: 1},
+		{name: "one and half up", rate: "0.5", amount: 3, want: 2},
+		{name: "half down for negative", rate: "0.5", amount: -1, want: -1},
+		{name: "just below half", rate: "0.49999999", amount: 1, want: 0},
+		{name: "just above half", rate: "0.50000001", amount: 1, want: 1},
+		{name: "half of big amount", rate: "0.00000005", amount: 10_000_000, want: 1},
+		{name: "below half of big amount", rate: "0.00000004", amount: 10_000_000, want: 0},
+
+		{name: "round up to next unit", rate: "0.00999999", amount: 100, want: 1},
+		{name: "negative rounds down", rate: "1.23456789", amount: -100, want: -123},
+	}
+	for _, tt := range tests {
+		rate, err := ParseRate(tt.rate)
+		if err != nil {
+			t.Fatalf("%s: parse rate %q: %s", tt.name, tt.rate, err)
+		}
+		if got := rate.Convert(tt.amount); got != tt.want {
+			t.Errorf("%s: %s.Convert(%d) = %d, want %d", tt.name, tt.rate, int64(tt.amount), int64(got), int64(tt.want))
+		}
+	}
+}
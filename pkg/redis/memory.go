@@ -0,0 +1,223 @@
+package redis
+
+import (
+	"container/list"
+	"context"
+	"encoding"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultMaxEntries = 100_000
+
+type MemoryOption func(m *memory)
+
+// MaxEntries максимальное количество ключей, при превышении вытесняются давно не использованные
+func MaxEntries(n int) MemoryOption {
+	return func(m *memory) {
+		if n > 0 {
+			m.maxEntries = n
+		}
+	}
+}
+
+type entry struct {
+	key       string
+	value     string
+	expiresAt time.Time // нулевое значение - без срока жизни
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// memory кэш в памяти процесса с тем же интерфейсом, что и redis. Подходит для локального запуска и тестов,
+// но не для нескольких реплик - у каждой будет свой кэш. Просроченные ключи удаляются при обращении
+// и при вытеснении, отдельного фонового процесса нет
+type memory struct {
+	mu         sync.Mutex
+	maxEntries int
+	items      map[string]*list.Element
+	lru        *list.List // в начале - последние использованные
+	now        func() time.Time
+}
+
+func NewMemory(opts ...MemoryOption) Redis {
+	m := &memory{
+		maxEntries: defaultMaxEntries,
+		items:      make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+	for _, option := range opts {
+		option(m)
+	}
+	return m
+}
+
+func (m *memory) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	s, err := formatValue(value)
+	if err != nil {
+		return redis.NewStatusResult("", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(key, s, expiration)
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (m *memory) Get(ctx context.Context, key string) *redis.StringCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.get(key)
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(e.value, nil)
+}
+
+func (m *memory) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for _, key := range keys {
+		if _, ok := m.get(key); ok {
+			m.remove(m.items[key])
+			deleted++
+		}
+	}
+	return redis.NewIntResult(deleted, nil)
+}
+
+func (m *memory) Exists(ctx context.Context, keys ...string) *redis.IntCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var found int64
+	for _, key := range keys {
+		if _, ok := m.get(key); ok {
+			found++
+		}
+	}
+	return redis.NewIntResult(found, nil)
+}
+
+// SetNewer то же, что и у redis: значение хранится как "version:value" и не перезаписывает более новую версию
+func (m *memory) SetNewer(ctx context.Context, key string, version int64, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	s, err := formatValue(value)
+	if err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.get(key); ok {
+		current, _, _ := strings.Cut(e.value, ":")
+		if v, err := strconv.ParseInt(current, 10, 64); err == nil && v >= version {
+			return redis.NewBoolResult(false, nil)
+		}
+	}
+	m.set(key, strconv.FormatInt(version, 10)+":"+s, expiration)
+	return redis.NewBoolResult(true, nil)
+}
+
+func (m *memory) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items = make(map[string]*list.Element)
+	m.lru.Init()
+}
+
+// Поиск живого ключа. Просроченный ключ удаляется, найденный становится последним использованным
+func (m *memory) get(key string) (*entry, bool) {
+	el, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if e.expired(m.now()) {
+		m.remove(el)
+		return nil, false
+	}
+	m.lru.MoveToFront(el)
+	return e, true
+}
+
+func (m *memory) set(key, value string, expiration time.Duration) {
+	var expiresAt time.Time
+	if expiration > 0 {
+		expiresAt = m.now().Add(expiration)
+	}
+	if el, ok := m.items[key]; ok {
+		e := el.Value.(*entry)
+		if expiration == redis.KeepTTL && !e.expired(m.now()) {
+			expiresAt = e.expiresAt
+		}
+		e.value, e.expiresAt = value, expiresAt
+		m.lru.MoveToFront(el)
+		return
+	}
+	m.items[key] = m.lru.PushFront(&entry{key: key, value: value, expiresAt: expiresAt})
+	m.evict()
+}
+
+// Вытеснение при превышении лимита: сначала просроченные ключи с конца списка, потом самые старые по использованию
+func (m *memory) evict() {
+	now := m.now()
+	for el := m.lru.Back(); el != nil && m.lru.Len() > m.maxEntries; {
+		prev := el.Prev()
+		if el.Value.(*entry).expired(now) {
+			m.remove(el)
+		}
+		el = prev
+	}
+	for m.lru.Len() > m.maxEntries {
+		m.remove(m.lru.Back())
+	}
+}
+
+func (m *memory) remove(el *list.Element) {
+	m.lru.Remove(el)
+	delete(m.items, el.Value.(*entry).key)
+}
+
+// Приведение значения к строке так же, как это делает клиент redis при отправке команды
+func formatValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case int32, int16, int8, uint, uint64, uint32, uint16, uint8:
+		return fmt.Sprint(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return strconv.FormatInt(v.Nanoseconds(), 10), nil
+	case encoding.BinaryMarshaler:
+		b, err := v.MarshalBinary()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("redis: can't marshal %T (implement encoding.BinaryMarshaler)", value)
+	}
+}
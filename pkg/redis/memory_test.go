@@ -0,0 +1,134 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+// Кэш с ручными часами: время двигается только через advance
+func newTestMemory(t *testing.T, opts ...MemoryOption) (*memory, func(time.Duration)) {
+	t.Helper()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMemory(opts...).(*memory)
+	m.now = func() time.Time { return now }
+	return m, func(d time.Duration) { now = now.Add(d) }
+}
+
+func assertValue(t *testing.T, m *memory, key, want string) {
+	t.Helper()
+	got, err := m.Get(context.Background(), key).Result()
+	if err != nil {
+		t.Fatalf("get %q: %s", key, err)
+	}
+	if got != want {
+		t.Fatalf("get %q = %q, want %q", key, got, want)
+	}
+}
+
+func assertMissing(t *testing.T, m *memory, key string) {
+	t.Helper()
+	if err := m.Get(context.Background(), key).Err(); !errors.Is(err, redis.Nil) {
+		t.Fatalf("get %q: error %v, want redis.Nil", key, err)
+	}
+}
+
+func TestMemoryEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestMemory(t, MaxEntries(2))
+
+	m.Set(ctx, "a", "1", 0)
+	m.Set(ctx, "b", "2", 0)
+	assertValue(t, m, "a", "1") // a использован позже b
+	m.Set(ctx, "c", "3", 0)
+
+	assertMissing(t, m, "b")
+	assertValue(t, m, "a", "1")
+	assertValue(t, m, "c", "3")
+}
+
+func TestMemoryEvictsExpiredFirst(t *testing.T) {
+	ctx := context.Background()
+	m, advance := newTestMemory(t, MaxEntries(2))
+
+	m.Set(ctx, "a", "1", 0)
+	m.Set(ctx, "b", "2", time.Second)
+	advance(time.Second)
+	m.Set(ctx, "c", "3", 0)
+
+	// a использован раньше всех, но вытесняется просроченный b
+	assertValue(t, m, "a", "1")
+	assertValue(t, m, "c", "3")
+	if m.lru.Len() != 2 {
+		t.Fatalf("entries = %d, want 2", m.lru.Len())
+	}
+}
+
+func TestMemoryExpiration(t *testing.T) {
+	ctx := context.Background()
+	m, advance := newTestMemory(t)
+
+	m.Set(ctx, "ttl", "1", time.Minute)
+	m.Set(ctx, "forever", "2", 0)
+
+	advance(time.Minute - time.Nanosecond)
+	assertValue(t, m, "ttl", "1")
+
+	advance(time.Nanosecond)
+	assertMissing(t, m, "ttl")
+	assertValue(t, m, "forever", "2")
+	if n := m.Exists(ctx, "ttl", "forever").Val(); n != 1 {
+		t.Fatalf("exists = %d, want 1", n)
+	}
+}
+
+func TestMemoryKeepTTL(t *testing.T) {
+	ctx := context.Background()
+	m, advance := newTestMemory(t)
+
+	m.Set(ctx, "key", "1", time.Minute)
+	advance(time.Second * 30)
+	m.Set(ctx, "key", "2", redis.KeepTTL)
+
+	advance(time.Second * 29)
+	assertValue(t, m, "key", "2")
+	advance(time.Second)
+	assertMissing(t, m, "key")
+}
+
+func TestMemorySetNewer(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestMemory(t)
+
+	if ok := m.SetNewer(ctx, "balance", 2, "200", 0).Val(); !ok {
+		t.Fatal("first version is not set")
+	}
+	if ok := m.SetNewer(ctx, "balance", 1, "100", 0).Val(); ok {
+		t.Fatal("older version overwrote newer")
+	}
+	if ok := m.SetNewer(ctx, "balance", 2, "300", 0).Val(); ok {
+		t.Fatal("same version overwrote stored value")
+	}
+	assertValue(t, m, "balance", "2:200")
+
+	if ok := m.SetNewer(ctx, "balance", 3, "300", 0).Val(); !ok {
+		t.Fatal("newer version is not set")
+	}
+	assertValue(t, m, "balance", "3:300")
+}
+
+func TestMemorySetNewerAfterExpiration(t *testing.T) {
+	ctx := context.Background()
+	m, advance := newTestMemory(t)
+
+	m.SetNewer(ctx, "balance", 5, "500", time.Minute)
+	advance(time.Minute)
+
+	// просроченная версия не мешает записать более старую
+	if ok := m.SetNewer(ctx, "balance", 1, "100", 0).Val(); !ok {
+		t.Fatal("version is not set over expired key")
+	}
+	assertValue(t, m, "balance", "1:100")
+}
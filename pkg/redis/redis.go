@@ -3,6 +3,7 @@ package redis
 import (
 	"context"
 	"github.com/redis/go-redis/v9"
+	"strconv"
 	"time"
 )
 
@@ -17,6 +18,7 @@ type Redis interface {
 	Get(ctx context.Context, key string) *redis.StringCmd
 	Del(ctx context.Context, keys ...string) *redis.IntCmd
 	Exists(ctx context.Context, keys ...string) *redis.IntCmd
+	SetNewer(ctx context.Context, key string, version int64, value interface{}, expiration time.Duration) *redis.BoolCmd
 	Close()
 }
 
@@ -37,6 +39,26 @@ func NewRedis(url string, opts ...Option) Redis {
 	return &rdb{redis.NewClient(rdbOpts)}
 }
 
+// Значение хранится как "version:value". Записывается, только если в ключе нет значения с такой же или более новой версией
+var setNewerScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current then
+	local version = tonumber(string.match(current, '^(%d+):'))
+	if version and version >= tonumber(ARGV[1]) then
+		return 0
+	end
+end
+redis.call('SET', KEYS[1], ARGV[1] .. ':' .. ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+
+// SetNewer версионная запись: старое значение не перезапишет более новое, даже если реплики пишут одновременно.
+// Возвращает true, если значение записано
+func (r *rdb) SetNewer(ctx context.Context, key string, version int64, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	written, err := setNewerScript.Run(ctx, r.Client, []string{key}, strconv.FormatInt(version, 10), value, expiration.Milliseconds()).Int()
+	return redis.NewBoolResult(written == 1, err)
+}
+
 func (r *rdb) Close() {
 	_ = r.Client.Close()
 }
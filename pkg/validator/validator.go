@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-playground/validator/v10"
+	"reflect"
 )
 
 type Validator struct {
@@ -34,6 +35,15 @@ func validateError(err validator.FieldError) error {
 	}
 }
 
+// Суммы передаются как money.Amount (целое число копеек), но на всякий случай поддерживается и float
 func amountValidate(fl validator.FieldLevel) bool {
-	return fl.Field().Float() > 0
+	field := fl.Field()
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return field.Int() > 0
+	case reflect.Float32, reflect.Float64:
+		return field.Float() > 0
+	default:
+		return false
+	}
 }
@@ -0,0 +1,118 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ContentType mime тип xlsx файла
+const ContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// Cell ячейка таблицы. Числа пишутся как числа, чтобы их можно было складывать в табличном редакторе,
+// остальное - как строки
+type Cell struct {
+	Value  string
+	Number bool
+}
+
+// String строковая ячейка
+func String(s string) Cell {
+	return Cell{Value: s}
+}
+
+// Number числовая ячейка, s - десятичная запись числа с точкой ("10", "-0.05")
+func Number(s string) Cell {
+	return Cell{Value: s, Number: true}
+}
+
+// Int числовая ячейка с целым числом
+func Int(i int) Cell {
+	return Number(strconv.Itoa(i))
+}
+
+// Write пишет в w книгу из одного листа sheet со строками rows. Это минимальный SpreadsheetML без стилей
+// и общих строк: строки пишутся прямо в ячейки (inlineStr), этого достаточно для выгрузки таблиц
+func Write(w io.Writer, sheet string, rows [][]Cell) error {
+	z := zip.NewWriter(w)
+
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"[Content_Types].xml", []byte(contentTypes)},
+		{"_rels/.rels", []byte(rootRels)},
+		{"xl/workbook.xml", []byte(fmt.Sprintf(workbook, escape(sheet)))},
+		{"xl/_rels/workbook.xml.rels", []byte(workbookRels)},
+		{"xl/worksheets/sheet1.xml", worksheet(rows)},
+	}
+	for _, file := range files {
+		f, err := z.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err = f.Write(file.content); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+func worksheet(rows [][]Cell) []byte {
+	b := &bytes.Buffer{}
+	b.WriteString(xml.Header)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range rows {
+		fmt.Fprintf(b, `<row r="%d">`, i+1)
+		for j, cell := range row {
+			ref := column(j) + strconv.Itoa(i+1)
+			switch {
+			case cell.Value == "":
+			case cell.Number:
+				fmt.Fprintf(b, `<c r="%s"><v>%s</v></c>`, ref, escape(cell.Value))
+			default:
+				fmt.Fprintf(b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, escape(cell.Value))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.Bytes()
+}
+
+// Буквенное имя колонки по номеру с нуля: A, B, ..., Z, AA, AB, ...
+func column(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func escape(s string) string {
+	b := &bytes.Buffer{}
+	_ = xml.EscapeText(b, []byte(s))
+	return b.String()
+}
+
+const contentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`</Types>`
+
+const rootRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+const workbook = xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+	`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+	`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+const workbookRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+	`</Relationships>`